@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Options struct {
+	RunAddress         string
+	DatabaseURI        string
+	LogsLevel          string
+	RateLimit          int
+	ProcessingInterval time.Duration
+	ShutdownTimeout    time.Duration
+}
+
+// Validate возвращает все найденные ошибки настроек сразу.
+func (o *Options) Validate() error {
+	var errs []error
+	if o.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate limit must not be negative, got %d", o.RateLimit))
+	}
+	if o.ProcessingInterval <= 0 {
+		errs = append(errs, fmt.Errorf("processing interval must be positive, got %s", o.ProcessingInterval))
+	}
+	if o.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown timeout must be positive, got %s", o.ShutdownTimeout))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,19 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	valid := Options{ProcessingInterval: time.Second, ShutdownTimeout: time.Second}
+	assert.NoError(t, valid.Validate())
+
+	invalid := Options{RateLimit: -1}
+	err := invalid.Validate()
+	assert.ErrorContains(t, err, "rate limit must not be negative, got -1")
+	assert.ErrorContains(t, err, "processing interval must be positive")
+	assert.ErrorContains(t, err, "shutdown timeout must be positive")
+}
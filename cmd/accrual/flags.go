@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/PaBah/gofermart/cmd/accrual/config"
+)
+
+// ParseFlags заполняет options из флагов и окружения; переменные окружения важнее флагов.
+func ParseFlags(options *config.Options) error {
+	var specified bool
+	var runAddress, databaseURI, logsLevel, rateLimit string
+
+	flag.StringVar(&options.RunAddress, "a", ":8080", "host:port on which server run")
+	flag.StringVar(&options.DatabaseURI, "d", "", "database DSN address, in-memory storage when empty")
+	flag.StringVar(&options.LogsLevel, "l", "info", "logs level")
+	flag.IntVar(&options.RateLimit, "rate-limit", 0, "max GET /api/orders/{number} requests per minute, 0 means unlimited")
+	flag.DurationVar(&options.ProcessingInterval, "processing-interval", time.Second, "pause between accrual calculations")
+	flag.DurationVar(&options.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "time to drain in-flight requests on shutdown")
+	flag.Parse()
+
+	runAddress, specified = os.LookupEnv("RUN_ADDRESS")
+	if specified {
+		options.RunAddress = runAddress
+	}
+
+	databaseURI, specified = os.LookupEnv("DATABASE_URI")
+	if specified {
+		options.DatabaseURI = databaseURI
+	}
+
+	logsLevel, specified = os.LookupEnv("LOG_LEVEL")
+	if specified {
+		options.LogsLevel = logsLevel
+	}
+
+	rateLimit, specified = os.LookupEnv("RATE_LIMIT")
+	if specified {
+		limit, err := strconv.Atoi(rateLimit)
+		if err != nil {
+			return fmt.Errorf("RATE_LIMIT: %q is not an integer", rateLimit)
+		}
+		options.RateLimit = limit
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/PaBah/gofermart/cmd/accrual/config"
+	"github.com/PaBah/gofermart/cmd/accrual/processor"
+	"github.com/PaBah/gofermart/cmd/accrual/server"
+	"github.com/PaBah/gofermart/cmd/accrual/storage"
+	"github.com/PaBah/gofermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+// readHeaderTimeout защищает сервер от клиентов, которые не дописывают заголовки запроса.
+const readHeaderTimeout = 10 * time.Second
+
+func main() {
+	options := &config.Options{}
+	err := ParseFlags(options)
+	if err == nil {
+		err = options.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+
+	if err := logger.Initialize(options.LogsLevel); err != nil {
+		fmt.Printf("Logger can not be initialized %s", err)
+		return
+	}
+
+	logger.Log().Info("Start accrual server on", zap.String("address", options.RunAddress))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var store storage.Repository
+	var dbStore *storage.DBStorage
+	if options.DatabaseURI == "" {
+		store = storage.NewMemoryStorage()
+	} else {
+		opened, err := storage.NewDBStorage(ctx, options.DatabaseURI)
+		if err != nil {
+			logger.Log().Error("Database error with start", zap.Error(err))
+			return
+		}
+		dbStore = &opened
+		store = dbStore
+	}
+
+	// Расчёт останавливается по ctx; хранилище закрывается только после него
+	var processing sync.WaitGroup
+	processing.Add(1)
+	go func() {
+		defer processing.Done()
+		processor.NewProcessor(store, options.ProcessingInterval).Run(ctx)
+	}()
+
+	httpServer := &http.Server{
+		Addr:              options.RunAddress,
+		Handler:           server.NewRouter(options, store),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Log().Error("Server crashed with error: ", zap.Error(err))
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	// Повторный сигнал завершит процесс сразу, не дожидаясь остановки
+	stop()
+	logger.Log().Info("Shutting down", zap.Duration("timeout", options.ShutdownTimeout))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), options.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Log().Error("In-flight requests were not drained", zap.Error(err))
+	}
+	processing.Wait()
+	if dbStore != nil {
+		if err := dbStore.Close(); err != nil {
+			logger.Log().Error("Database can not be closed", zap.Error(err))
+		}
+	}
+	logger.Log().Info("Server stopped")
+}
@@ -0,0 +1,31 @@
+package models
+
+import "github.com/PaBah/gofermart/internal/models"
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusInvalid    = "INVALID"
+	StatusProcessing = "PROCESSING"
+	StatusProcessed  = "PROCESSED"
+
+	RewardTypePercent = "%"
+	RewardTypePoints  = "pt"
+)
+
+type Good struct {
+	Description string       `json:"description"`
+	Price       models.Money `json:"price"`
+}
+
+type Order struct {
+	Number  string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual models.Money `json:"accrual,omitempty"`
+	Goods   []Good       `json:"-"`
+}
+
+type Reward struct {
+	Match      string       `json:"match"`
+	Reward     models.Money `json:"reward"`
+	RewardType string       `json:"reward_type"`
+}
@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+	"github.com/PaBah/gofermart/cmd/accrual/storage"
+	"github.com/PaBah/gofermart/internal/logger"
+	moneymodels "github.com/PaBah/gofermart/internal/models"
+	"go.uber.org/zap"
+)
+
+const batchSize = 100
+
+type Processor struct {
+	storage  storage.Repository
+	interval time.Duration
+}
+
+// matchReward выбирает механику с самым длинным совпадением по описанию товара.
+func matchReward(description string, rewards []models.Reward) (reward models.Reward, ok bool) {
+	for _, candidate := range rewards {
+		if strings.Contains(description, candidate.Match) && len(candidate.Match) > len(reward.Match) {
+			reward, ok = candidate, true
+		}
+	}
+	return
+}
+
+// Calculate считает начисление по заказу. Заказ без единого подходящего товара признаётся INVALID.
+func Calculate(order models.Order, rewards []models.Reward) models.Order {
+	matched := false
+	var accrual moneymodels.Money
+	for _, good := range order.Goods {
+		reward, ok := matchReward(good.Description, rewards)
+		if !ok {
+			continue
+		}
+
+		matched = true
+		switch reward.RewardType {
+		case models.RewardTypePercent:
+			// price и reward хранятся в сотых долях, поэтому делим на 100*100 с округлением
+			accrual += (good.Price*reward.Reward + 5000) / 10000
+		case models.RewardTypePoints:
+			accrual += reward.Reward
+		}
+	}
+
+	order.Accrual = accrual
+	order.Status = models.StatusProcessed
+	if !matched {
+		order.Status = models.StatusInvalid
+	}
+	return order
+}
+
+func (p Processor) processBatch(ctx context.Context) {
+	orders, err := p.storage.GetRegisteredOrders(ctx, batchSize)
+	if err != nil {
+		logger.Log().Error("can not load registered orders", zap.Error(err))
+		return
+	}
+	if len(orders) == 0 {
+		return
+	}
+
+	rewards, err := p.storage.GetRewards(ctx)
+	if err != nil {
+		logger.Log().Error("can not load rewards", zap.Error(err))
+		return
+	}
+
+	for _, order := range orders {
+		order.Status = models.StatusProcessing
+		err = p.storage.UpdateOrder(ctx, order)
+		if err != nil {
+			logger.Log().Error("can not update order number="+order.Number, zap.Error(err))
+			continue
+		}
+
+		err = p.storage.UpdateOrder(ctx, Calculate(order, rewards))
+		if err != nil {
+			logger.Log().Error("can not update order number="+order.Number, zap.Error(err))
+		}
+	}
+}
+
+// Run рассчитывает зарегистрированные заказы каждые interval, пока не отменён ctx.
+func (p Processor) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.processBatch(ctx)
+		}
+	}
+}
+
+func NewProcessor(storage storage.Repository, interval time.Duration) Processor {
+	return Processor{storage: storage, interval: interval}
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+	"github.com/PaBah/gofermart/cmd/accrual/storage"
+	moneymodels "github.com/PaBah/gofermart/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculate(t *testing.T) {
+	rewards := []models.Reward{
+		{Match: "Bork", Reward: 1000, RewardType: models.RewardTypePercent},
+		{Match: "Чайник Bork", Reward: 1500, RewardType: models.RewardTypePercent},
+		{Match: "LG", Reward: 50000, RewardType: models.RewardTypePoints},
+	}
+
+	testCases := []struct {
+		name            string
+		goods           []models.Good
+		expectedStatus  string
+		expectedAccrual moneymodels.Money
+	}{
+		{name: "percent", goods: []models.Good{{Description: "Утюг Bork", Price: 700000}}, expectedStatus: models.StatusProcessed, expectedAccrual: 70000},
+		{name: "longest match", goods: []models.Good{{Description: "Чайник Bork", Price: 700000}}, expectedStatus: models.StatusProcessed, expectedAccrual: 105000},
+		{name: "points", goods: []models.Good{{Description: "Телевизор LG", Price: 4000000}}, expectedStatus: models.StatusProcessed, expectedAccrual: 50000},
+		{name: "rounding", goods: []models.Good{{Description: "Bork", Price: 5}}, expectedStatus: models.StatusProcessed, expectedAccrual: 1},
+		{name: "mixed", goods: []models.Good{{Description: "Утюг Bork", Price: 1000}, {Description: "Хлеб", Price: 5000}}, expectedStatus: models.StatusProcessed, expectedAccrual: 100},
+		{name: "no match", goods: []models.Good{{Description: "Хлеб", Price: 5000}}, expectedStatus: models.StatusInvalid},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			order := Calculate(models.Order{Number: "1", Goods: tc.goods}, rewards)
+			assert.Equal(t, tc.expectedStatus, order.Status)
+			assert.Equal(t, tc.expectedAccrual, order.Accrual)
+		})
+	}
+}
+
+func TestProcessor_Run(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, store.RegisterReward(ctx, models.Reward{Match: "Bork", Reward: 1000, RewardType: models.RewardTypePercent}))
+	assert.NoError(t, store.RegisterOrder(ctx, models.Order{Number: "12345678903", Goods: []models.Good{{Description: "Чайник Bork", Price: 700000}}}))
+
+	go NewProcessor(store, 5*time.Millisecond).Run(ctx)
+
+	assert.Eventually(t, func() bool {
+		order, _ := store.GetOrder(ctx, "12345678903")
+		return order.Status == models.StatusProcessed
+	}, time.Second, 5*time.Millisecond, "Order processed")
+	order, _ := store.GetOrder(ctx, "12345678903")
+	assert.Equal(t, moneymodels.Money(70000), order.Accrual)
+}
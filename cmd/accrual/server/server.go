@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/PaBah/gofermart/cmd/accrual/config"
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+	"github.com/PaBah/gofermart/cmd/accrual/storage"
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/problem"
+	"github.com/PaBah/gofermart/internal/utils"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+type (
+	RegisterOrderRequest struct {
+		Order string        `json:"order"`
+		Goods []models.Good `json:"goods"`
+	}
+
+	// requestsLimiter — ограничение числа запросов в минуту из контракта GET /api/orders/{number}.
+	requestsLimiter struct {
+		mu          sync.Mutex
+		limit       int
+		windowStart time.Time
+		count       int
+	}
+)
+
+func (rl *requestsLimiter) Allow(now time.Time) bool {
+	if rl.limit <= 0 {
+		return true
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.windowStart) >= time.Minute {
+		rl.windowStart = now
+		rl.count = 0
+	}
+	rl.count++
+	return rl.count <= rl.limit
+}
+
+type Server struct {
+	options *config.Options
+	storage storage.Repository
+	limiter *requestsLimiter
+}
+
+func (s Server) registerOrderHandle(res http.ResponseWriter, req *http.Request) {
+	requestData := &RegisterOrderRequest{}
+	err := json.NewDecoder(req.Body).Decode(requestData)
+	if err != nil {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidJSON, "Request body is not valid JSON")
+		return
+	}
+
+	if utils.ValidateLuhn(requestData.Order) != nil || len(requestData.Goods) == 0 {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid order")
+		return
+	}
+
+	err = s.storage.RegisterOrder(req.Context(), models.Order{Number: requestData.Order, Goods: requestData.Goods})
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		http.Error(res, "Order already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func (s Server) registerRewardHandle(res http.ResponseWriter, req *http.Request) {
+	reward := models.Reward{}
+	err := json.NewDecoder(req.Body).Decode(&reward)
+	if err != nil {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidJSON, "Request body is not valid JSON")
+		return
+	}
+
+	if reward.Match == "" || reward.Reward <= 0 ||
+		(reward.RewardType != models.RewardTypePercent && reward.RewardType != models.RewardTypePoints) {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid reward")
+		return
+	}
+
+	err = s.storage.RegisterReward(req.Context(), reward)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		http.Error(res, "Reward for such match already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+	res.WriteHeader(http.StatusOK)
+}
+
+func (s Server) getOrderHandle(res http.ResponseWriter, req *http.Request) {
+	if !s.limiter.Allow(time.Now()) {
+		res.Header().Set("Content-Type", "text/plain")
+		res.Header().Set("Retry-After", "60")
+		res.WriteHeader(http.StatusTooManyRequests)
+		_, _ = fmt.Fprintf(res, "No more than %d requests per minute allowed", s.limiter.limit)
+		return
+	}
+
+	order, err := s.storage.GetOrder(req.Context(), chi.URLParam(req, "number"))
+	if errors.Is(err, storage.ErrNotFound) {
+		res.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	response, _ := json.Marshal(order)
+
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(response)
+	if err != nil {
+		logger.Log().Error("Can not send response from GET /api/orders/{number}", zap.Error(err))
+	}
+}
+
+func NewRouter(options *config.Options, storage storage.Repository) *chi.Mux {
+	r := chi.NewRouter()
+
+	s := Server{
+		options: options,
+		storage: storage,
+		limiter: &requestsLimiter{limit: options.RateLimit},
+	}
+	r.Use(logger.LoggerMiddleware)
+
+	r.Post("/api/orders", s.registerOrderHandle)
+	r.Post("/api/goods", s.registerRewardHandle)
+	r.Get("/api/orders/{number}", s.getOrderHandle)
+	return r
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/cmd/accrual/config"
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+	"github.com/PaBah/gofermart/cmd/accrual/storage"
+	"github.com/PaBah/gofermart/internal/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	testCases := []struct {
+		method       string
+		path         string
+		requestBody  string
+		expectedCode int
+		expectedBody string
+	}{
+		//Rewards
+		{method: http.MethodPost, path: "/api/goods", requestBody: `{"match":"Bork","reward":10,"reward_type":"%"}`, expectedCode: http.StatusOK},
+		{method: http.MethodPost, path: "/api/goods", requestBody: `{"match":"Bork","reward":15,"reward_type":"pt"}`, expectedCode: http.StatusConflict},
+		{method: http.MethodPost, path: "/api/goods", requestBody: `{"match":"LG","reward":15,"reward_type":"x"}`, expectedCode: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/api/goods", requestBody: `{"match":"","reward":15,"reward_type":"pt"}`, expectedCode: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/api/goods", requestBody: `{"match":`, expectedCode: http.StatusBadRequest},
+		//Orders
+		{method: http.MethodPost, path: "/api/orders", requestBody: `{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000}]}`, expectedCode: http.StatusAccepted},
+		{method: http.MethodPost, path: "/api/orders", requestBody: `{"order":"12345678903","goods":[{"description":"Чайник Bork","price":7000}]}`, expectedCode: http.StatusConflict},
+		{method: http.MethodPost, path: "/api/orders", requestBody: `{"order":"123","goods":[{"description":"Чайник Bork","price":7000}]}`, expectedCode: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/api/orders", requestBody: `{"order":"2377225624","goods":[]}`, expectedCode: http.StatusBadRequest},
+		//Order state
+		{method: http.MethodGet, path: "/api/orders/12345678903", expectedCode: http.StatusOK, expectedBody: `{"order":"12345678903","status":"REGISTERED"}`},
+		{method: http.MethodGet, path: "/api/orders/2377225624", expectedCode: http.StatusNoContent},
+	}
+
+	sh := NewRouter(&config.Options{}, storage.NewMemoryStorage())
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.requestBody))
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, w.Body.String(), "Тело ответа не совпадает с ожидаемым")
+			}
+		})
+	}
+}
+
+func TestServer_ProcessedOrder(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	_ = store.RegisterOrder(context.Background(), models.Order{Number: "12345678903"})
+	_ = store.UpdateOrder(context.Background(), models.Order{Number: "12345678903", Status: models.StatusProcessed, Accrual: 72998})
+	sh := NewRouter(&config.Options{}, store)
+
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/12345678903", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"order":"12345678903","status":"PROCESSED","accrual":729.98}`, w.Body.String())
+}
+
+// brokenStorage отвечает ошибкой базы на чтение заказа.
+type brokenStorage struct {
+	storage.Repository
+}
+
+func (brokenStorage) GetOrder(context.Context, string) (models.Order, error) {
+	return models.Order{}, errors.New("pq: connection to 10.0.0.5 refused")
+}
+
+func TestServer_Problems(t *testing.T) {
+	sh := NewRouter(&config.Options{}, brokenStorage{})
+
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/orders", strings.NewReader(`{"order":12}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	p := problem.Problem{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, problem.CodeInvalidJSON, p.Code)
+	assert.NotContains(t, w.Body.String(), "unmarshal", "Decoder error is not sent to client")
+
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/12345678903", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "10.0.0.5", "Database error is not sent to client")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, problem.CodeInternal, p.Code)
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	sh := NewRouter(&config.Options{RateLimit: 2}, storage.NewMemoryStorage())
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/12345678903", nil))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/12345678903", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Equal(t, "No more than 2 requests per minute allowed", w.Body.String())
+}
+
+func TestRequestsLimiter_Window(t *testing.T) {
+	rl := &requestsLimiter{limit: 1}
+	now := time.Now()
+
+	assert.True(t, rl.Allow(now))
+	assert.False(t, rl.Allow(now.Add(time.Second)))
+	assert.True(t, rl.Allow(now.Add(time.Minute)), "New window resets the counter")
+}
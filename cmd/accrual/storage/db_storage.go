@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+type DBStorage struct {
+	db *sql.DB
+}
+
+func (ds *DBStorage) initialize(ctx context.Context, databaseDSN string) (err error) {
+	ds.db, err = sql.Open("pgx", databaseDSN)
+	if err != nil {
+		return
+	}
+
+	driver, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return err
+	}
+
+	// Отдельная таблица версий: accrual может работать в одной базе с gophermart
+	d, err := postgres.WithInstance(ds.db, &postgres.Config{MigrationsTable: "accrual_schema_migrations"})
+	if err != nil {
+		return err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", driver, "psql_db", d)
+	if err != nil {
+		return err
+	}
+
+	err = m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		err = nil
+	}
+	return
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
+func (ds *DBStorage) RegisterReward(ctx context.Context, reward models.Reward) error {
+	_, err := ds.db.ExecContext(ctx,
+		`INSERT INTO accrual_rewards(match, reward, reward_type) VALUES ($1, $2, $3)`, reward.Match, reward.Reward, reward.RewardType)
+	if isUniqueViolation(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+func (ds *DBStorage) GetRewards(ctx context.Context) (rewards []models.Reward, err error) {
+	rows, err := ds.db.QueryContext(ctx, `SELECT match, reward, reward_type FROM accrual_rewards ORDER BY match`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rewards = make([]models.Reward, 0)
+	var reward models.Reward
+
+	for rows.Next() {
+		err = rows.Scan(&reward.Match, &reward.Reward, &reward.RewardType)
+		if err != nil {
+			return nil, err
+		}
+		rewards = append(rewards, reward)
+	}
+	err = rows.Err()
+	return
+}
+
+func (ds *DBStorage) RegisterOrder(ctx context.Context, order models.Order) error {
+	goods, err := json.Marshal(order.Goods)
+	if err != nil {
+		return err
+	}
+
+	_, err = ds.db.ExecContext(ctx,
+		`INSERT INTO accrual_orders(number, status, goods) VALUES ($1, $2, $3)`, order.Number, models.StatusRegistered, goods)
+	if isUniqueViolation(err) {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+func (ds *DBStorage) GetOrder(ctx context.Context, number string) (order models.Order, err error) {
+	row := ds.db.QueryRowContext(ctx, `SELECT number, status, accrual FROM accrual_orders WHERE number=$1`, number)
+
+	err = row.Scan(&order.Number, &order.Status, &order.Accrual)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return
+}
+
+func (ds *DBStorage) GetRegisteredOrders(ctx context.Context, limit int) (orders []models.Order, err error) {
+	rows, err := ds.db.QueryContext(ctx,
+		`SELECT number, status, goods FROM accrual_orders WHERE status=$1 ORDER BY registered_at LIMIT $2`, models.StatusRegistered, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders = make([]models.Order, 0)
+	var goods []byte
+
+	for rows.Next() {
+		var order models.Order
+		err = rows.Scan(&order.Number, &order.Status, &goods)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(goods, &order.Goods)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	err = rows.Err()
+	return
+}
+
+func (ds *DBStorage) UpdateOrder(ctx context.Context, order models.Order) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE accrual_orders SET status=$1, accrual=$2 WHERE number=$3`, order.Status, order.Accrual, order.Number)
+	return err
+}
+
+func (ds *DBStorage) Close() error {
+	return ds.db.Close()
+}
+
+func NewDBStorage(ctx context.Context, databaseDSN string) (DBStorage, error) {
+	store := DBStorage{}
+	err := store.initialize(ctx, databaseDSN)
+	return store, err
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDBStorage_RegisterOrder(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: db,
+	}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO accrual_orders(number, status, goods) VALUES ($1, $2, $3)")).
+		WithArgs("12345678903", models.StatusRegistered, []byte(`[{"description":"Чайник Bork","price":7000}]`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := ds.RegisterOrder(context.Background(), models.Order{Number: "12345678903", Goods: []models.Good{{Description: "Чайник Bork", Price: 700000}}})
+	assert.NoError(t, err, "Order registered without error")
+}
+
+func TestDBStorage_GetOrder(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: db,
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, status, accrual FROM accrual_orders WHERE number=$1")).
+		WithArgs("12345678903").
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual"}).AddRow("12345678903", "PROCESSED", "729.98"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, status, accrual FROM accrual_orders WHERE number=$1")).
+		WithArgs("2377225624").
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual"}))
+
+	order, err := ds.GetOrder(context.Background(), "12345678903")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Order{Number: "12345678903", Status: "PROCESSED", Accrual: 72998}, order)
+
+	_, err = ds.GetOrder(context.Background(), "2377225624")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDBStorage_GetRegisteredOrders(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: db,
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, status, goods FROM accrual_orders WHERE status=$1 ORDER BY registered_at LIMIT $2")).
+		WithArgs(models.StatusRegistered, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "goods"}).
+			AddRow("12345678903", "REGISTERED", []byte(`[{"description":"Чайник Bork","price":7000}]`)))
+
+	orders, err := ds.GetRegisteredOrders(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Order{{Number: "12345678903", Status: "REGISTERED", Goods: []models.Good{{Description: "Чайник Bork", Price: 700000}}}}, orders)
+}
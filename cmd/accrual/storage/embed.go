@@ -0,0 +1,6 @@
+package storage
+
+import "embed"
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
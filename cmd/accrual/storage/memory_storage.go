@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+)
+
+// MemoryStorage — хранилище для локального запуска без DATABASE_URI и для тестов.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	rewards map[string]models.Reward
+	orders  map[string]models.Order
+	queue   []string
+}
+
+func (ms *MemoryStorage) RegisterReward(ctx context.Context, reward models.Reward) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.rewards[reward.Match]; ok {
+		return ErrAlreadyExists
+	}
+	ms.rewards[reward.Match] = reward
+	return nil
+}
+
+func (ms *MemoryStorage) GetRewards(ctx context.Context) ([]models.Reward, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	rewards := make([]models.Reward, 0, len(ms.rewards))
+	for _, reward := range ms.rewards {
+		rewards = append(rewards, reward)
+	}
+	sort.Slice(rewards, func(i, j int) bool { return rewards[i].Match < rewards[j].Match })
+	return rewards, nil
+}
+
+func (ms *MemoryStorage) RegisterOrder(ctx context.Context, order models.Order) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.orders[order.Number]; ok {
+		return ErrAlreadyExists
+	}
+	order.Status = models.StatusRegistered
+	ms.orders[order.Number] = order
+	ms.queue = append(ms.queue, order.Number)
+	return nil
+}
+
+func (ms *MemoryStorage) GetOrder(ctx context.Context, number string) (models.Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	order, ok := ms.orders[number]
+	if !ok {
+		return models.Order{}, ErrNotFound
+	}
+	return order, nil
+}
+
+func (ms *MemoryStorage) GetRegisteredOrders(ctx context.Context, limit int) ([]models.Order, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orders := make([]models.Order, 0)
+	for _, number := range ms.queue {
+		if len(orders) == limit {
+			break
+		}
+		if order := ms.orders[number]; order.Status == models.StatusRegistered {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (ms *MemoryStorage) UpdateOrder(ctx context.Context, order models.Order) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.orders[order.Number]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Status = order.Status
+	stored.Accrual = order.Accrual
+	ms.orders[order.Number] = stored
+	// В очереди остаются только заказы, ожидающие расчёта
+	if stored.Status != models.StatusRegistered {
+		if i := slices.Index(ms.queue, order.Number); i >= 0 {
+			ms.queue = slices.Delete(ms.queue, i, i+1)
+		}
+	}
+	return nil
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		rewards: make(map[string]models.Reward),
+		orders:  make(map[string]models.Order),
+	}
+}
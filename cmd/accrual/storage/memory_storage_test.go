@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStorage_UpdateOrderTrimsQueue(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryStorage()
+	require.NoError(t, ms.RegisterOrder(ctx, models.Order{Number: "12345678903"}))
+	require.NoError(t, ms.RegisterOrder(ctx, models.Order{Number: "2377225624"}))
+
+	require.NoError(t, ms.UpdateOrder(ctx, models.Order{Number: "12345678903", Status: models.StatusProcessed, Accrual: 500}))
+	assert.Equal(t, []string{"2377225624"}, ms.queue, "Processed order leaves the queue")
+
+	orders, err := ms.GetRegisteredOrders(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, "2377225624", orders[0].Number)
+
+	order, err := ms.GetOrder(ctx, "12345678903")
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProcessed, order.Status, "Order stays available for lookup")
+}
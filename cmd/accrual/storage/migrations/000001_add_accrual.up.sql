@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS accrual_rewards (
+    match VARCHAR PRIMARY KEY,
+    reward NUMERIC NOT NULL,
+    reward_type VARCHAR(2) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS accrual_orders (
+    number VARCHAR PRIMARY KEY,
+    status VARCHAR NOT NULL DEFAULT 'REGISTERED',
+    accrual NUMERIC,
+    goods JSONB NOT NULL,
+    registered_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS accrual_orders_status_idx ON accrual_orders(status);
@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/PaBah/gofermart/cmd/accrual/models"
+)
+
+var (
+	ErrAlreadyExists = errors.New("already exists")
+	ErrNotFound      = errors.New("not found")
+)
+
+type Repository interface {
+	RegisterReward(ctx context.Context, reward models.Reward) error
+	GetRewards(ctx context.Context) ([]models.Reward, error)
+	RegisterOrder(ctx context.Context, order models.Order) error
+	GetOrder(ctx context.Context, number string) (models.Order, error)
+	GetRegisteredOrders(ctx context.Context, limit int) ([]models.Order, error)
+	UpdateOrder(ctx context.Context, order models.Order) error
+}
@@ -2,54 +2,206 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/PaBah/gofermart/cmd/gophermart/server"
 	"github.com/PaBah/gofermart/internal/accrual"
+	"github.com/PaBah/gofermart/internal/auth"
 	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/events"
+	"github.com/PaBah/gofermart/internal/health"
+	"github.com/PaBah/gofermart/internal/idempotency"
 	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/metrics"
 	"github.com/PaBah/gofermart/internal/storage"
+	"github.com/PaBah/gofermart/internal/tracing"
+	"github.com/PaBah/gofermart/internal/webhook"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"go.uber.org/zap"
 )
 
 func main() {
-	options := &config.Options{}
-	ParseFlags(options)
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		os.Exit(printConfig(os.Args[3:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	options, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err == nil {
+		err = options.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
 
 	if err := logger.Initialize(options.LogsLevel); err != nil {
 		fmt.Printf("Logger can not be initialized %s", err)
 		return
 	}
 
+	keyring, err := auth.NewKeyringFromOptions(options)
+	if err != nil {
+		logger.Log().Error("JWT keys can not be loaded", zap.Error(err))
+		return
+	}
+	auth.SetKeyring(keyring)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), options)
+	if err != nil {
+		logger.Log().Error("Tracing can not be initialized", zap.Error(err))
+		return
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			logger.Log().Error("Traces can not be flushed", zap.Error(err))
+		}
+	}()
+
 	logger.Log().Info("Start server on", zap.String("address", options.RunAddress))
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
-	var store storage.Repository
-	dbStore, err := storage.NewDBStorage(context.Background(), options.DatabaseURI)
+	expectedMigration, err := storage.LatestMigrationVersion()
 	if err != nil {
-		logger.Log().Error("Database error with start", zap.Error(err))
+		logger.Log().Error("Migrations can not be read", zap.Error(err))
 		return
 	}
-	store = &dbStore
-	defer dbStore.Close()
 
-	newServer := server.NewRouter(options, &store)
-	scraper := accrual.NewOrdersAccrualClient(options, store)
-	scraper.ScrapeOrders()
+	var store storage.Repository
+	var dbStore *storage.DBStorage
+	if options.Database.URI == "" {
+		logger.Log().Warn("Database URI is not set, data is kept in memory and lost on restart")
+		store = storage.NewMemoryStorage()
+	} else {
+		opened, err := storage.NewDBStorage(ctx, options.Database)
+		if err != nil {
+			logger.Log().Error("Database error with start", zap.Error(err))
+			return
+		}
+		dbStore = &opened
+		store = dbStore
+	}
 
-	go func() {
-		err := http.ListenAndServe(options.RunAddress, newServer)
+	// Фоновые обработчики останавливаются по ctx; хранилище закрывается только после них
+	var workers sync.WaitGroup
+	runWorker := func(run func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
 
-		if err != nil {
-			logger.Log().Error("Server crashed with error: ", zap.Error(err))
+	broker := events.NewBroker()
+	scraper := accrual.NewOrdersAccrualClient(options, store)
+	dispatcher := webhook.NewDispatcher(options, store)
+	runWorker(func() { broker.Run(ctx, store) })
+	runWorker(func() { scraper.ScrapeOrders(ctx) })
+	runWorker(func() { dispatcher.Run(ctx) })
+	runWorker(func() { idempotency.RunCleanup(ctx, store) })
+	runWorker(func() { events.RunCleanup(ctx, store, options.HTTP.EventsRetention) })
+
+	metrics.Registry.MustRegister(metrics.NewAccrualQueueCollector(store, "NEW", "PROCESSING"))
+	checker := health.NewChecker()
+	if dbStore != nil {
+		if pool := dbStore.Pool(); pool != nil {
+			metrics.Registry.MustRegister(metrics.NewPgxPoolCollector(pool))
+		} else {
+			metrics.Registry.MustRegister(collectors.NewDBStatsCollector(dbStore.DB(), "gophermart"))
 		}
-	}()
+		checker.Add("database", health.DatabaseCheck(dbStore))
+		checker.Add("migrations", health.MigrationsCheck(dbStore, expectedMigration))
+	}
+	checker.Add("accrual", scraper.CheckAnswered(options.Accrual.ReadyWindow))
+
+	httpServer := &http.Server{
+		Addr:              options.RunAddress,
+		Handler:           server.NewRouter(options, &store, broker),
+		ReadHeaderTimeout: options.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       options.HTTP.ReadTimeout,
+		WriteTimeout:      options.HTTP.WriteTimeout,
+		IdleTimeout:       options.HTTP.IdleTimeout,
+	}
+	// Потоки событий иначе держали бы Shutdown до истечения таймаута
+	httpServer.RegisterOnShutdown(broker.Close)
+	go serve(httpServer, stop)
+
+	var adminServer *http.Server
+	if options.AdminAddress != "" {
+		logger.Log().Info("Start admin server on", zap.String("address", options.AdminAddress))
+		adminServer = &http.Server{Addr: options.AdminAddress, Handler: server.NewAdminRouter(checker), ReadHeaderTimeout: options.HTTP.ReadHeaderTimeout}
+		go serve(adminServer, stop)
+	}
 
 	<-ctx.Done()
-	//accrual.Main()
+	// Повторный сигнал завершит процесс сразу, не дожидаясь остановки
+	stop()
+	logger.Log().Info("Shutting down", zap.Duration("delay", options.HTTP.ShutdownDelay), zap.Duration("timeout", options.HTTP.ShutdownTimeout))
+
+	checker.SetDraining()
+	time.Sleep(options.HTTP.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), options.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Log().Error("In-flight requests were not drained", zap.Error(err))
+	}
+	workers.Wait()
+	// Пробы отвечают draining до конца остановки
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			logger.Log().Error("Admin server was not stopped", zap.Error(err))
+		}
+	}
+	if dbStore != nil {
+		if err := dbStore.Close(); err != nil {
+			logger.Log().Error("Database can not be closed", zap.Error(err))
+		}
+	}
+	logger.Log().Info("Server stopped")
+}
+
+// serve запускает сервер; ошибка запуска останавливает весь сервис через stop.
+func serve(srv *http.Server, stop context.CancelFunc) {
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Log().Error("Server crashed with error: ", zap.String("address", srv.Addr), zap.Error(err))
+		stop()
+	}
+}
+
+// printConfig выводит итоговые настройки со скрытыми секретами, а ошибки проверки — в stderr.
+func printConfig(args []string) int {
+	options, err := config.Load("config print", args, os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err = options.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err = options.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/storage"
+)
+
+const migrateUsage = `Usage: gophermart migrate <command> [flags]
+
+Commands:
+  up         apply all new migrations
+  down N     roll back N last migrations
+  goto V     migrate up or down to version V
+  version    print applied schema version
+  force V    set version V without running migrations and clear dirty flag, -1 means empty schema
+
+Flags are the same as for the server, only database settings are used.`
+
+// runMigrate выполняет команду управления схемой и печатает итоговую версию.
+func runMigrate(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	command, args := args[0], args[1:]
+	var argument int
+	switch command {
+	case "up", "version":
+	case "down", "goto", "force":
+		if len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "migrate %s requires a number\n", command)
+			return 2
+		}
+		var err error
+		argument, err = strconv.Atoi(args[0])
+		if err != nil || (command != "force" && argument < 0) {
+			fmt.Fprintf(os.Stderr, "migrate %s: invalid number %q\n", command, args[0])
+			return 2
+		}
+		args = args[1:]
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n\n%s\n", command, migrateUsage)
+		return 2
+	}
+
+	options, err := config.Load("migrate "+command, args, os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err == nil && options.Database.URI == "" {
+		err = errors.New("database.uri: is required")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	migrator, err := storage.OpenMigrator(ctx, options.Database)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Database can not be opened:", err)
+		return 1
+	}
+	defer migrator.Close()
+
+	switch command {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		err = migrator.Down(argument)
+	case "goto":
+		err = migrator.Goto(uint(argument))
+	case "force":
+		err = migrator.Force(argument)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate %s failed: %s\n", command, err)
+		return 1
+	}
+
+	version, dirty, err := migrator.Version()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Schema version can not be read:", err)
+		return 1
+	}
+	latest, err := storage.LatestMigrationVersion()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Migrations can not be read:", err)
+		return 1
+	}
+	fmt.Printf("version: %d\ndirty: %t\nlatest: %d\n", version, dirty, latest)
+	return 0
+}
@@ -0,0 +1,16 @@
+package server
+
+import (
+	"github.com/PaBah/gofermart/internal/health"
+	"github.com/PaBah/gofermart/internal/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// NewAdminRouter обслуживает служебный адрес, закрытый от пользователей: метрики и пробы не требуют авторизации.
+func NewAdminRouter(checker *health.Checker) *chi.Mux {
+	r := chi.NewRouter()
+	r.Method("GET", "/metrics", metrics.Handler())
+	r.Get("/healthz", health.LiveHandler)
+	r.Get("/readyz", checker.ReadyHandler)
+	return r
+}
@@ -2,98 +2,323 @@ package server
 
 import (
 	"compress/flate"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/PaBah/gofermart/internal/auth"
 	"github.com/PaBah/gofermart/internal/config"
 	"github.com/PaBah/gofermart/internal/dto"
+	"github.com/PaBah/gofermart/internal/events"
+	"github.com/PaBah/gofermart/internal/idempotency"
 	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/loginguard"
+	"github.com/PaBah/gofermart/internal/metrics"
 	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+	"github.com/PaBah/gofermart/internal/problem"
 	"github.com/PaBah/gofermart/internal/storage"
+	"github.com/PaBah/gofermart/internal/tracing"
 	"github.com/PaBah/gofermart/internal/utils"
+	"github.com/PaBah/gofermart/internal/validation"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"go.uber.org/zap"
 )
 
+const refreshTokenCookie = "Refresh-Token"
+
 type Server struct {
-	options *config.Options
-	storage storage.Repository
+	options        *config.Options
+	storage        storage.Repository
+	loginGuard     loginguard.Guard
+	events         *events.Broker
+	trustedProxies []netip.Prefix
 }
 
-func (s Server) registerUserHandle(res http.ResponseWriter, req *http.Request) {
-	contentType := req.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		http.Error(res, "Invalid request content type", http.StatusBadRequest)
-		return
+// clientIP возвращает адрес клиента. X-Forwarded-For учитывается, только если соединение пришло от доверенного прокси:
+// цепочка разбирается справа налево, и клиентом считается первый адрес, не принадлежащий доверенным прокси.
+func (s Server) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !s.isTrustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		host = hop
+		if !s.isTrustedProxy(hop) {
+			break
+		}
 	}
+	return host
+}
 
-	body, err := io.ReadAll(req.Body)
+func (s Server) isTrustedProxy(host string) bool {
+	addr, err := netip.ParseAddr(host)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// startSession создаёт сессию пользователя и выдаёт ей access- и refresh-токены.
+func (s Server) startSession(res http.ResponseWriter, req *http.Request, userID string) error {
+	refreshToken, refreshTokenHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	session, err := s.storage.CreateSession(req.Context(), models.Session{
+		UserID:           userID,
+		RefreshTokenHash: refreshTokenHash,
+		ExpiresAt:        time.Now().Add(auth.RefreshTokenExp),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.issueTokens(res, session, refreshToken)
+}
+
+// issueTokens выставляет токены в cookies для браузера и возвращает их в теле ответа
+// для клиентов, которые авторизуются заголовком Authorization: Bearer.
+func (s Server) issueTokens(res http.ResponseWriter, session models.Session, refreshToken string) error {
+	JWTToken, err := auth.BuildJWTString(session.UserID, session.ID)
+	if err != nil {
+		return err
+	}
+	csrfToken, err := auth.NewCSRFToken()
+	if err != nil {
+		return err
 	}
 
+	http.SetCookie(res, &http.Cookie{
+		Name: "Authorization", Value: JWTToken, Path: "/",
+		MaxAge: int(auth.TokenExp.Seconds()), HttpOnly: true, Secure: s.options.Auth.CookieSecure, SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(res, &http.Cookie{
+		Name: refreshTokenCookie, Value: refreshToken, Path: "/api/user/token",
+		MaxAge: int(time.Until(session.ExpiresAt).Seconds()), HttpOnly: true, Secure: s.options.Auth.CookieSecure, SameSite: http.SameSiteStrictMode,
+	})
+	// CSRF-токен читается скриптом страницы и возвращается в заголовке X-CSRF-Token
+	http.SetCookie(res, &http.Cookie{
+		Name: auth.CSRFCookie, Value: csrfToken, Path: "/",
+		MaxAge: int(auth.RefreshTokenExp.Seconds()), Secure: s.options.Auth.CookieSecure, SameSite: http.SameSiteLaxMode,
+	})
+
+	resBody, err := json.Marshal(dto.TokenResponse{
+		AccessToken:  JWTToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(auth.TokenExp.Seconds()),
+		RefreshToken: refreshToken,
+		CSRFToken:    csrfToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	_, err = res.Write(resBody)
+	if err != nil {
+		logger.Log().Error("Can not write auth tokens", zap.Error(err))
+	}
+	return nil
+}
+
+func (s Server) clearSessionCookies(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{Name: "Authorization", Path: "/", MaxAge: -1, HttpOnly: true, Secure: s.options.Auth.CookieSecure, SameSite: http.SameSiteLaxMode})
+	http.SetCookie(res, &http.Cookie{Name: refreshTokenCookie, Path: "/api/user/token", MaxAge: -1, HttpOnly: true, Secure: s.options.Auth.CookieSecure, SameSite: http.SameSiteStrictMode})
+	http.SetCookie(res, &http.Cookie{Name: auth.CSRFCookie, Path: "/", MaxAge: -1, Secure: s.options.Auth.CookieSecure, SameSite: http.SameSiteLaxMode})
+}
+
+func (s Server) registerUserHandle(res http.ResponseWriter, req *http.Request) {
 	requestData := &dto.RegisterUserRequest{}
-	_ = json.Unmarshal(body, requestData)
+	err := validation.DecodeJSON(req, requestData)
+	if err == nil {
+		err = validation.RegisterUser(*requestData, s.options.Auth.StrictCredentials)
+	}
+	if err != nil {
+		validation.WriteError(res, req, err)
+		return
+	}
+
 	user := models.NewUser(requestData.Login, requestData.Password)
 	createdUser, err := s.storage.CreateUser(req.Context(), user)
 
 	if errors.Is(err, storage.ErrAlreadyExists) {
-		http.Error(res, "User with such login already exists", http.StatusConflict)
+		problem.Error(res, req, http.StatusConflict, problem.CodeLoginTaken, "User with such login already exists")
+		return
+	}
+	if err != nil {
+		problem.Internal(res, req, err)
 		return
 	}
 
-	JWTToken, err := auth.BuildJWTString(createdUser.ID)
+	err = s.startSession(res, req, createdUser.ID)
 	if err != nil {
-		http.Error(res, "Can not build auth token", http.StatusInternalServerError)
+		problem.Internal(res, req, err)
 		return
 	}
-	res.Header().Set("Content-Type", "application/json")
-	http.SetCookie(res, &http.Cookie{Name: "Authorization", Value: JWTToken})
 }
 
 func (s Server) loginUserHandle(res http.ResponseWriter, req *http.Request) {
-	contentType := req.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		http.Error(res, "Invalid request content type", http.StatusBadRequest)
+	requestData := &dto.LoginUserRequest{}
+	err := validation.DecodeJSON(req, requestData)
+	if err == nil {
+		err = validation.LoginUser(*requestData)
+	}
+	if err != nil {
+		validation.WriteError(res, req, err)
 		return
 	}
 
-	body, _ := io.ReadAll(req.Body)
-
-	requestData := &dto.LoginUserRequest{}
-	err := json.Unmarshal(body, requestData)
+	ip := s.clientIP(req)
+	wait, err := s.loginGuard.Attempt(req.Context(), requestData.Login, ip)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		problem.Internal(res, req, err)
+		return
+	}
+	if wait > 0 {
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		problem.Error(res, req, http.StatusTooManyRequests, problem.CodeTooManyLoginAttempts, "Too many failed login attempts")
 		return
 	}
+
 	user, err := s.storage.AuthorizeUser(req.Context(), requestData.Login)
 
 	if err != nil || !utils.CheckPasswordHash(user.Password, requestData.Password) {
-		http.Error(res, "User with such credentials can not be logined", http.StatusUnauthorized)
+		s.loginGuard.Failure(req.Context(), ip)
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeInvalidCredentials, "User with such credentials can not be logined")
 		return
 	}
+	s.loginGuard.Success(req.Context(), requestData.Login)
 
-	JWTToken, err := auth.BuildJWTString(user.ID)
+	err = s.startSession(res, req, user.ID)
 	if err != nil {
-		http.Error(res, "Can not build auth token", http.StatusInternalServerError)
+		problem.Internal(res, req, err)
 		return
 	}
-	res.Header().Set("Content-Type", "application/json")
-	http.SetCookie(res, &http.Cookie{Name: "Authorization", Value: JWTToken})
+}
+
+func (s Server) refreshTokenHandle(res http.ResponseWriter, req *http.Request) {
+	var refreshToken string
+	if refreshCookie, err := req.Cookie(refreshTokenCookie); err == nil {
+		if s.options.Auth.CSRFProtection && !auth.ValidCSRF(req) {
+			problem.Error(res, req, http.StatusForbidden, problem.CodeCSRFFailed, "CSRF token missing or invalid")
+			return
+		}
+		refreshToken = refreshCookie.Value
+	} else {
+		requestData := &dto.RefreshTokenRequest{}
+		err = validation.DecodeJSON(req, requestData)
+		if err != nil {
+			validation.WriteError(res, req, err)
+			return
+		}
+		refreshToken = requestData.RefreshToken
+	}
+
+	if refreshToken == "" {
+		p := problem.New(http.StatusUnprocessableEntity, problem.CodeValidationFailed, "Request validation failed")
+		p.Errors = []problem.FieldError{{Field: "refresh_token", Code: "required", Message: "refresh token is required"}}
+		p.Write(res, req)
+		return
+	}
+
+	newRefreshToken, newRefreshTokenHash, err := auth.NewRefreshToken()
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	session, err := s.storage.RotateSession(req.Context(), auth.HashRefreshToken(refreshToken), newRefreshTokenHash, time.Now().Add(auth.RefreshTokenExp))
+	if errors.Is(err, storage.ErrNotFound) {
+		s.clearSessionCookies(res)
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeInvalidRefreshToken, "Refresh token is invalid or revoked")
+		return
+	}
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	err = s.issueTokens(res, session, newRefreshToken)
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+}
+
+func (s Server) logoutHandle(res http.ResponseWriter, req *http.Request) {
+	sessionID := req.Context().Value(auth.ContextSessionKey).(string)
+	err := s.storage.RevokeSession(req.Context(), sessionID)
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+	s.clearSessionCookies(res)
+}
+
+func (s Server) logoutAllHandle(res http.ResponseWriter, req *http.Request) {
+	userID := req.Context().Value(auth.ContextUserKey).(string)
+	err := s.storage.RevokeUserSessions(req.Context(), userID)
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+	s.clearSessionCookies(res)
+}
+
+// orderStatuses — статусы, по которым можно фильтровать список заказов.
+var orderStatuses = []string{"NEW", "PROCESSING", "INVALID", "PROCESSED"}
+
+// setNextPage отдаёт ссылку на следующую страницу списка, если она есть.
+func setNextPage(res http.ResponseWriter, req *http.Request, next *pagination.Cursor) {
+	if next == nil {
+		return
+	}
+	res.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, pagination.NextURL(req.URL, *next)))
+	res.Header().Set("X-Next-Cursor", next.Encode())
 }
 
 func (s Server) getOrdersHandle(res http.ResponseWriter, req *http.Request) {
-	orders, err := s.storage.GetUsersOrders(req.Context())
+	query, err := pagination.ParseQuery(req.URL.Query(), true, orderStatuses...)
+	if err != nil {
+		validation.WriteError(res, req, err)
+		return
+	}
+
+	orders, next, err := s.storage.ListUsersOrders(req.Context(), query)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		problem.Internal(res, req, err)
 		return
 	}
 
+	setNextPage(res, req, next)
 	if len(orders) == 0 {
 		res.WriteHeader(http.StatusNoContent)
 		return
@@ -116,110 +341,157 @@ func (s Server) getOrdersHandle(res http.ResponseWriter, req *http.Request) {
 	_, err = res.Write(response)
 	if err != nil {
 		logger.Log().Error("Can not send response from GET /api/user/orders", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
-func (s Server) createOrderHandle(res http.ResponseWriter, req *http.Request) {
-	contentType := req.Header.Get("Content-Type")
-	if contentType != "text/plain" {
-		http.Error(res, "Invalid request content type", http.StatusBadRequest)
+// etagMatches проверяет If-None-Match по слабому сравнению (RFC 9110, 13.1.2).
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Server) getOrderHandle(res http.ResponseWriter, req *http.Request) {
+	order, err := s.storage.GetUsersOrder(req.Context(), chi.URLParam(req, "number"))
+	if errors.Is(err, storage.ErrNotFound) {
+		problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "Order not found")
+		return
+	}
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	responseData := dto.OrderStatusResponse{
+		Number:     order.Number,
+		Status:     order.Status,
+		Accrual:    order.Accrual,
+		UploadedAt: dto.JSONTime(order.UploadedAt),
+	}
+	if !order.AccrualCheckedAt.IsZero() {
+		checkedAt := dto.JSONTime(order.AccrualCheckedAt)
+		responseData.AccrualCheckedAt = &checkedAt
+	}
+	response, _ := json.Marshal(responseData)
+
+	// ETag зависит только от тела ответа, поэтому опрос без изменений обходится ответом 304.
+	// Тег слабый: после сжатия байты ответа отличаются.
+	sum := sha256.Sum256(response)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	res.Header().Set("ETag", etag)
+	res.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		res.WriteHeader(http.StatusNotModified)
 		return
 	}
 
-	body, err := io.ReadAll(req.Body)
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	_, err = res.Write(response)
+	if err != nil {
+		logger.Log().Error("Can not send response from GET /api/user/orders/{number}", zap.Error(err))
+	}
+}
+
+func (s Server) createOrderHandle(res http.ResponseWriter, req *http.Request) {
+	body, err := validation.ReadBody(req, "text/plain")
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		validation.WriteError(res, req, err)
 		return
 	}
 
 	orderNumber := string(body)
-	err = utils.ValidateLuhn(orderNumber)
+	err = validation.OrderNumber(orderNumber)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusUnprocessableEntity)
+		validation.WriteError(res, req, err)
 		return
 	}
 
-	order, err := s.storage.RegisterOrder(req.Context(), string(body))
+	_, err = s.storage.RegisterOrder(req.Context(), orderNumber)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+	if errors.Is(err, storage.ErrOrderConflict) {
+		problem.Error(res, req, http.StatusConflict, problem.CodeOrderConflict, "Order number already uploaded by another user")
+		return
+	}
 	if err != nil {
-		if order.UserID == req.Context().Value(auth.ContextUserKey).(string) {
-			res.WriteHeader(http.StatusOK)
-			return
-		}
-
-		res.WriteHeader(http.StatusConflict)
+		problem.Internal(res, req, err)
 		return
 	}
 	res.WriteHeader(http.StatusAccepted)
 }
 
 func (s Server) getBalanceHandle(res http.ResponseWriter, req *http.Request) {
-	balance, _ := s.storage.GetUsersBalance(req.Context())
-	withdraw, _ := s.storage.GetUsersWithdraw(req.Context())
+	balance, err := s.storage.GetBalance(req.Context())
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
 
 	responseData := dto.UserBalanceResponse{
-		Current:   balance - withdraw,
-		Withdrawn: withdraw,
+		Current:   balance.Current,
+		Withdrawn: balance.Withdrawn,
 	}
 
 	res.Header().Set("Content-Type", "application/json")
 	response, _ := json.Marshal(responseData)
 
 	res.WriteHeader(http.StatusOK)
-	_, err := res.Write(response)
+	_, err = res.Write(response)
 	if err != nil {
 		logger.Log().Error("Can not send response from GET /api/user/balance", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
-		return
 	}
 }
 
 func (s Server) withdrawFundsHandle(res http.ResponseWriter, req *http.Request) {
-	contentType := req.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		http.Error(res, "Invalid request content type", http.StatusBadRequest)
-		return
+	requestData := &dto.WithdrawalRequest{}
+	err := validation.DecodeJSON(req, requestData)
+	if err == nil {
+		err = validation.Withdrawal(*requestData)
 	}
-
-	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+		validation.WriteError(res, req, err)
 		return
 	}
 
-	requestData := &dto.WithdrawalRequest{}
-	err = json.Unmarshal(body, requestData)
-	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+	userID := req.Context().Value(auth.ContextUserKey).(string)
+	_, err = s.storage.Withdraw(req.Context(), userID, requestData.Number, requestData.Sum)
+	if errors.Is(err, storage.ErrInsufficientFunds) {
+		problem.Error(res, req, http.StatusPaymentRequired, problem.CodeInsufficientFunds, "Not enough funds")
+		return
+	}
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		problem.Error(res, req, http.StatusConflict, problem.CodeWithdrawalExists, "Withdrawal for this order number was already registered")
 		return
 	}
-
-	err = utils.ValidateLuhn(requestData.Number)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusUnprocessableEntity)
+		problem.Internal(res, req, err)
 		return
 	}
+}
 
-	balance, _ := s.storage.GetUsersBalance(req.Context())
-	withdraw, _ := s.storage.GetUsersWithdraw(req.Context())
-
-	if balance-withdraw < requestData.Sum {
-		http.Error(res, "Not enough funds", http.StatusPaymentRequired)
+func (s Server) getUsersWithdrawalsHandle(res http.ResponseWriter, req *http.Request) {
+	query, err := pagination.ParseQuery(req.URL.Query(), true)
+	if err != nil {
+		validation.WriteError(res, req, err)
 		return
 	}
 
-	withdrawal := models.Withdrawal{OrderNumber: requestData.Number, Sum: requestData.Sum}
-	_, err = s.storage.CreateWithdrawal(req.Context(), withdrawal)
+	withdrawals, next, err := s.storage.ListUsersWithdrawals(req.Context(), query)
 	if err != nil {
-		http.Error(res, err.Error(), http.StatusBadRequest)
+		problem.Internal(res, req, err)
 		return
 	}
-}
-
-func (s Server) getUsersWithdrawalsHandle(res http.ResponseWriter, req *http.Request) {
-	withdrawals, _ := s.storage.GetUsersWithdrawals(req.Context())
 
+	setNextPage(res, req, next)
 	if len(withdrawals) == 0 {
 		res.WriteHeader(http.StatusNoContent)
 		return
@@ -238,35 +510,208 @@ func (s Server) getUsersWithdrawalsHandle(res http.ResponseWriter, req *http.Req
 	response, _ := json.Marshal(responseData)
 
 	res.WriteHeader(http.StatusOK)
-	_, err := res.Write(response)
+	_, err = res.Write(response)
 	if err != nil {
 		logger.Log().Error("Can not send response from GET /api/user/withdrawals", zap.Error(err))
-		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+const (
+	eventsBatchSize = 100
+	// eventsHeartbeat держит соединение открытым за прокси и заодно перечитывает журнал,
+	// если уведомление было потеряно при переподключении LISTEN.
+	eventsHeartbeat = 15 * time.Second
+	eventsRetry     = 3 * time.Second
+)
+
+// eventsHandle отдаёт поток Server-Sent Events со сменой статусов заказов и баланса пользователя.
+// Без Last-Event-ID поток начинается с новых событий.
+func (s Server) eventsHandle(res http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	userID := ctx.Value(auth.ContextUserKey).(string)
+
+	var lastID int64
+	var err error
+	if value := req.Header.Get("Last-Event-ID"); value != "" {
+		lastID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || lastID < 0 {
+			problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidParameter, "Last-Event-ID must be an event id")
+			return
+		}
+	}
+
+	// Подписка оформляется до чтения журнала, чтобы не пропустить событие между чтением и ожиданием
+	wake, unsubscribe := s.events.Subscribe(userID)
+	defer unsubscribe()
+
+	if req.Header.Get("Last-Event-ID") == "" {
+		lastID, err = s.storage.GetLastUserEventID(ctx, userID)
+		if err != nil {
+			problem.Internal(res, req, err)
+			return
+		}
+	}
+
+	rc := http.NewResponseController(res)
+	// Поток живёт дольше http.write_timeout; ошибка означает, что дедлайны не поддерживаются
+	_ = rc.SetWriteDeadline(time.Time{})
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	_, err = fmt.Fprintf(res, "retry: %d\n\n", eventsRetry.Milliseconds())
+	if err == nil {
+		err = rc.Flush()
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for err == nil {
+		var userEvents []models.UserEvent
+		userEvents, err = s.storage.GetUserEvents(ctx, userID, lastID, eventsBatchSize)
+		for i := 0; err == nil && i < len(userEvents); i++ {
+			_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", userEvents[i].ID, userEvents[i].Type, userEvents[i].Payload)
+			lastID = userEvents[i].ID
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil || len(userEvents) == eventsBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.events.Done():
+			// Клиент переподключится к другому экземпляру с Last-Event-ID
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(res, ": keep-alive\n\n")
+		}
+	}
+
+	if ctx.Err() == nil {
+		logger.Log().Error("user events stream closed", zap.String("user", userID), zap.Error(err))
+	}
+}
+
+const defaultLockoutsLimit = 100
+
+func (s Server) unlockLoginHandle(res http.ResponseWriter, req *http.Request) {
+	requestData := &dto.UnlockLoginRequest{}
+	err := validation.DecodeJSON(req, requestData)
+	if err != nil {
+		validation.WriteError(res, req, err)
 		return
 	}
+	if (requestData.Login == "") == (requestData.IP == "") {
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidParameter, "Exactly one of login or ip required")
+		return
+	}
+
+	scope, key := models.LoginScopeLogin, requestData.Login
+	if requestData.IP != "" {
+		scope, key = models.LoginScopeIP, requestData.IP
+	}
+
+	err = s.storage.UnlockLogin(req.Context(), scope, key, "admin@"+s.clientIP(req))
+	if errors.Is(err, storage.ErrNotFound) {
+		problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "No failed attempts recorded")
+		return
+	}
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+	logger.Log().Info("Login unlocked by admin", zap.String("scope", scope), zap.String("key", key))
 }
 
-func NewRouter(options *config.Options, storage *storage.Repository) *chi.Mux {
+func (s Server) getLoginLockoutsHandle(res http.ResponseWriter, req *http.Request) {
+	limit := defaultLockoutsLimit
+	if value := req.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid limit")
+			return
+		}
+	}
+
+	lockouts, err := s.storage.GetLoginLockouts(req.Context(), limit)
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	resBody, err := json.Marshal(lockouts)
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	_, err = res.Write(resBody)
+	if err != nil {
+		logger.Log().Error("Can not write login lockouts", zap.Error(err))
+	}
+}
+
+func NewRouter(options *config.Options, storage *storage.Repository, broker *events.Broker) *chi.Mux {
 	r := chi.NewRouter()
 
+	// Список прокси уже проверен в config.Validate
+	trustedProxies, _ := options.HTTP.TrustedProxyPrefixes()
 	s := Server{
-		options: options,
-		storage: *storage,
+		options:        options,
+		storage:        *storage,
+		loginGuard:     loginguard.New(options, *storage),
+		events:         broker,
+		trustedProxies: trustedProxies,
 	}
+	r.Use(tracing.Middleware)
 	r.Use(logger.LoggerMiddleware)
+	r.Use(metrics.Middleware)
+	r.Use(validation.LimitBody(validation.MaxBodySize))
 	r.Use(middleware.NewCompressor(flate.DefaultCompression).Handler)
 
+	r.Get("/.well-known/jwks.json", auth.JWKSHandler)
 	r.Group(func(r chi.Router) {
 		r.Post("/api/user/register", s.registerUserHandle)
 		r.Post("/api/user/login", s.loginUserHandle)
+		r.Post("/api/user/token/refresh", s.refreshTokenHandle)
 	})
 	r.Group(func(r chi.Router) {
-		r.Use(auth.AuthorizedMiddleware)
-		r.Post("/api/user/orders", s.createOrderHandle)
+		r.Use(auth.AuthorizedMiddleware(s.storage))
+		if options.Auth.CSRFProtection {
+			r.Use(auth.CSRFMiddleware)
+		}
+		r.Post("/api/user/logout", s.logoutHandle)
+		r.Post("/api/user/logout/all", s.logoutAllHandle)
+		r.With(idempotency.Middleware(s.storage)).Post("/api/user/orders", s.createOrderHandle)
 		r.Get("/api/user/orders", s.getOrdersHandle)
+		r.Get("/api/user/orders/{number}", s.getOrderHandle)
 		r.Get("/api/user/balance", s.getBalanceHandle)
-		r.Post("/api/user/balance/withdraw", s.withdrawFundsHandle)
+		r.With(idempotency.Middleware(s.storage)).Post("/api/user/balance/withdraw", s.withdrawFundsHandle)
 		r.Get("/api/user/withdrawals", s.getUsersWithdrawalsHandle)
+		r.Get("/api/user/events", s.eventsHandle)
+		r.Post("/api/user/webhooks", s.createWebhookHandle(userWebhooks))
+		r.Get("/api/user/webhooks", s.getWebhooksHandle(userWebhooks))
+		r.Delete("/api/user/webhooks/{id}", s.deleteWebhookHandle(userWebhooks))
+		r.Get("/api/user/webhooks/{id}/deliveries", s.getWebhookDeliveriesHandle(userWebhooks))
+		r.Post("/api/user/webhooks/{id}/deliveries/{deliveryID}/redeliver", s.redeliverWebhookHandle(userWebhooks))
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.AdminMiddleware(options.Auth.AdminToken))
+		r.Get("/api/admin/login-lockouts", s.getLoginLockoutsHandle)
+		r.Post("/api/admin/login-lockouts/unlock", s.unlockLoginHandle)
+		r.Post("/api/admin/webhooks", s.createWebhookHandle(adminWebhooks))
+		r.Get("/api/admin/webhooks", s.getWebhooksHandle(adminWebhooks))
+		r.Delete("/api/admin/webhooks/{id}", s.deleteWebhookHandle(adminWebhooks))
+		r.Get("/api/admin/webhooks/{id}/deliveries", s.getWebhookDeliveriesHandle(adminWebhooks))
+		r.Post("/api/admin/webhooks/{id}/deliveries/{deliveryID}/redeliver", s.redeliverWebhookHandle(adminWebhooks))
 	})
 	return r
 }
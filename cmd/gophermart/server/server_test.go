@@ -1,22 +1,44 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/PaBah/gofermart/internal/accrual"
+	"github.com/PaBah/gofermart/internal/accrual/accrualtest"
 	"github.com/PaBah/gofermart/internal/auth"
 	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/dto"
+	"github.com/PaBah/gofermart/internal/events"
+	"github.com/PaBah/gofermart/internal/health"
 	"github.com/PaBah/gofermart/internal/mock"
 	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+	"github.com/PaBah/gofermart/internal/problem"
 	"github.com/PaBah/gofermart/internal/storage"
+	"github.com/PaBah/gofermart/internal/validation"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
+func TestMain(m *testing.M) {
+	keyring, err := auth.NewEphemeralKeyring()
+	if err != nil {
+		panic(err)
+	}
+	auth.SetKeyring(keyring)
+	os.Exit(m.Run())
+}
+
 func TestServer(t *testing.T) {
 	// описываем набор данных: метод запроса, ожидаемый код ответа, ожидаемое тело
 	testCases := []struct {
@@ -44,6 +66,7 @@ func TestServer(t *testing.T) {
 		{method: http.MethodPost, path: "/api/user/orders", requestBody: "12345678903", userID: "test", expectedCode: http.StatusBadRequest},
 		{method: http.MethodPost, path: "/api/user/orders", requestBody: "12345678903", expectedCode: http.StatusUnauthorized},
 		{method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", userID: "test", requestBody: "6400700313", expectedCode: http.StatusConflict},
+		{method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", userID: "test", requestBody: "79927398713", expectedCode: http.StatusInternalServerError},
 		{method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", userID: "test", requestBody: "123", expectedCode: http.StatusUnprocessableEntity},
 		//Orders List
 		{method: http.MethodGet, path: "/api/user/orders", contentType: "application/json", userID: "test", expectedCode: http.StatusOK, expectedBody: `[{"number":"12345678903","status":"NEW","uploaded_at":"2020-12-10T15:15:45+03:00"}]`},
@@ -60,6 +83,7 @@ func TestServer(t *testing.T) {
 		{method: http.MethodPost, path: "/api/user/balance/withdraw", userID: "test", requestBody: `{"order": 2377225624","sum":1231}`, expectedCode: http.StatusBadRequest},
 		{method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", userID: "test", requestBody: `{"order": "2377225624","sum":1231}`, expectedCode: http.StatusPaymentRequired},
 		{method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", userID: "test", requestBody: `{"order": "4","sum":1231}`, expectedCode: http.StatusUnprocessableEntity},
+		{method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", userID: "test", requestBody: `{"order": "79927398713","sum":1}`, expectedCode: http.StatusConflict},
 		//List Withdrawals
 		{method: http.MethodGet, path: "/api/user/withdrawals", contentType: "application/json", userID: "test", expectedCode: http.StatusOK, expectedBody: `[{"order":"2377225624","sum":123,"processed_at":"2020-12-09T16:09:57+03:00"}]`},
 		{method: http.MethodGet, path: "/api/user/withdrawals", contentType: "application/json", userID: "test2", expectedCode: http.StatusNoContent},
@@ -67,9 +91,9 @@ func TestServer(t *testing.T) {
 	}
 
 	options := &config.Options{
-		RunAddress:           ":8081",
-		DatabaseURI:          "http://localhost:8080",
-		AccrualSystemAddress: "wrong DSN",
+		RunAddress: ":8081",
+		Database:   config.DatabaseOptions{URI: "http://localhost:8080"},
+		Accrual:    config.AccrualOptions{Address: "wrong DSN"},
 	}
 	uploadedAt, _ := time.Parse(time.RFC3339, "2020-12-10T15:15:45+03:00")
 	processedAt, _ := time.Parse(time.RFC3339, "2020-12-09T16:09:57+03:00")
@@ -89,6 +113,34 @@ func TestServer(t *testing.T) {
 		CreateUser(gomock.Any(), gomock.Any()).
 		Return(models.User{ID: "test", Login: "test", Password: "test"}, storage.ErrAlreadyExists).
 		Times(1)
+	rm.
+		EXPECT().
+		CreateSession(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, session models.Session) (models.Session, error) {
+			session.ID = "session"
+			return session, nil
+		}).
+		AnyTimes()
+	rm.
+		EXPECT().
+		IsSessionActive(gomock.Any(), "session").
+		Return(true, nil).
+		AnyTimes()
+	rm.
+		EXPECT().
+		CheckLogin(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(models.LoginAttempt{Allowed: true}, nil).
+		AnyTimes()
+	rm.
+		EXPECT().
+		AttemptLogin(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(models.LoginAttempt{Allowed: true}, nil).
+		AnyTimes()
+	rm.
+		EXPECT().
+		ResetLoginFailures(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil).
+		AnyTimes()
 	rm.
 		EXPECT().
 		AuthorizeUser(gomock.Any(), gomock.Any()).
@@ -102,55 +154,65 @@ func TestServer(t *testing.T) {
 	rm.
 		EXPECT().
 		RegisterOrder(gomock.Any(), "3081279352").
-		Return(models.Order{Number: "3081279352", UserID: "test", Status: "NEW", Accrual: 0}, errors.New("already exists")).
+		Return(models.Order{Number: "3081279352", UserID: "test", Status: "NEW", Accrual: 0}, storage.ErrAlreadyExists).
 		AnyTimes()
 	rm.
 		EXPECT().
 		RegisterOrder(gomock.Any(), "6400700313").
-		Return(models.Order{Number: "6400700313", UserID: "not_test", Status: "NEW", Accrual: 0}, errors.New("already exists")).
+		Return(models.Order{Number: "6400700313", UserID: "not_test", Status: "NEW", Accrual: 0}, storage.ErrOrderConflict).
+		AnyTimes()
+	rm.
+		EXPECT().
+		RegisterOrder(gomock.Any(), "79927398713").
+		Return(models.Order{}, errors.New("connection refused")).
 		AnyTimes()
 	rm.
 		EXPECT().
-		GetUsersOrders(gomock.Any()).
-		Return([]models.Order{models.Order{Number: "12345678903", UserID: "test", Status: "NEW", Accrual: 0, UploadedAt: uploadedAt}}, nil).
+		ListUsersOrders(gomock.Any(), gomock.Any()).
+		Return([]models.Order{models.Order{Number: "12345678903", UserID: "test", Status: "NEW", Accrual: 0, UploadedAt: uploadedAt}}, nil, nil).
 		Times(1)
 	rm.
 		EXPECT().
-		GetUsersOrders(gomock.Any()).
-		Return([]models.Order{}, nil).
+		ListUsersOrders(gomock.Any(), gomock.Any()).
+		Return([]models.Order{}, nil, nil).
 		Times(1)
 	rm.
 		EXPECT().
-		GetUsersOrders(gomock.Any()).
-		Return([]models.Order{}, errors.New("DB brake down")).
+		ListUsersOrders(gomock.Any(), gomock.Any()).
+		Return([]models.Order{}, nil, errors.New("DB brake down")).
 		Times(1)
 	rm.
 		EXPECT().
-		GetUsersBalance(gomock.Any()).
-		Return(542.5, nil).
+		GetBalance(gomock.Any()).
+		Return(models.Balance{Current: 50050, Withdrawn: 4200}, nil).
+		AnyTimes()
+	rm.
+		EXPECT().
+		Withdraw(gomock.Any(), "test", "2377225624", models.Money(12300)).
+		Return(models.Withdrawal{OrderNumber: "2377225624", Sum: 12300}, nil).
 		AnyTimes()
 	rm.
 		EXPECT().
-		GetUsersWithdraw(gomock.Any()).
-		Return(float64(42), nil).
+		Withdraw(gomock.Any(), "test", "2377225624", models.Money(123100)).
+		Return(models.Withdrawal{}, storage.ErrInsufficientFunds).
 		AnyTimes()
 	rm.
 		EXPECT().
-		CreateWithdrawal(gomock.Any(), models.Withdrawal{OrderNumber: "2377225624", Sum: 123}).
-		Return(models.Withdrawal{OrderNumber: "2377225624", Sum: 123}, nil).
+		Withdraw(gomock.Any(), "test", "79927398713", models.Money(100)).
+		Return(models.Withdrawal{}, storage.ErrAlreadyExists).
 		AnyTimes()
 	rm.
 		EXPECT().
-		GetUsersWithdrawals(gomock.Any()).
-		Return([]models.Withdrawal{models.Withdrawal{OrderNumber: "2377225624", Sum: 123, ProcessedAt: processedAt}}, nil).
+		ListUsersWithdrawals(gomock.Any(), gomock.Any()).
+		Return([]models.Withdrawal{models.Withdrawal{OrderNumber: "2377225624", Sum: 12300, ProcessedAt: processedAt}}, nil, nil).
 		Times(1)
 	rm.
 		EXPECT().
-		GetUsersWithdrawals(gomock.Any()).
-		Return([]models.Withdrawal{}, nil).
+		ListUsersWithdrawals(gomock.Any(), gomock.Any()).
+		Return([]models.Withdrawal{}, nil, nil).
 		Times(1)
 
-	sh := NewRouter(options, &store)
+	sh := NewRouter(options, &store, events.NewBroker())
 
 	for _, tc := range testCases {
 		t.Run(tc.method, func(t *testing.T) {
@@ -161,8 +223,9 @@ func TestServer(t *testing.T) {
 			}
 			w := httptest.NewRecorder()
 			if tc.userID != "" {
-				JWTToken, _ := auth.BuildJWTString(tc.userID)
-				r.Header.Set("Cookie", "Authorization="+JWTToken)
+				JWTToken, _ := auth.BuildJWTString(tc.userID, "session")
+				r.Header.Set("Cookie", "Authorization="+JWTToken+"; "+auth.CSRFCookie+"=csrf")
+				r.Header.Set(auth.CSRFHeader, "csrf")
 			}
 			r.Header.Set("Content-Type", tc.contentType)
 
@@ -175,3 +238,737 @@ func TestServer(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_Sessions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	rm.EXPECT().IsSessionActive(gomock.Any(), "active").Return(true, nil).AnyTimes()
+	rm.EXPECT().IsSessionActive(gomock.Any(), "revoked").Return(false, nil).AnyTimes()
+	rm.EXPECT().RotateSession(gomock.Any(), auth.HashRefreshToken("valid"), gomock.Any(), gomock.Any()).
+		Return(models.Session{ID: "active", UserID: "test"}, nil).Times(2)
+	rm.EXPECT().RotateSession(gomock.Any(), auth.HashRefreshToken("rotated"), gomock.Any(), gomock.Any()).
+		Return(models.Session{}, storage.ErrNotFound).Times(1)
+	rm.EXPECT().RevokeSession(gomock.Any(), "active").Return(nil).Times(1)
+	rm.EXPECT().RevokeUserSessions(gomock.Any(), "test").Return(nil).Times(1)
+	rm.EXPECT().GetBalance(gomock.Any()).Return(models.Balance{}, nil).AnyTimes()
+
+	sh := NewRouter(&config.Options{}, &store, events.NewBroker())
+
+	testCases := []struct {
+		name         string
+		path         string
+		method       string
+		sessionID    string
+		refreshToken string
+		requestBody  string
+		expectedCode int
+	}{
+		{name: "refresh by cookie", method: http.MethodPost, path: "/api/user/token/refresh", refreshToken: "valid", expectedCode: http.StatusOK},
+		{name: "refresh by body", method: http.MethodPost, path: "/api/user/token/refresh", requestBody: `{"refresh_token":"valid"}`, expectedCode: http.StatusOK},
+		{name: "refresh with rotated token", method: http.MethodPost, path: "/api/user/token/refresh", refreshToken: "rotated", expectedCode: http.StatusUnauthorized},
+		{name: "refresh without token", method: http.MethodPost, path: "/api/user/token/refresh", expectedCode: http.StatusBadRequest},
+		{name: "active session", method: http.MethodGet, path: "/api/user/balance", sessionID: "active", expectedCode: http.StatusOK},
+		{name: "revoked session", method: http.MethodGet, path: "/api/user/balance", sessionID: "revoked", expectedCode: http.StatusUnauthorized},
+		{name: "logout", method: http.MethodPost, path: "/api/user/logout", sessionID: "active", expectedCode: http.StatusOK},
+		{name: "logout all devices", method: http.MethodPost, path: "/api/user/logout/all", sessionID: "active", expectedCode: http.StatusOK},
+		{name: "logout unauthorized", method: http.MethodPost, path: "/api/user/logout", expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.requestBody))
+			if tc.sessionID != "" {
+				JWTToken, _ := auth.BuildJWTString("test", tc.sessionID)
+				r.AddCookie(&http.Cookie{Name: "Authorization", Value: JWTToken})
+			}
+			if tc.refreshToken != "" {
+				r.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: tc.refreshToken})
+			}
+			r.AddCookie(&http.Cookie{Name: auth.CSRFCookie, Value: "csrf"})
+			r.Header.Set(auth.CSRFHeader, "csrf")
+			if tc.requestBody != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			if tc.expectedCode == http.StatusOK && tc.refreshToken+tc.requestBody != "" {
+				cookies := w.Result().Cookies()
+				defer w.Result().Body.Close()
+				assert.Len(t, cookies, 3, "Access, rotated refresh and CSRF tokens issued")
+				assert.NotEqual(t, "valid", cookies[1].Value, "Refresh token rotated")
+				claims, err := auth.ParseToken(cookies[0].Value)
+				assert.NoError(t, err)
+				assert.Equal(t, "active", claims.ID, "Access token bound to session")
+			}
+		})
+	}
+}
+
+func TestServer_Authentication(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	rm.EXPECT().AuthorizeUser(gomock.Any(), "test").
+		Return(models.NewUser("test", "test"), nil).AnyTimes()
+	rm.EXPECT().CheckLogin(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(models.LoginAttempt{Allowed: true}, nil).AnyTimes()
+	rm.EXPECT().AttemptLogin(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(models.LoginAttempt{Allowed: true}, nil).AnyTimes()
+	rm.EXPECT().ResetLoginFailures(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	rm.EXPECT().CreateSession(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, session models.Session) (models.Session, error) {
+			session.ID = "session"
+			return session, nil
+		}).AnyTimes()
+	rm.EXPECT().IsSessionActive(gomock.Any(), "session").Return(true, nil).AnyTimes()
+	rm.EXPECT().RevokeSession(gomock.Any(), "session").Return(nil).Times(2)
+
+	sh := NewRouter(&config.Options{Auth: config.AuthOptions{CookieSecure: true, CSRFProtection: true}}, &store, events.NewBroker())
+
+	t.Run("login returns tokens", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(`{"login":"test","password":"test"}`))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		sh.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		tokens := dto.TokenResponse{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &tokens))
+		assert.Equal(t, "Bearer", tokens.TokenType)
+		assert.Equal(t, int(auth.TokenExp.Seconds()), tokens.ExpiresIn)
+		assert.NotEmpty(t, tokens.RefreshToken)
+
+		cookies := map[string]*http.Cookie{}
+		for _, cookie := range w.Result().Cookies() {
+			cookies[cookie.Name] = cookie
+		}
+		defer w.Result().Body.Close()
+		accessCookie := cookies["Authorization"]
+		assert.Equal(t, tokens.AccessToken, accessCookie.Value)
+		assert.True(t, accessCookie.HttpOnly)
+		assert.True(t, accessCookie.Secure)
+		assert.Equal(t, http.SameSiteLaxMode, accessCookie.SameSite)
+		assert.Equal(t, int(auth.TokenExp.Seconds()), accessCookie.MaxAge)
+		assert.Equal(t, http.SameSiteStrictMode, cookies[refreshTokenCookie].SameSite)
+		assert.Equal(t, tokens.CSRFToken, cookies[auth.CSRFCookie].Value)
+		assert.False(t, cookies[auth.CSRFCookie].HttpOnly, "CSRF token readable by page scripts")
+	})
+
+	JWTToken, _ := auth.BuildJWTString("test", "session")
+	testCases := []struct {
+		name         string
+		method       string
+		bearer       string
+		cookie       string
+		csrfCookie   string
+		csrfHeader   string
+		expectedCode int
+	}{
+		{name: "bearer read", method: http.MethodGet, bearer: JWTToken, expectedCode: http.StatusOK},
+		{name: "bearer mutation without csrf", method: http.MethodPost, bearer: JWTToken, expectedCode: http.StatusOK},
+		{name: "bearer invalid", method: http.MethodGet, bearer: "invalid", cookie: JWTToken, expectedCode: http.StatusUnauthorized},
+		{name: "cookie read without csrf", method: http.MethodGet, cookie: JWTToken, expectedCode: http.StatusOK},
+		{name: "cookie mutation without csrf", method: http.MethodPost, cookie: JWTToken, expectedCode: http.StatusForbidden},
+		{name: "cookie mutation with wrong csrf", method: http.MethodPost, cookie: JWTToken, csrfCookie: "csrf", csrfHeader: "other", expectedCode: http.StatusForbidden},
+		{name: "cookie mutation with csrf", method: http.MethodPost, cookie: JWTToken, csrfCookie: "csrf", csrfHeader: "csrf", expectedCode: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := "/api/user/logout"
+			if tc.method == http.MethodGet {
+				path = "/api/user/balance"
+				rm.EXPECT().GetBalance(gomock.Any()).Return(models.Balance{}, nil).MaxTimes(1)
+			}
+			r := httptest.NewRequest(tc.method, path, nil)
+			if tc.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.bearer)
+			}
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "Authorization", Value: tc.cookie})
+			}
+			if tc.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: auth.CSRFCookie, Value: tc.csrfCookie})
+			}
+			if tc.csrfHeader != "" {
+				r.Header.Set(auth.CSRFHeader, tc.csrfHeader)
+			}
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			if tc.expectedCode == http.StatusUnauthorized {
+				assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestServer_LoginThrottling(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	options := &config.Options{Auth: config.AuthOptions{LoginMaxFailures: 5, LoginIPMaxFailures: 50, LoginLockout: time.Minute, LoginWindow: time.Hour, LoginDelay: time.Second, LoginMaxDelay: time.Minute}}
+	loginPolicy := models.LoginPolicy{MaxFailures: 5, Lockout: time.Minute, Window: time.Hour, Delay: time.Second, MaxDelay: time.Minute}
+	ipPolicy := loginPolicy
+	ipPolicy.MaxFailures = 50
+	rm.EXPECT().AuthorizeUser(gomock.Any(), "test").Return(models.NewUser("test", "test"), nil).AnyTimes()
+	// Счётчик IP-адреса проверяется до пароля, а неудача учитывается в нём без паузы только после неверного пароля
+	failurePolicy := ipPolicy
+	failurePolicy.Delay = 0
+	rm.EXPECT().CheckLogin(gomock.Any(), models.LoginScopeIP, "192.0.2.1", ipPolicy).Return(models.LoginAttempt{Allowed: true}, nil).Times(3)
+	rm.EXPECT().CheckLogin(gomock.Any(), models.LoginScopeIP, "203.0.113.9", ipPolicy).Return(models.LoginAttempt{Allowed: true}, nil).Times(1)
+	rm.EXPECT().CheckLogin(gomock.Any(), models.LoginScopeIP, "198.51.100.1", ipPolicy).
+		Return(models.LoginAttempt{Failures: 3, RetryAfter: 3500 * time.Millisecond}, nil).Times(1)
+	rm.EXPECT().AttemptLogin(gomock.Any(), models.LoginScopeIP, "192.0.2.1", failurePolicy).Return(models.LoginAttempt{Allowed: true, Failures: 1}, nil).Times(2)
+	rm.EXPECT().AttemptLogin(gomock.Any(), models.LoginScopeLogin, "test", loginPolicy).Return(models.LoginAttempt{Allowed: true, Failures: 1}, nil).Times(3)
+	rm.EXPECT().AttemptLogin(gomock.Any(), models.LoginScopeLogin, "locked", loginPolicy).
+		Return(models.LoginAttempt{Failures: 5, LockedUntil: time.Now().Add(time.Minute), RetryAfter: time.Minute}, nil).Times(1)
+	rm.EXPECT().ResetLoginFailures(gomock.Any(), models.LoginScopeLogin, "test").Return(nil).Times(1)
+	rm.EXPECT().CreateSession(gomock.Any(), gomock.Any()).Return(models.Session{ID: "session"}, nil).AnyTimes()
+
+	options.HTTP.TrustedProxies = "192.0.2.0/24"
+	sh := NewRouter(options, &store, events.NewBroker())
+
+	testCases := []struct {
+		name               string
+		remoteAddr         string
+		forwardedFor       string
+		requestBody        string
+		expectedCode       int
+		expectedRetryAfter string
+	}{
+		{name: "wrong password counted", remoteAddr: "192.0.2.1:1234", requestBody: `{"login":"test","password":"wrong"}`, expectedCode: http.StatusUnauthorized},
+		{name: "locked login", remoteAddr: "192.0.2.1:1234", requestBody: `{"login":"locked","password":"test"}`, expectedCode: http.StatusTooManyRequests, expectedRetryAfter: "60"},
+		{name: "progressive delay for ip", remoteAddr: "198.51.100.1:1234", forwardedFor: "203.0.113.9", requestBody: `{"login":"slow","password":"test"}`, expectedCode: http.StatusTooManyRequests, expectedRetryAfter: "4"},
+		{name: "spoofed header behind trusted proxy", remoteAddr: "192.0.2.1:1234", forwardedFor: "203.0.113.9, 192.0.2.1", requestBody: `{"login":"test","password":"test"}`, expectedCode: http.StatusOK},
+		{name: "malformed forwarded for ignored", remoteAddr: "192.0.2.1:1234", forwardedFor: "not-an-ip", requestBody: `{"login":"test","password":"wrong"}`, expectedCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/login", strings.NewReader(tc.requestBody))
+			r.RemoteAddr = tc.remoteAddr
+			r.Header.Set("Content-Type", "application/json")
+			if tc.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, tc.expectedRetryAfter, w.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestServer_Admin(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	rm.EXPECT().UnlockLogin(gomock.Any(), models.LoginScopeLogin, "test", "admin@192.0.2.1").Return(nil).Times(1)
+	rm.EXPECT().UnlockLogin(gomock.Any(), models.LoginScopeIP, "192.0.2.7", gomock.Any()).Return(storage.ErrNotFound).Times(1)
+	rm.EXPECT().GetLoginLockouts(gomock.Any(), 100).Return([]models.LoginLockout{{ID: 1, Scope: models.LoginScopeLogin, Key: "test", Failures: 5}}, nil).Times(1)
+
+	testCases := []struct {
+		name         string
+		adminToken   string
+		method       string
+		path         string
+		header       string
+		requestBody  string
+		expectedCode int
+	}{
+		{name: "admin api disabled", method: http.MethodGet, path: "/api/admin/login-lockouts", header: "", expectedCode: http.StatusNotFound},
+		{name: "wrong admin token", adminToken: "admin", method: http.MethodGet, path: "/api/admin/login-lockouts", header: "wrong", expectedCode: http.StatusForbidden},
+		{name: "lockouts audit", adminToken: "admin", method: http.MethodGet, path: "/api/admin/login-lockouts", header: "admin", expectedCode: http.StatusOK},
+		{name: "invalid limit", adminToken: "admin", method: http.MethodGet, path: "/api/admin/login-lockouts?limit=0", header: "admin", expectedCode: http.StatusBadRequest},
+		{name: "unlock login", adminToken: "admin", method: http.MethodPost, path: "/api/admin/login-lockouts/unlock", header: "admin", requestBody: `{"login":"test"}`, expectedCode: http.StatusOK},
+		{name: "unlock unknown ip", adminToken: "admin", method: http.MethodPost, path: "/api/admin/login-lockouts/unlock", header: "admin", requestBody: `{"ip":"192.0.2.7"}`, expectedCode: http.StatusNotFound},
+		{name: "unlock both", adminToken: "admin", method: http.MethodPost, path: "/api/admin/login-lockouts/unlock", header: "admin", requestBody: `{"login":"test","ip":"192.0.2.7"}`, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sh := NewRouter(&config.Options{Auth: config.AuthOptions{AdminToken: tc.adminToken}}, &store, events.NewBroker())
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.requestBody))
+			if tc.header != "" {
+				r.Header.Set(auth.AdminTokenHeader, tc.header)
+			}
+			if tc.requestBody != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+		})
+	}
+}
+
+func TestServer_Problems(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	rm.EXPECT().IsSessionActive(gomock.Any(), "session").Return(true, nil).AnyTimes()
+	sh := NewRouter(&config.Options{}, &store, events.NewBroker())
+	JWTToken, _ := auth.BuildJWTString("test", "session")
+
+	testCases := []struct {
+		name            string
+		path            string
+		contentType     string
+		authorized      bool
+		requestBody     string
+		expectedCode    int
+		expectedProblem string
+		expectedFields  []string
+	}{
+		{name: "empty credentials", path: "/api/user/register", contentType: "application/json", requestBody: `{}`, expectedCode: http.StatusBadRequest, expectedProblem: problem.CodeValidationFailed, expectedFields: []string{"login", "password"}},
+		{name: "empty login", path: "/api/user/login", contentType: "application/json", requestBody: `{"password":"test"}`, expectedCode: http.StatusBadRequest, expectedProblem: problem.CodeValidationFailed, expectedFields: []string{"login"}},
+		{name: "too long password", path: "/api/user/register", contentType: "application/json", requestBody: `{"login":"test","password":"` + strings.Repeat("a", 73) + `"}`, expectedCode: http.StatusBadRequest, expectedProblem: problem.CodeValidationFailed, expectedFields: []string{"password"}},
+		{name: "malformed json", path: "/api/user/register", contentType: "application/json", requestBody: `{"login":`, expectedCode: http.StatusBadRequest, expectedProblem: problem.CodeInvalidJSON},
+		{name: "content type", path: "/api/user/login", contentType: "text/plain", requestBody: `{}`, expectedCode: http.StatusBadRequest, expectedProblem: problem.CodeInvalidContentType},
+		{name: "body too large", path: "/api/user/login", contentType: "application/json", requestBody: `{"login":"` + strings.Repeat("a", validation.MaxBodySize) + `"}`, expectedCode: http.StatusRequestEntityTooLarge, expectedProblem: problem.CodeBodyTooLarge},
+		{name: "negative sum", path: "/api/user/balance/withdraw", contentType: "application/json", authorized: true, requestBody: `{"order":"2377225624","sum":-1}`, expectedCode: http.StatusUnprocessableEntity, expectedProblem: problem.CodeValidationFailed, expectedFields: []string{"sum"}},
+		{name: "withdrawal luhn", path: "/api/user/balance/withdraw", contentType: "application/json", authorized: true, requestBody: `{"order":"123","sum":1}`, expectedCode: http.StatusUnprocessableEntity, expectedProblem: problem.CodeInvalidOrderNumber},
+		{name: "invalid order number", path: "/api/user/orders", contentType: "text/plain", authorized: true, requestBody: "123", expectedCode: http.StatusUnprocessableEntity, expectedProblem: problem.CodeInvalidOrderNumber},
+		{name: "unauthorized", path: "/api/user/orders", contentType: "text/plain", requestBody: "12345678903", expectedCode: http.StatusUnauthorized, expectedProblem: problem.CodeUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.requestBody))
+			r.Header.Set("Content-Type", tc.contentType)
+			if tc.authorized {
+				r.Header.Set("Authorization", "Bearer "+JWTToken)
+			}
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+			p := problem.Problem{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			assert.Equal(t, tc.expectedCode, p.Status)
+			assert.Equal(t, tc.expectedProblem, p.Code)
+			assert.Equal(t, tc.path, p.Instance)
+			fields := make([]string, 0)
+			for _, fieldError := range p.Errors {
+				fields = append(fields, fieldError.Field)
+			}
+			assert.ElementsMatch(t, tc.expectedFields, fields)
+		})
+	}
+}
+
+func TestServer_Pagination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	uploadedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	next := &pagination.Cursor{Time: uploadedAt, Key: "12345678903", Descending: true}
+	rm.EXPECT().IsSessionActive(gomock.Any(), "session").Return(true, nil).AnyTimes()
+	rm.EXPECT().
+		ListUsersOrders(gomock.Any(), pagination.Query{Limit: 1, Statuses: []string{"NEW", "PROCESSED"}, Descending: true}).
+		Return([]models.Order{{Number: "12345678903", Status: "NEW", UploadedAt: uploadedAt}}, next, nil).
+		Times(1)
+	rm.EXPECT().
+		ListUsersWithdrawals(gomock.Any(), pagination.Query{Descending: true}).
+		Return([]models.Withdrawal{}, nil, nil).
+		Times(1)
+
+	sh := NewRouter(&config.Options{}, &store, events.NewBroker())
+	JWTToken, _ := auth.BuildJWTString("test", "session")
+
+	testCases := []struct {
+		name           string
+		path           string
+		expectedCode   int
+		expectedLink   string
+		expectedFields []string
+	}{
+		{name: "next page link", path: "/api/user/orders?limit=1&status=NEW,PROCESSED", expectedCode: http.StatusOK,
+			expectedLink: `</api/user/orders?cursor=` + next.Encode() + `&limit=1&status=NEW%2CPROCESSED>; rel="next"`},
+		{name: "last page", path: "/api/user/withdrawals", expectedCode: http.StatusNoContent},
+		{name: "invalid parameters", path: "/api/user/orders?limit=0&status=DONE&sort=up&from=yesterday", expectedCode: http.StatusUnprocessableEntity,
+			expectedFields: []string{"limit", "sort", "status", "from"}},
+		{name: "status filter on withdrawals", path: "/api/user/withdrawals?status=NEW", expectedCode: http.StatusUnprocessableEntity,
+			expectedFields: []string{"status"}},
+		{name: "cursor of another direction", path: "/api/user/withdrawals?sort=asc&cursor=" + next.Encode(), expectedCode: http.StatusUnprocessableEntity,
+			expectedFields: []string{"cursor"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			r.Header.Set("Authorization", "Bearer "+JWTToken)
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, tc.expectedLink, w.Header().Get("Link"))
+			if tc.expectedFields != nil {
+				p := problem.Problem{}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+				fields := make([]string, 0)
+				for _, fieldError := range p.Errors {
+					fields = append(fields, fieldError.Field)
+				}
+				assert.Equal(t, tc.expectedFields, fields)
+			}
+		})
+	}
+}
+
+func TestServer_GetOrder(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	uploadedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	rm.EXPECT().IsSessionActive(gomock.Any(), "session").Return(true, nil).AnyTimes()
+	rm.EXPECT().GetUsersOrder(gomock.Any(), "12345678903").
+		Return(models.Order{Number: "12345678903", UserID: "test", Status: "PROCESSED", Accrual: 50050, UploadedAt: uploadedAt, AccrualCheckedAt: uploadedAt.Add(time.Minute)}, nil).
+		AnyTimes()
+	rm.EXPECT().GetUsersOrder(gomock.Any(), "6400700313").Return(models.Order{}, storage.ErrNotFound).Times(1)
+
+	sh := NewRouter(&config.Options{}, &store, events.NewBroker())
+	JWTToken, _ := auth.BuildJWTString("test", "session")
+	get := func(path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer "+JWTToken)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		sh.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/api/user/orders/12345678903", "")
+	assert.Equal(t, http.StatusOK, w.Code, "Код ответа не совпадает с ожидаемым")
+	assert.JSONEq(t, `{"number":"12345678903","status":"PROCESSED","accrual":500.5,"uploaded_at":"2024-05-01T10:00:00Z","accrual_checked_at":"2024-05-01T10:01:00Z"}`, w.Body.String())
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	w = get("/api/user/orders/12345678903", `"other", `+etag)
+	assert.Equal(t, http.StatusNotModified, w.Code, "Код ответа не совпадает с ожидаемым")
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = get("/api/user/orders/12345678903", `W/"other"`)
+	assert.Equal(t, http.StatusOK, w.Code, "Код ответа не совпадает с ожидаемым")
+
+	w = get("/api/user/orders/6400700313", "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "Код ответа не совпадает с ожидаемым")
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+}
+
+func TestServer_Events(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	broker := events.NewBroker()
+	rm.EXPECT().IsSessionActive(gomock.Any(), "session").Return(true, nil).AnyTimes()
+	sh := NewRouter(&config.Options{}, &store, broker)
+	JWTToken, _ := auth.BuildJWTString("test", "session")
+
+	stream := func(t *testing.T, lastEventID string, cancel context.CancelFunc, ctx context.Context) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/user/events", nil).WithContext(ctx)
+		r.Header.Set("Authorization", "Bearer "+JWTToken)
+		if lastEventID != "" {
+			r.Header.Set("Last-Event-ID", lastEventID)
+		}
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			sh.ServeHTTP(w, r)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			cancel()
+			t.Fatal("Event stream did not stop")
+		}
+		return w
+	}
+
+	t.Run("resume from Last-Event-ID", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		rm.EXPECT().GetUserEvents(gomock.Any(), "test", int64(5), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, userID string, afterID int64, limit int) ([]models.UserEvent, error) {
+				cancel()
+				return []models.UserEvent{
+					{ID: 6, Type: models.UserEventOrder, Payload: []byte(`{"number":"12345678903","status":"PROCESSED","accrual":500}`)},
+					{ID: 7, Type: models.UserEventBalance, Payload: []byte(`{"current":500,"withdrawn":0}`)},
+				}, nil
+			})
+
+		w := stream(t, "5", cancel, ctx)
+		assert.Equal(t, http.StatusOK, w.Code, "Код ответа не совпадает с ожидаемым")
+		assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+		assert.Equal(t, "retry: 3000\n\n"+
+			"id: 6\nevent: order\ndata: {\"number\":\"12345678903\",\"status\":\"PROCESSED\",\"accrual\":500}\n\n"+
+			"id: 7\nevent: balance\ndata: {\"current\":500,\"withdrawn\":0}\n\n", w.Body.String())
+	})
+
+	t.Run("new events after notification", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		rm.EXPECT().GetLastUserEventID(gomock.Any(), "test").Return(int64(7), nil)
+		gomock.InOrder(
+			rm.EXPECT().GetUserEvents(gomock.Any(), "test", int64(7), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, userID string, afterID int64, limit int) ([]models.UserEvent, error) {
+					broker.Notify("test")
+					return []models.UserEvent{}, nil
+				}),
+			rm.EXPECT().GetUserEvents(gomock.Any(), "test", int64(7), gomock.Any()).DoAndReturn(
+				func(ctx context.Context, userID string, afterID int64, limit int) ([]models.UserEvent, error) {
+					cancel()
+					return []models.UserEvent{{ID: 8, Type: models.UserEventBalance, Payload: []byte(`{"current":0,"withdrawn":500}`)}}, nil
+				}),
+		)
+
+		w := stream(t, "", cancel, ctx)
+		assert.Equal(t, "retry: 3000\n\nid: 8\nevent: balance\ndata: {\"current\":0,\"withdrawn\":500}\n\n", w.Body.String())
+	})
+
+	t.Run("invalid Last-Event-ID", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w := stream(t, "latest", cancel, ctx)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "Код ответа не совпадает с ожидаемым")
+	})
+
+	t.Run("stream ends on shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		rm.EXPECT().GetLastUserEventID(gomock.Any(), "test").Return(int64(8), nil)
+		rm.EXPECT().GetUserEvents(gomock.Any(), "test", int64(8), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, userID string, afterID int64, limit int) ([]models.UserEvent, error) {
+				broker.Close()
+				return []models.UserEvent{}, nil
+			})
+
+		w := stream(t, "", cancel, ctx)
+		assert.Equal(t, "retry: 3000\n\n", w.Body.String(), "Stream closed without waiting for client")
+	})
+}
+
+func TestServer_Webhooks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	endpointID, deliveryID := "0b6f4a3e-1f2c-4d5e-8a9b-0c1d2e3f4a5b", "7d2c9e14-5a6b-4c3d-9e8f-1a2b3c4d5e6f"
+	subscribed := []string{models.WebhookEventOrderProcessed, models.WebhookEventWithdrawalCreated}
+	rm.EXPECT().IsSessionActive(gomock.Any(), "session").Return(true, nil).AnyTimes()
+	rm.EXPECT().CreateWebhookEndpoint(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, endpoint models.WebhookEndpoint) (models.WebhookEndpoint, error) {
+			assert.Equal(t, "test", endpoint.UserID)
+			assert.True(t, strings.HasPrefix(endpoint.Secret, "whsec_"), "Secret generated when not given")
+			endpoint.ID = "endpoint"
+			endpoint.CreatedAt = createdAt
+			return endpoint, nil
+		}).Times(1)
+	rm.EXPECT().CreateWebhookEndpoint(gomock.Any(), models.WebhookEndpoint{URL: "https://partner.example/all", Secret: "0123456789abcdef", Events: subscribed}).
+		Return(models.WebhookEndpoint{ID: "admin", URL: "https://partner.example/all", Secret: "0123456789abcdef", Events: subscribed, CreatedAt: createdAt}, nil).Times(1)
+	rm.EXPECT().GetWebhookEndpoints(gomock.Any(), "test").
+		Return([]models.WebhookEndpoint{{ID: "endpoint", UserID: "test", URL: "https://partner.example/hook", Secret: "whsec_secret", Events: subscribed, CreatedAt: createdAt}}, nil).Times(1)
+	rm.EXPECT().DeleteWebhookEndpoint(gomock.Any(), "test", "9f8e7d6c-5b4a-4392-8170-6f5e4d3c2b1a").Return(storage.ErrNotFound).Times(1)
+	rm.EXPECT().GetWebhookDeliveries(gomock.Any(), "test", endpointID, 10).
+		Return([]models.WebhookDelivery{{ID: "delivery", EndpointID: "endpoint", EventType: models.WebhookEventOrderProcessed, Payload: []byte(`{}`), Status: models.WebhookDeliveryDead, Attempts: 10}}, nil).Times(1)
+	rm.EXPECT().RedeliverWebhook(gomock.Any(), "test", endpointID, deliveryID).Return(nil).Times(1)
+
+	sh := NewRouter(&config.Options{Auth: config.AuthOptions{AdminToken: "admin"}}, &store, events.NewBroker())
+	JWTToken, _ := auth.BuildJWTString("test", "session")
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		admin        bool
+		requestBody  string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "create with generated secret", method: http.MethodPost, path: "/api/user/webhooks",
+			requestBody: `{"url":"https://partner.example/hook","events":["order.processed","withdrawal.created"]}`, expectedCode: http.StatusCreated},
+		{name: "invalid endpoint", method: http.MethodPost, path: "/api/user/webhooks",
+			requestBody: `{"url":"ftp://partner.example","secret":"short","events":["order.created"]}`, expectedCode: http.StatusUnprocessableEntity},
+		{name: "list hides secret", method: http.MethodGet, path: "/api/user/webhooks", expectedCode: http.StatusOK,
+			expectedBody: `[{"id":"endpoint","url":"https://partner.example/hook","events":["order.processed","withdrawal.created"],"created_at":"2024-05-01T10:00:00Z"}]`},
+		{name: "delete unknown", method: http.MethodDelete, path: "/api/user/webhooks/9f8e7d6c-5b4a-4392-8170-6f5e4d3c2b1a", expectedCode: http.StatusNotFound},
+		{name: "delete malformed id", method: http.MethodDelete, path: "/api/user/webhooks/missing", expectedCode: http.StatusNotFound},
+		{name: "delivery log", method: http.MethodGet, path: "/api/user/webhooks/" + endpointID + "/deliveries?limit=10", expectedCode: http.StatusOK},
+		{name: "delivery log of malformed id", method: http.MethodGet, path: "/api/user/webhooks/endpoint/deliveries", expectedCode: http.StatusNotFound},
+		{name: "invalid delivery log limit", method: http.MethodGet, path: "/api/user/webhooks/" + endpointID + "/deliveries?limit=0", expectedCode: http.StatusBadRequest},
+		{name: "redeliver dead letter", method: http.MethodPost, path: "/api/user/webhooks/" + endpointID + "/deliveries/" + deliveryID + "/redeliver", expectedCode: http.StatusAccepted},
+		{name: "redeliver malformed delivery id", method: http.MethodPost, path: "/api/user/webhooks/" + endpointID + "/deliveries/1/redeliver", expectedCode: http.StatusNotFound},
+		{name: "admin endpoint for all users", method: http.MethodPost, path: "/api/admin/webhooks", admin: true,
+			requestBody: `{"url":"https://partner.example/all","secret":"0123456789abcdef","events":["order.processed","withdrawal.created"]}`, expectedCode: http.StatusCreated,
+			expectedBody: `{"id":"admin","url":"https://partner.example/all","events":["order.processed","withdrawal.created"],"secret":"0123456789abcdef","created_at":"2024-05-01T10:00:00Z"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.requestBody))
+			r.Header.Set("Content-Type", "application/json")
+			if tc.admin {
+				r.Header.Set(auth.AdminTokenHeader, "admin")
+			} else {
+				r.Header.Set("Authorization", "Bearer "+JWTToken)
+			}
+			w := httptest.NewRecorder()
+
+			sh.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			if tc.expectedBody != "" {
+				assert.JSONEq(t, tc.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	var store storage.Repository = rm
+	sh := NewRouter(&config.Options{}, &store, events.NewBroker())
+
+	sh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/user/orders/12345678903", nil))
+
+	w := httptest.NewRecorder()
+	NewAdminRouter(health.NewChecker()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code, "Код ответа не совпадает с ожидаемым")
+	assert.Contains(t, w.Body.String(), `gophermart_http_requests_total{code="401",method="GET",route="/api/user/orders/{number}"}`)
+
+	w = httptest.NewRecorder()
+	sh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "Metrics are not exposed on public listener")
+}
+
+// TestServer_CookieFlow проходит сценарий спецификации клиентом, который знает только cookie: настройки по умолчанию
+// не должны требовать ни HTTPS, ни CSRF-заголовка.
+func TestServer_CookieFlow(t *testing.T) {
+	options := config.Default()
+	var store storage.Repository = storage.NewMemoryStorage()
+	srv := httptest.NewServer(NewRouter(&options, &store, events.NewBroker()))
+	defer srv.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	post := func(path string, contentType string, body string) *http.Response {
+		res, err := client.Post(srv.URL+path, contentType, strings.NewReader(body))
+		require.NoError(t, err)
+		defer res.Body.Close()
+		return res
+	}
+
+	res := post("/api/user/register", "application/json", `{"login":"cookie","password":"passw0rd"}`)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.False(t, res.Cookies()[0].Secure, "Plain HTTP cookie jar sends auth cookie back")
+
+	res = post("/api/user/orders", "text/plain", "2377225624")
+	assert.Equal(t, http.StatusAccepted, res.StatusCode, "Cookie authorizes upload without CSRF header")
+	res = post("/api/user/orders", "text/plain", "12345678903")
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	res, err = client.Get(srv.URL + "/api/user/orders")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var orders []struct {
+		Number string `json:"number"`
+		Status string `json:"status"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&orders))
+	require.Len(t, orders, 2)
+	assert.Equal(t, "12345678903", orders[0].Number, "Orders are listed from newest to oldest by default")
+	assert.Equal(t, "2377225624", orders[1].Number)
+	assert.Equal(t, "NEW", orders[0].Status)
+}
+
+// TestServer_AccrualFlow проверяет путь заказа целиком: загрузка через API, опрос заглушки системы расчёта
+// и начисление баллов на баланс в хранилище в памяти.
+func TestServer_AccrualFlow(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script("12345678903", accrualtest.Registered(), accrualtest.Processing(), accrualtest.Processed(72998))
+
+	options := config.Default()
+	options.Accrual.Address = ts.URL
+	options.Accrual.IdleInterval = 5 * time.Millisecond
+	options.Accrual.MaxBackoff = 50 * time.Millisecond
+	var store storage.Repository = storage.NewMemoryStorage()
+	srv := httptest.NewServer(NewRouter(&options, &store, events.NewBroker()))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		accrual.NewOrdersAccrualClient(&options, store).ScrapeOrders(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	post := func(path string, contentType string, body string) int {
+		res, err := client.Post(srv.URL+path, contentType, strings.NewReader(body))
+		require.NoError(t, err)
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+	// get не останавливает тест сам, потому что вызывается и из горутины assert.Eventually
+	get := func(path string, target interface{}) bool {
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			return false
+		}
+		defer res.Body.Close()
+		return res.StatusCode == http.StatusOK && json.NewDecoder(res.Body).Decode(target) == nil
+	}
+
+	require.Equal(t, http.StatusOK, post("/api/user/register", "application/json", `{"login":"accrual","password":"passw0rd"}`))
+	require.Equal(t, http.StatusAccepted, post("/api/user/orders", "text/plain", "12345678903"))
+
+	var order struct {
+		Status  string       `json:"status"`
+		Accrual models.Money `json:"accrual"`
+	}
+	assert.Eventually(t, func() bool {
+		return get("/api/user/orders/12345678903", &order) && order.Status == "PROCESSED"
+	}, 2*time.Second, 10*time.Millisecond, "Order reaches PROCESSED")
+	assert.Equal(t, models.Money(72998), order.Accrual)
+	assert.Equal(t, 3, ts.Calls("12345678903"), "Order is polled until final status")
+
+	var balance dto.UserBalanceResponse
+	require.True(t, get("/api/user/balance", &balance))
+	assert.Equal(t, dto.UserBalanceResponse{Current: 72998}, balance, "Accrual credited to balance")
+}
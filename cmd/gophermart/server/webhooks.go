@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/dto"
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/problem"
+	"github.com/PaBah/gofermart/internal/storage"
+	"github.com/PaBah/gofermart/internal/validation"
+	"github.com/PaBah/gofermart/internal/webhook"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const defaultDeliveriesLimit = 100
+
+// webhookOwner определяет владельца эндпоинтов: пользователь или, для пустой строки, администратор.
+type webhookOwner func(req *http.Request) string
+
+func userWebhooks(req *http.Request) string {
+	return req.Context().Value(auth.ContextUserKey).(string)
+}
+
+func adminWebhooks(*http.Request) string {
+	return ""
+}
+
+// validIDs проверяет идентификаторы из пути: в базе они хранятся как uuid, поэтому другая строка не найдёт
+// ничего, как и в хранилище в памяти, а не сломает запрос.
+func validIDs(ids ...string) bool {
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newWebhookEndpointResponse(endpoint models.WebhookEndpoint) dto.WebhookEndpointResponse {
+	return dto.WebhookEndpointResponse{
+		ID:        endpoint.ID,
+		URL:       endpoint.URL,
+		Events:    endpoint.Events,
+		CreatedAt: dto.JSONTime(endpoint.CreatedAt),
+	}
+}
+
+func writeJSON(res http.ResponseWriter, req *http.Request, status int, data interface{}) {
+	response, err := json.Marshal(data)
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	_, err = res.Write(response)
+	if err != nil {
+		logger.Log().Error("Can not send response from "+req.Method+" "+req.URL.Path, zap.Error(err))
+	}
+}
+
+// createWebhookHandle регистрирует эндпоинт; секрет подписи возвращается только в этом ответе.
+func (s Server) createWebhookHandle(owner webhookOwner) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		requestData := &dto.WebhookEndpointRequest{}
+		err := validation.DecodeJSON(req, requestData)
+		if err == nil {
+			err = validation.WebhookEndpoint(*requestData, s.options.Dev)
+		}
+		if err != nil {
+			validation.WriteError(res, req, err)
+			return
+		}
+
+		secret := requestData.Secret
+		if secret == "" {
+			secret, err = webhook.NewSecret()
+			if err != nil {
+				problem.Internal(res, req, err)
+				return
+			}
+		}
+
+		endpoint, err := s.storage.CreateWebhookEndpoint(req.Context(), models.WebhookEndpoint{
+			UserID: owner(req),
+			URL:    requestData.URL,
+			Secret: secret,
+			Events: requestData.Events,
+		})
+		if err != nil {
+			problem.Internal(res, req, err)
+			return
+		}
+
+		responseData := newWebhookEndpointResponse(endpoint)
+		responseData.Secret = endpoint.Secret
+		writeJSON(res, req, http.StatusCreated, responseData)
+	}
+}
+
+func (s Server) getWebhooksHandle(owner webhookOwner) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		endpoints, err := s.storage.GetWebhookEndpoints(req.Context(), owner(req))
+		if err != nil {
+			problem.Internal(res, req, err)
+			return
+		}
+
+		if len(endpoints) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		responseData := make([]dto.WebhookEndpointResponse, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			responseData = append(responseData, newWebhookEndpointResponse(endpoint))
+		}
+		writeJSON(res, req, http.StatusOK, responseData)
+	}
+}
+
+func (s Server) deleteWebhookHandle(owner webhookOwner) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		id := chi.URLParam(req, "id")
+		if !validIDs(id) {
+			problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "Webhook not found")
+			return
+		}
+
+		err := s.storage.DeleteWebhookEndpoint(req.Context(), owner(req), id)
+		if errors.Is(err, storage.ErrNotFound) {
+			problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "Webhook not found")
+			return
+		}
+		if err != nil {
+			problem.Internal(res, req, err)
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getWebhookDeliveriesHandle отдаёт журнал доставок эндпоинта, новые первыми.
+func (s Server) getWebhookDeliveriesHandle(owner webhookOwner) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		id := chi.URLParam(req, "id")
+		if !validIDs(id) {
+			problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "Webhook not found")
+			return
+		}
+
+		limit := defaultDeliveriesLimit
+		if value := req.URL.Query().Get("limit"); value != "" {
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 {
+				problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid limit")
+				return
+			}
+		}
+
+		deliveries, err := s.storage.GetWebhookDeliveries(req.Context(), owner(req), id, limit)
+		if errors.Is(err, storage.ErrNotFound) {
+			problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "Webhook not found")
+			return
+		}
+		if err != nil {
+			problem.Internal(res, req, err)
+			return
+		}
+
+		if len(deliveries) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(res, req, http.StatusOK, deliveries)
+	}
+}
+
+// redeliverWebhookHandle возвращает доставку в очередь, в том числе из dead letter.
+func (s Server) redeliverWebhookHandle(owner webhookOwner) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		id, deliveryID := chi.URLParam(req, "id"), chi.URLParam(req, "deliveryID")
+		if !validIDs(id, deliveryID) {
+			problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "Webhook delivery not found")
+			return
+		}
+
+		err := s.storage.RedeliverWebhook(req.Context(), owner(req), id, deliveryID)
+		if errors.Is(err, storage.ErrNotFound) {
+			problem.Error(res, req, http.StatusNotFound, problem.CodeNotFound, "Webhook delivery not found")
+			return
+		}
+		if err != nil {
+			problem.Internal(res, req, err)
+			return
+		}
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
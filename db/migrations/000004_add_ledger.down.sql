@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS balances;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TYPE IF EXISTS ledger_entry_type;
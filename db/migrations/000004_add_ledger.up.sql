@@ -0,0 +1,31 @@
+CREATE TYPE ledger_entry_type AS ENUM ('ACCRUAL', 'WITHDRAWAL');
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL references users(id),
+    order_number VARCHAR NOT NULL,
+    entry_type ledger_entry_type NOT NULL,
+    amount NUMERIC NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_accrual_order_idx ON ledger_entries(order_number) WHERE entry_type = 'ACCRUAL';
+CREATE INDEX IF NOT EXISTS ledger_entries_user_id_idx ON ledger_entries(user_id);
+
+CREATE TABLE IF NOT EXISTS balances (
+    user_id uuid PRIMARY KEY references users(id),
+    current NUMERIC NOT NULL DEFAULT 0,
+    withdrawn NUMERIC NOT NULL DEFAULT 0
+);
+
+INSERT INTO ledger_entries(user_id, order_number, entry_type, amount, created_at)
+SELECT user_id, number, 'ACCRUAL', accrual, uploaded_at FROM orders
+WHERE status = 'PROCESSED' AND accrual > 0 AND user_id IS NOT NULL;
+
+INSERT INTO ledger_entries(user_id, order_number, entry_type, amount, created_at)
+SELECT user_id, number, 'WITHDRAWAL', -sum, processed_at FROM withdrawals
+WHERE sum > 0 AND user_id IS NOT NULL;
+
+INSERT INTO balances(user_id, current, withdrawn)
+SELECT user_id, SUM(amount), SUM(CASE WHEN entry_type = 'WITHDRAWAL' THEN -amount ELSE 0 END)
+FROM ledger_entries GROUP BY user_id;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS accrual_jobs (
+    order_number VARCHAR PRIMARY KEY references orders(number) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS accrual_jobs_next_attempt_at_idx ON accrual_jobs(next_attempt_at);
+
+INSERT INTO accrual_jobs(order_number)
+SELECT number FROM orders WHERE status IN ('NEW', 'PROCESSING')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    scope VARCHAR(16) NOT NULL,
+    key VARCHAR NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id BIGSERIAL PRIMARY KEY,
+    scope VARCHAR(16) NOT NULL,
+    key VARCHAR NOT NULL,
+    failures INTEGER NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    unlocked_at TIMESTAMP WITH TIME ZONE,
+    unlocked_by VARCHAR
+);
+
+CREATE INDEX IF NOT EXISTS login_lockouts_scope_key_idx ON login_lockouts(scope, key);
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS orders_user_id_uploaded_at_idx ON orders(user_id, uploaded_at, number);
+CREATE INDEX IF NOT EXISTS withdrawals_user_id_processed_at_idx ON withdrawals(user_id, processed_at, id);
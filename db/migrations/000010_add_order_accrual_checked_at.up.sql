@@ -0,0 +1 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS accrual_checked_at TIMESTAMP WITH TIME ZONE;
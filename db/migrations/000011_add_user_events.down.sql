@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS balances_update_event ON balances;
+DROP TRIGGER IF EXISTS balances_insert_event ON balances;
+DROP TRIGGER IF EXISTS orders_status_event ON orders;
+DROP FUNCTION IF EXISTS record_balance_event();
+DROP FUNCTION IF EXISTS record_order_event();
+DROP TABLE IF EXISTS user_events;
+DROP FUNCTION IF EXISTS notify_user_event();
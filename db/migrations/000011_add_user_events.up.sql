@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS user_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id uuid NOT NULL references users(id) ON DELETE CASCADE,
+    event_type VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_events_user_id_idx ON user_events(user_id, id);
+
+-- Каждое событие будит подписчиков на всех экземплярах сервиса; полезная нагрузка — только пользователь,
+-- сами события читаются из таблицы, поэтому пропущенное уведомление не теряет данных.
+CREATE OR REPLACE FUNCTION notify_user_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('user_events', NEW.user_id::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_events_notify AFTER INSERT ON user_events
+    FOR EACH ROW EXECUTE FUNCTION notify_user_event();
+
+CREATE OR REPLACE FUNCTION record_order_event() RETURNS trigger AS $$
+BEGIN
+    IF NEW.user_id IS NOT NULL THEN
+        INSERT INTO user_events(user_id, event_type, payload)
+        VALUES (NEW.user_id, 'order', json_build_object(
+            'number', NEW.number, 'status', NEW.status, 'accrual', NEW.accrual, 'uploaded_at', NEW.uploaded_at));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_status_event AFTER UPDATE ON orders
+    FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status OR OLD.accrual IS DISTINCT FROM NEW.accrual)
+    EXECUTE FUNCTION record_order_event();
+
+CREATE OR REPLACE FUNCTION record_balance_event() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO user_events(user_id, event_type, payload)
+    VALUES (NEW.user_id, 'balance', json_build_object('current', NEW.current, 'withdrawn', NEW.withdrawn));
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER balances_insert_event AFTER INSERT ON balances
+    FOR EACH ROW EXECUTE FUNCTION record_balance_event();
+
+CREATE TRIGGER balances_update_event AFTER UPDATE ON balances
+    FOR EACH ROW WHEN (OLD.current IS DISTINCT FROM NEW.current OR OLD.withdrawn IS DISTINCT FROM NEW.withdrawn)
+    EXECUTE FUNCTION record_balance_event();
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- NULL у эндпоинтов администратора: они получают события всех пользователей
+    user_id uuid references users(id) ON DELETE CASCADE,
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    events VARCHAR[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_endpoints_user_id_idx ON webhook_endpoints(user_id);
+
+CREATE TYPE webhook_delivery_status AS ENUM ('PENDING', 'DELIVERED', 'DEAD');
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    endpoint_id uuid NOT NULL references webhook_endpoints(id) ON DELETE CASCADE,
+    event_type VARCHAR NOT NULL,
+    payload JSONB NOT NULL,
+    status webhook_delivery_status NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_status_code INTEGER,
+    last_error VARCHAR,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries(next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_endpoint_id_idx ON webhook_deliveries(endpoint_id, created_at);
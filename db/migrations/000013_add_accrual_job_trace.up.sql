@@ -0,0 +1 @@
+ALTER TABLE accrual_jobs ADD COLUMN IF NOT EXISTS trace_parent TEXT;
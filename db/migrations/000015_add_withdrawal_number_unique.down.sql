@@ -0,0 +1 @@
+DROP INDEX IF EXISTS withdrawals_number_idx;
@@ -0,0 +1,17 @@
+-- Дубликаты списаний — деньги пользователей, поэтому миграция не удаляет их сама, а останавливается
+-- и перечисляет номера, которые оператор должен разобрать вручную.
+DO $$
+DECLARE
+    duplicates text;
+BEGIN
+    SELECT string_agg(number, ', ' ORDER BY number) INTO duplicates
+    FROM (SELECT number FROM withdrawals GROUP BY number HAVING count(*) > 1) AS d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'withdrawals contain duplicate order numbers: %', duplicates
+            USING HINT = 'Keep one withdrawal per number, remove the extra rows with their WITHDRAWAL ledger entries, restore balances, then rerun the migration.';
+    END IF;
+END;
+$$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawals_number_idx ON withdrawals(number);
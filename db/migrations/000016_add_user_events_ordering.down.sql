@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS user_events_created_at_idx;
+DROP TRIGGER IF EXISTS user_events_assign_id ON user_events;
+ALTER TABLE user_events ALTER COLUMN id SET DEFAULT nextval('user_events_id_seq');
+DROP FUNCTION IF EXISTS assign_user_event_id();
+DROP FUNCTION IF EXISTS lock_user_events(uuid);
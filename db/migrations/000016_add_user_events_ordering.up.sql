@@ -0,0 +1,24 @@
+-- Идентификатор события выдаётся под блокировкой пользователя, которая держится до конца транзакции. Поэтому
+-- события одного пользователя фиксируются в порядке id, и читатель, дошедший до id, не пропустит меньший,
+-- зафиксированный позже. Транзакции, меняющие баланс, берут эту блокировку раньше блокировки balances.
+CREATE OR REPLACE FUNCTION lock_user_events(target uuid) RETURNS void AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtextextended('user_events:' || target::text, 0));
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION assign_user_event_id() RETURNS trigger AS $$
+BEGIN
+    PERFORM lock_user_events(NEW.user_id);
+    NEW.id := nextval('user_events_id_seq');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Значение по умолчанию выдавалось бы до ожидания блокировки
+ALTER TABLE user_events ALTER COLUMN id DROP DEFAULT;
+
+CREATE TRIGGER user_events_assign_id BEFORE INSERT ON user_events
+    FOR EACH ROW EXECUTE FUNCTION assign_user_event_id();
+
+CREATE INDEX IF NOT EXISTS user_events_created_at_idx ON user_events(created_at);
@@ -0,0 +1,171 @@
+// Package accrualtest — управляемая заглушка системы расчёта начислений для тестов.
+//
+// Для каждого номера заказа задаётся сценарий — последовательность ответов.
+// Каждый запрос забирает следующий ответ, последний ответ повторяется.
+// Заказы без сценария отвечают 204.
+package accrualtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+)
+
+const ordersPath = "/api/orders/"
+
+type Response struct {
+	StatusCode        int
+	OrderStatus       string
+	Accrual           models.Money
+	RetryAfter        time.Duration
+	RequestsPerMinute int
+	Latency           time.Duration
+}
+
+type orderResponse struct {
+	Order   string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual models.Money `json:"accrual,omitempty"`
+}
+
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	scenarios map[string][]Response
+	calls     map[string]int
+	headers   map[string]http.Header
+	latency   time.Duration
+}
+
+func Registered() Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "REGISTERED"}
+}
+
+func Processing() Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "PROCESSING"}
+}
+
+func Processed(accrual models.Money) Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "PROCESSED", Accrual: accrual}
+}
+
+func Invalid() Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "INVALID"}
+}
+
+func NoContent() Response {
+	return Response{StatusCode: http.StatusNoContent}
+}
+
+func ServerError() Response {
+	return Response{StatusCode: http.StatusInternalServerError}
+}
+
+func TooManyRequests(retryAfter time.Duration, requestsPerMinute int) Response {
+	return Response{StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter, RequestsPerMinute: requestsPerMinute}
+}
+
+// WithLatency задерживает конкретный ответ.
+func (r Response) WithLatency(latency time.Duration) Response {
+	r.Latency = latency
+	return r
+}
+
+// Script задаёт сценарий ответов для заказа, заменяя предыдущий.
+func (s *Server) Script(order string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scenarios[order] = responses
+	s.calls[order] = 0
+}
+
+// SetLatency задерживает все ответы сервера.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = latency
+}
+
+// Calls возвращает число запросов по заказу.
+func (s *Server) Calls(order string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[order]
+}
+
+// Header возвращает заголовки последнего запроса по заказу.
+func (s *Server) Header(order string) http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.headers[order]
+}
+
+func (s *Server) next(order string, header http.Header) (Response, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.headers[order] = header.Clone()
+	scenario := s.scenarios[order]
+	step := s.calls[order]
+	s.calls[order]++
+
+	if len(scenario) == 0 {
+		return NoContent(), s.latency
+	}
+	if step >= len(scenario) {
+		step = len(scenario) - 1
+	}
+	return scenario[step], s.latency
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet || !strings.HasPrefix(r.URL.Path, ordersPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	order := strings.TrimPrefix(r.URL.Path, ordersPath)
+	response, latency := s.next(order, r.Header)
+
+	select {
+	case <-r.Context().Done():
+		return
+	case <-time.After(latency + response.Latency):
+	}
+
+	switch response.StatusCode {
+	case 0, http.StatusOK:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(orderResponse{Order: order, Status: response.OrderStatus, Accrual: response.Accrual})
+	case http.StatusTooManyRequests:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(int(response.RetryAfter.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = fmt.Fprintf(w, "No more than %d requests per minute allowed", response.RequestsPerMinute)
+	default:
+		w.WriteHeader(response.StatusCode)
+	}
+}
+
+// NewServer запускает заглушку; адрес доступен в поле URL, остановка — Close.
+func NewServer() *Server {
+	s := &Server{
+		scenarios: make(map[string][]Response),
+		calls:     make(map[string]int),
+		headers:   make(map[string]http.Header),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
@@ -0,0 +1,65 @@
+package accrualtest
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, s *Server, order string) (*http.Response, string) {
+	res, err := http.Get(s.URL + ordersPath + order)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestServer_Scenario(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Script("1", Registered(), Processing(), Processed(50050))
+
+	_, body := get(t, s, "1")
+	assert.JSONEq(t, `{"order":"1","status":"REGISTERED"}`, body)
+	_, body = get(t, s, "1")
+	assert.JSONEq(t, `{"order":"1","status":"PROCESSING"}`, body)
+	_, body = get(t, s, "1")
+	assert.JSONEq(t, `{"order":"1","status":"PROCESSED","accrual":500.5}`, body)
+	_, body = get(t, s, "1")
+	assert.JSONEq(t, `{"order":"1","status":"PROCESSED","accrual":500.5}`, body, "Last response repeats")
+	assert.Equal(t, 4, s.Calls("1"))
+
+	res, _ := get(t, s, "unknown")
+	assert.Equal(t, http.StatusNoContent, res.StatusCode, "Unscripted order is unknown")
+}
+
+func TestServer_Errors(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.Script("1", TooManyRequests(30*time.Second, 5), ServerError())
+
+	res, body := get(t, s, "1")
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "30", res.Header.Get("Retry-After"))
+	assert.Equal(t, "No more than 5 requests per minute allowed", body)
+
+	res, _ = get(t, s, "1")
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
+
+func TestServer_Latency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetLatency(20 * time.Millisecond)
+	s.Script("1", Invalid().WithLatency(30*time.Millisecond))
+
+	start := time.Now()
+	_, body := get(t, s, "1")
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond, "Server and response latency add up")
+	assert.JSONEq(t, `{"order":"1","status":"INVALID"}`, body)
+}
@@ -7,18 +7,40 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/PaBah/gofermart/internal/config"
 	"github.com/PaBah/gofermart/internal/dto"
 	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/metrics"
 	"github.com/PaBah/gofermart/internal/models"
 	"github.com/PaBah/gofermart/internal/storage"
+	"github.com/PaBah/gofermart/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+const (
+	defaultRetryAfter = time.Minute
+	jobLease          = time.Minute
+	jobsPerWorker     = 4
+	// probeOrder запрашивается проверкой готовности, когда опросов давно не было
+	probeOrder = "0"
+)
+
 type OrdersAccrualClient struct {
 	options *config.Options
 	storage storage.Repository
+	client  *http.Client
+	limiter *rateLimiter
+	// lastAnswer — время последнего ответа системы расчёта без ошибки сервера, в наносекундах Unix
+	lastAnswer *atomic.Int64
 }
 
 var (
@@ -28,43 +50,215 @@ var (
 	ErrAccrualNoData             = errors.New("unknown order number")
 )
 
-func (oac OrdersAccrualClient) ScrapeOrders() {
-	go func() {
-		for {
-			ordersIDs, _ := oac.storage.GetAllOrdersIDs(context.Background())
-			for _, orderID := range ordersIDs {
-				order, err := oac.GetOrder(orderID)
-				if err == nil {
-					orderInstance := models.Order{
-						Accrual: order.Accrual,
-						Number:  order.Order,
-					}
-					if order.Status == "REGISTERED" {
-						orderInstance.Status = "NEW"
-					} else {
-						orderInstance.Status = order.Status
-					}
-					_, err = oac.storage.UpdateOrder(context.Background(), orderInstance)
-					if err != nil {
-						logger.Log().Error("can not update order number="+order.Order, zap.Error(err))
-					}
+// TooManyRequestsError несёт паузу из заголовка Retry-After и лимит из тела ответа 429.
+type TooManyRequestsError struct {
+	RetryAfter        time.Duration
+	RequestsPerMinute int
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrAccrualTooManyRequests, e.RetryAfter)
+}
+
+func (e *TooManyRequestsError) Unwrap() error {
+	return ErrAccrualTooManyRequests
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// ScrapeOrders опрашивает систему начислений пулом воркеров, пока не отменён ctx.
+// После отмены новые заказы не забираются, а начатые опрашиваются до конца; метод возвращается,
+// когда все воркеры остановились.
+func (oac OrdersAccrualClient) ScrapeOrders(ctx context.Context) {
+	workers := oac.options.Accrual.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan models.AccrualJob)
+	var wg, batch sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				oac.processJob(ctx, job)
+				batch.Done()
+			}
+		}()
+	}
+	defer func() {
+		close(jobs)
+		wg.Wait()
+	}()
+
+	for {
+		claimedJobs, err := oac.storage.ClaimAccrualJobs(ctx, workers*jobsPerWorker, jobLease)
+		if err != nil && ctx.Err() == nil {
+			logger.Log().Error("can not claim orders for accrual", zap.Error(err))
+		}
+
+		if len(claimedJobs) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(oac.options.Accrual.IdleInterval):
+			}
+			continue
+		}
+
+		for i, job := range claimedJobs {
+			batch.Add(1)
+			select {
+			case <-ctx.Done():
+				batch.Done()
+				// Не розданные воркерам заказы сразу возвращаются в очередь, не дожидаясь конца аренды
+				for _, job := range claimedJobs[i:] {
+					oac.reschedule(job, 0)
 				}
+				return
+			case jobs <- job:
 			}
 		}
-	}()
+		batch.Wait()
+	}
+}
+
+// retryDelay растёт экспоненциально с числом попыток опроса заказа.
+func (oac OrdersAccrualClient) retryDelay(attempts int) time.Duration {
+	delay := oac.options.Accrual.IdleInterval
+	for i := 1; i < attempts && delay < oac.options.Accrual.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > oac.options.Accrual.MaxBackoff {
+		delay = oac.options.Accrual.MaxBackoff
+	}
+	return delay
+}
+
+func (oac OrdersAccrualClient) reschedule(job models.AccrualJob, delay time.Duration) {
+	// Заказ должен вернуться в очередь даже при остановке сервиса
+	err := oac.storage.RescheduleAccrualJob(context.Background(), job.OrderNumber, delay)
+	if err != nil {
+		logger.Log().Error("can not reschedule order number="+job.OrderNumber, zap.Error(err))
+	}
+}
+
+// rateLimited применяет ответ 429 ко всем воркерам: снижает частоту запросов и останавливает опрос на Retry-After.
+func (oac OrdersAccrualClient) rateLimited(tooManyRequests *TooManyRequestsError) {
+	metrics.ObserveAccrualRateLimited()
+	if tooManyRequests.RequestsPerMinute > 0 {
+		oac.limiter.SetRate(float64(tooManyRequests.RequestsPerMinute) / 60)
+	}
+	oac.limiter.Pause(tooManyRequests.RetryAfter)
+	logger.Log().Info("accrual system rate limit reached", zap.Duration("retry_after", tooManyRequests.RetryAfter))
+}
+
+// processJob опрашивает один заказ. Отмена stop прерывает только ожидание лимита запросов:
+// начатый опрос доводится до записи результата, чтобы остановка не оборвала обновление заказа.
+func (oac OrdersAccrualClient) processJob(stop context.Context, job models.AccrualJob) {
+	// Опрос продолжает трассу запроса, загрузившего заказ
+	ctx, span := tracing.Tracer().Start(tracing.ContextWithTraceParent(context.WithoutCancel(stop), job.TraceParent), "accrual poll",
+		trace.WithAttributes(attribute.String("order.number", job.OrderNumber), attribute.Int("accrual.attempt", job.Attempts)))
+	defer span.End()
+
+	if err := oac.limiter.Wait(stop); err != nil {
+		oac.reschedule(job, 0)
+		return
+	}
+
+	order, err := oac.GetOrder(ctx, job.OrderNumber)
+	var tooManyRequests *TooManyRequestsError
+	switch {
+	case errors.As(err, &tooManyRequests):
+		oac.rateLimited(tooManyRequests)
+		oac.reschedule(job, tooManyRequests.RetryAfter)
+		return
+	case errors.Is(err, ErrAccrualServiceServerError):
+		// Заказ ждёт ту же паузу, на которую остановлен опрос: она растёт с каждой ошибкой сервера подряд
+		delay := oac.limiter.Backoff()
+		logger.Log().Warn("accrual system server error", zap.String("order", job.OrderNumber), zap.Duration("backoff", delay))
+		oac.reschedule(job, delay)
+		return
+	case errors.Is(err, ErrAccrualNoData):
+		// Заказ могут зарегистрировать в системе расчёта позже, поэтому опрос продолжается с паузой до MaxBackoff
+		oac.limiter.Success()
+		if err = oac.storage.MarkAccrualChecked(ctx, job.OrderNumber); err != nil {
+			logger.Log().Error("can not mark accrual check number="+job.OrderNumber, zap.Error(err))
+		}
+		oac.reschedule(job, oac.retryDelay(job.Attempts))
+		return
+	case err != nil:
+		if ctx.Err() == nil {
+			logger.Log().Error("can not get order accrual number="+job.OrderNumber, zap.Error(err))
+		}
+		oac.reschedule(job, oac.retryDelay(job.Attempts))
+		return
+	}
+	oac.limiter.Success()
+
+	orderInstance := models.Order{
+		Accrual: models.Money(order.Accrual),
+		Number:  order.Order,
+	}
+	if order.Status == "REGISTERED" {
+		orderInstance.Status = "NEW"
+	} else {
+		orderInstance.Status = order.Status
+	}
+	_, err = oac.storage.UpdateOrder(ctx, orderInstance)
+	if err != nil {
+		logger.Log().Error("can not update order number="+order.Order, zap.Error(err))
+	} else if orderInstance.Status == "PROCESSED" || orderInstance.Status == "INVALID" {
+		metrics.ObserveOrderProcessed(orderInstance.Status, job.UploadedAt)
+	}
+
+	// Окончательные статусы удаляют задачу внутри UpdateOrder
+	if err != nil || (orderInstance.Status != "PROCESSED" && orderInstance.Status != "INVALID") {
+		oac.reschedule(job, oac.options.Accrual.IdleInterval)
+	}
 }
 
-func (oac OrdersAccrualClient) GetOrder(number string) (order dto.AccrualOrderResponse, err error) {
-	requestURL := fmt.Sprintf("%s/api/orders/%s", oac.options.AccrualSystemAddress, number)
-	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+func (oac OrdersAccrualClient) GetOrder(ctx context.Context, number string) (order dto.AccrualOrderResponse, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "GET /api/orders/{number}", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(http.MethodGet), attribute.String("order.number", number)))
+	defer func() {
+		if err != nil && !errors.Is(err, ErrAccrualNoData) {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	requestURL := fmt.Sprintf("%s/api/orders/%s", oac.options.Accrual.Address, number)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
 	if err != nil {
 		return order, ErrAccrualRequestCrashed
 	}
+	tracing.Inject(ctx, req.Header)
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := oac.client.Do(req)
 	if err != nil {
+		metrics.ObserveAccrualResponse(0)
+		span.RecordError(err)
 		return order, ErrAccrualRequestCrashed
 	}
+	defer res.Body.Close()
+	metrics.ObserveAccrualResponse(res.StatusCode)
+	if res.StatusCode < http.StatusInternalServerError {
+		oac.lastAnswer.Store(time.Now().UnixNano())
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCodeKey.Int(res.StatusCode))
 
 	switch res.StatusCode {
 	case http.StatusOK:
@@ -79,22 +273,64 @@ func (oac OrdersAccrualClient) GetOrder(number string) (order dto.AccrualOrderRe
 		}
 
 		return order, nil
-	case http.StatusInternalServerError:
-		return order, ErrAccrualServiceServerError
 	case http.StatusTooManyRequests:
+		tooManyRequests := &TooManyRequestsError{RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"))}
 		resBody, err := io.ReadAll(res.Body)
-		if err != nil {
-			return order, ErrAccrualTooManyRequests
+		if err == nil {
+			_, _ = fmt.Sscanf(string(resBody), "No more than %d requests per minute allowed", &tooManyRequests.RequestsPerMinute)
 		}
-		logger.Log().Info(string(resBody))
+		return order, tooManyRequests
 	case http.StatusNoContent:
 		return order, ErrAccrualNoData
+	case http.StatusInternalServerError:
+		return order, ErrAccrualServiceServerError
+	default:
+		if res.StatusCode >= http.StatusInternalServerError {
+			return order, ErrAccrualServiceServerError
+		}
+		return order, ErrAccrualRequestCrashed
 	}
-	defer res.Body.Close()
+}
 
-	return
+// LastAnswer возвращает время последнего ответа системы расчёта; нулевое, если ответов не было.
+func (oac OrdersAccrualClient) LastAnswer() time.Time {
+	if nanos := oac.lastAnswer.Load(); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// CheckAnswered — проверка готовности: система расчёта отвечала не раньше window назад.
+// Если опросов давно не было, система расчёта опрашивается пробным номером заказа. Проба расходует общий
+// лимит запросов и не выполняется, пока опрос на паузе после 429: ответ 429 уже считается ответом системы.
+func (oac OrdersAccrualClient) CheckAnswered(window time.Duration) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		if time.Since(oac.LastAnswer()) > window && oac.limiter.TryAcquire() {
+			_, err := oac.GetOrder(ctx, probeOrder)
+			var tooManyRequests *TooManyRequestsError
+			if errors.As(err, &tooManyRequests) {
+				oac.rateLimited(tooManyRequests)
+			}
+		}
+
+		lastAnswer := oac.LastAnswer()
+		if lastAnswer.IsZero() {
+			return nil, errors.New("accrual system has not answered yet")
+		}
+		details := map[string]interface{}{"last_answer_at": lastAnswer.Format(time.RFC3339)}
+		if time.Since(lastAnswer) > window {
+			return details, fmt.Errorf("accrual system has not answered for %s", time.Since(lastAnswer).Round(time.Second))
+		}
+		return details, nil
+	}
 }
 
 func NewOrdersAccrualClient(options *config.Options, storage storage.Repository) OrdersAccrualClient {
-	return OrdersAccrualClient{options: options, storage: storage}
+	return OrdersAccrualClient{
+		options:    options,
+		storage:    storage,
+		client:     &http.Client{Timeout: options.Accrual.RequestTimeout},
+		limiter:    newRateLimiter(options.Accrual.RateLimit, options.Accrual.Workers, options.Accrual.MaxBackoff),
+		lastAnswer: &atomic.Int64{},
+	}
 }
@@ -0,0 +1,339 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/accrual/accrualtest"
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/mock"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestOrdersAccrualClient_GetOrder(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script("1", accrualtest.Processed(72998))
+	ts.Script("3", accrualtest.TooManyRequests(time.Minute, 10))
+	ts.Script("4", accrualtest.ServerError())
+
+	oac := NewOrdersAccrualClient(&config.Options{Accrual: config.AccrualOptions{Address: ts.URL}}, nil)
+
+	order, err := oac.GetOrder(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoundedMoney(72998), order.Accrual, "Accrual parsed without drift")
+
+	_, err = oac.GetOrder(context.Background(), "2")
+	assert.ErrorIs(t, err, ErrAccrualNoData)
+
+	_, err = oac.GetOrder(context.Background(), "3")
+	assert.ErrorIs(t, err, ErrAccrualTooManyRequests)
+	var tooManyRequests *TooManyRequestsError
+	assert.ErrorAs(t, err, &tooManyRequests)
+	assert.Equal(t, time.Minute, tooManyRequests.RetryAfter, "Retry-After parsed")
+	assert.Equal(t, 10, tooManyRequests.RequestsPerMinute, "Limit parsed from body")
+
+	_, err = oac.GetOrder(context.Background(), "4")
+	assert.ErrorIs(t, err, ErrAccrualServiceServerError)
+}
+
+func TestOrdersAccrualClient_GetOrderRoundsAccrual(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/orders/1":
+			_, _ = w.Write([]byte(`{"order":"1","status":"PROCESSED","accrual":729.975}`))
+		default:
+			_, _ = w.Write([]byte(`{"order":"2","status":"PROCESSED","accrual":1e2}`))
+		}
+	}))
+	defer ts.Close()
+
+	oac := NewOrdersAccrualClient(&config.Options{Accrual: config.AccrualOptions{Address: ts.URL}}, nil)
+
+	order, err := oac.GetOrder(context.Background(), "1")
+	assert.NoError(t, err, "Extra fractional digits do not break decoding")
+	assert.Equal(t, models.RoundedMoney(72998), order.Accrual)
+
+	order, err = oac.GetOrder(context.Background(), "2")
+	assert.NoError(t, err, "Exponent form is accepted")
+	assert.Equal(t, models.RoundedMoney(10000), order.Accrual)
+}
+
+func TestOrdersAccrualClient_GetOrderTimeout(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script("1", accrualtest.Processed(100).WithLatency(time.Second))
+
+	oac := NewOrdersAccrualClient(&config.Options{Accrual: config.AccrualOptions{Address: ts.URL}}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := oac.GetOrder(ctx, "1")
+	assert.ErrorIs(t, err, ErrAccrualRequestCrashed, "Slow accrual system does not block the caller")
+}
+
+func TestOrdersAccrualClient_GetOrderPropagatesTrace(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	oac := NewOrdersAccrualClient(&config.Options{Accrual: config.AccrualOptions{Address: ts.URL}}, nil)
+	_, err := oac.GetOrder(tracing.ContextWithTraceParent(context.Background(), traceParent), "1")
+	assert.ErrorIs(t, err, ErrAccrualNoData)
+	assert.Equal(t, traceParent, ts.Header("1").Get("traceparent"), "W3C trace context sent to accrual system")
+}
+
+func TestOrdersAccrualClient_CheckAnswered(t *testing.T) {
+	ts := accrualtest.NewServer()
+	oac := NewOrdersAccrualClient(&config.Options{Accrual: config.AccrualOptions{Address: ts.URL}}, nil)
+	check := oac.CheckAnswered(time.Minute)
+
+	details, err := check(context.Background())
+	assert.NoError(t, err, "Idle client probes accrual system")
+	assert.Contains(t, details, "last_answer_at")
+	assert.Equal(t, 1, ts.Calls(probeOrder))
+
+	_, err = check(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, ts.Calls(probeOrder), "Recent answer is enough")
+
+	ts.Close()
+	_, err = oac.CheckAnswered(0)(context.Background())
+	assert.Error(t, err, "Stale answer and failed probe")
+}
+
+func TestOrdersAccrualClient_CheckAnsweredRespectsLimiter(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script(probeOrder, accrualtest.TooManyRequests(time.Minute, 30))
+
+	oac := NewOrdersAccrualClient(&config.Options{Accrual: config.AccrualOptions{Address: ts.URL, Workers: 1, MaxBackoff: time.Minute}}, nil)
+	_, err := oac.CheckAnswered(0)(context.Background())
+	assert.Error(t, err, "Answer is older than zero window")
+	assert.Equal(t, 1, ts.Calls(probeOrder))
+	assert.Greater(t, oac.limiter.reserve(time.Now()), 50*time.Second, "429 on probe pauses polling")
+	assert.Equal(t, 0.5, oac.limiter.rate, "429 on probe lowers rate")
+
+	_, err = oac.CheckAnswered(time.Hour)(context.Background())
+	assert.NoError(t, err, "429 counts as an answer")
+	_, _ = oac.CheckAnswered(0)(context.Background())
+	assert.Equal(t, 1, ts.Calls(probeOrder), "No probe while polling is paused")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5"))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)))
+}
+
+// jobQueue эмулирует accrual_jobs поверх мока хранилища.
+type jobQueue struct {
+	mu      sync.Mutex
+	pending map[string]models.AccrualJob
+	updated map[string][]models.Order
+}
+
+func newJobQueue(rm *mock.MockRepository, orders ...string) *jobQueue {
+	q := &jobQueue{pending: make(map[string]models.AccrualJob), updated: make(map[string][]models.Order)}
+	for _, order := range orders {
+		q.pending[order] = models.AccrualJob{OrderNumber: order}
+	}
+
+	rm.EXPECT().ClaimAccrualJobs(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			jobs := make([]models.AccrualJob, 0)
+			for number, job := range q.pending {
+				job.Attempts++
+				jobs = append(jobs, job)
+				delete(q.pending, number)
+			}
+			return jobs, nil
+		}).AnyTimes()
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, orderNumber string, delay time.Duration) error {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.pending[orderNumber] = models.AccrualJob{OrderNumber: orderNumber}
+			return nil
+		}).AnyTimes()
+	rm.EXPECT().MarkAccrualChecked(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	rm.EXPECT().UpdateOrder(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, order models.Order) (models.Order, error) {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.updated[order.Number] = append(q.updated[order.Number], order)
+			return order, nil
+		}).AnyTimes()
+	return q
+}
+
+func (q *jobQueue) history(order string) []models.Order {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]models.Order(nil), q.updated[order]...)
+}
+
+func (q *jobQueue) finalStatus(order string) string {
+	history := q.history(order)
+	if len(history) == 0 {
+		return ""
+	}
+	return history[len(history)-1].Status
+}
+
+func TestOrdersAccrualClient_ScrapeOrders(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script("1", accrualtest.Registered(), accrualtest.Processing(), accrualtest.Processed(1000))
+	ts.Script("2", accrualtest.NoContent(), accrualtest.Invalid())
+	ts.Script("3", accrualtest.ServerError(), accrualtest.Processed(50050))
+	ts.Script("4", accrualtest.TooManyRequests(0, 0), accrualtest.Processed(1))
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	q := newJobQueue(rm, "1", "2", "3", "4")
+
+	options := &config.Options{Accrual: config.AccrualOptions{Address: ts.URL, Workers: 2, IdleInterval: 5 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewOrdersAccrualClient(options, rm).ScrapeOrders(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return q.finalStatus("1") == "PROCESSED" && q.finalStatus("2") == "INVALID" &&
+			q.finalStatus("3") == "PROCESSED" && q.finalStatus("4") == "PROCESSED"
+	}, 2*time.Second, 5*time.Millisecond, "All orders reach final status")
+
+	assert.Equal(t, []models.Order{
+		{Number: "1", Status: "NEW"},
+		{Number: "1", Status: "PROCESSING"},
+		{Number: "1", Status: "PROCESSED", Accrual: 1000},
+	}, q.history("1"), "REGISTERED is stored as NEW and each transition is saved")
+	assert.Equal(t, []models.Order{{Number: "3", Status: "PROCESSED", Accrual: 50050}}, q.history("3"), "Server error is retried")
+	assert.Equal(t, 2, ts.Calls("4"), "Rate limited order is retried")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ScrapeOrders did not stop after context cancel")
+	}
+
+	calls := ts.Calls("1")
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, calls, ts.Calls("1"), "Final orders are not polled any more")
+}
+
+func TestOrdersAccrualClient_ScrapeOrdersFinishesCurrentOrder(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script("1", accrualtest.Processed(100).WithLatency(100*time.Millisecond))
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	q := newJobQueue(rm, "1")
+
+	options := &config.Options{Accrual: config.AccrualOptions{Address: ts.URL, Workers: 1, IdleInterval: 5 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewOrdersAccrualClient(options, rm).ScrapeOrders(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return ts.Calls("1") == 1 }, time.Second, time.Millisecond, "Order is being polled")
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ScrapeOrders did not stop after context cancel")
+	}
+	assert.Equal(t, "PROCESSED", q.finalStatus("1"), "Poll started before cancel is saved")
+}
+
+func TestOrdersAccrualClient_ProcessJobReschedules(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script("1", accrualtest.Processing())
+	ts.Script("2", accrualtest.ServerError())
+	ts.Script("3", accrualtest.TooManyRequests(7*time.Second, 0))
+	ts.Script("4", accrualtest.NoContent())
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	rm.EXPECT().UpdateOrder(gomock.Any(), models.Order{Number: "1", Status: "PROCESSING"}).Return(models.Order{}, nil)
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "1", time.Second).Return(nil)
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "2", baseBackoff).Return(nil)
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "3", 7*time.Second).Return(nil)
+	rm.EXPECT().MarkAccrualChecked(gomock.Any(), "4").Return(nil)
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "4", 2*time.Second).Return(nil)
+
+	options := &config.Options{Accrual: config.AccrualOptions{Address: ts.URL, Workers: 1, IdleInterval: time.Second, MaxBackoff: time.Minute}}
+	oac := NewOrdersAccrualClient(options, rm)
+	oac.processJob(context.Background(), models.AccrualJob{OrderNumber: "1", Attempts: 1})
+	oac.processJob(context.Background(), models.AccrualJob{OrderNumber: "2", Attempts: 3})
+	oac.limiter.pausedUntil = time.Time{}
+	oac.processJob(context.Background(), models.AccrualJob{OrderNumber: "3", Attempts: 1})
+
+	assert.Greater(t, oac.limiter.reserve(time.Now()), 6*time.Second, "Retry-After pauses every worker")
+
+	oac.limiter.pausedUntil = time.Time{}
+	oac.processJob(context.Background(), models.AccrualJob{OrderNumber: "4", Attempts: 2})
+}
+
+func TestOrdersAccrualClient_ProcessJobServerErrorBackoff(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	for _, number := range []string{"1", "2", "3", "5"} {
+		ts.Script(number, accrualtest.ServerError())
+	}
+	ts.Script("4", accrualtest.Processing())
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	gomock.InOrder(
+		rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "1", baseBackoff).Return(nil),
+		rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "2", 2*baseBackoff).Return(nil),
+		rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "3", 4*baseBackoff).Return(nil),
+	)
+	rm.EXPECT().UpdateOrder(gomock.Any(), models.Order{Number: "4", Status: "PROCESSING"}).Return(models.Order{}, nil)
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "4", time.Second).Return(nil)
+	// Успешный ответ начинает паузы заново
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "5", baseBackoff).Return(nil)
+
+	options := &config.Options{Accrual: config.AccrualOptions{Address: ts.URL, Workers: 1, IdleInterval: time.Second, MaxBackoff: time.Minute}}
+	oac := NewOrdersAccrualClient(options, rm)
+	// Пауза опроса снимается вручную, чтобы тест не ждал её
+	for _, number := range []string{"1", "2", "3", "4", "5"} {
+		oac.processJob(context.Background(), models.AccrualJob{OrderNumber: number, Attempts: 5})
+		oac.limiter.pausedUntil = time.Time{}
+	}
+}
+
+func TestOrdersAccrualClient_ProcessJobKeepsPollingUnknownOrder(t *testing.T) {
+	ts := accrualtest.NewServer()
+	defer ts.Close()
+	ts.Script("1", accrualtest.NoContent())
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	rm.EXPECT().MarkAccrualChecked(gomock.Any(), "1").Return(nil)
+	rm.EXPECT().RescheduleAccrualJob(gomock.Any(), "1", time.Minute).Return(nil)
+
+	options := &config.Options{Accrual: config.AccrualOptions{Address: ts.URL, Workers: 1, IdleInterval: time.Second, MaxBackoff: time.Minute}}
+	oac := NewOrdersAccrualClient(options, rm)
+	// Без ожидания UpdateOrder статус заказа не меняется, сколько бы раз система расчёта ни ответила 204
+	oac.processJob(context.Background(), models.AccrualJob{OrderNumber: "1", Attempts: 1000})
+}
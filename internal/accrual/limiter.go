@@ -0,0 +1,126 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const baseBackoff = 100 * time.Millisecond
+
+// rateLimiter — общий для всех воркеров token bucket, который также умеет
+// полностью останавливать опрос на время Retry-After или экспоненциальной паузы после 5xx.
+type rateLimiter struct {
+	mu          sync.Mutex
+	rate        float64
+	burst       float64
+	tokens      float64
+	lastRefill  time.Time
+	pausedUntil time.Time
+	failures    int
+	maxBackoff  time.Duration
+}
+
+func newRateLimiter(rate float64, burst int, maxBackoff time.Duration) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:       rate,
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+		maxBackoff: maxBackoff,
+	}
+}
+
+// reserve забирает токен, если он доступен, иначе возвращает время ожидания.
+func (rl *rateLimiter) reserve(now time.Time) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Before(rl.pausedUntil) {
+		return rl.pausedUntil.Sub(now)
+	}
+
+	if rl.rate <= 0 {
+		return 0
+	}
+
+	rl.tokens += now.Sub(rl.lastRefill).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.lastRefill = now
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+	return time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+}
+
+// TryAcquire забирает токен без ожидания; false, если опрос на паузе или токенов нет.
+func (rl *rateLimiter) TryAcquire() bool {
+	return rl.reserve(time.Now()) <= 0
+}
+
+func (rl *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay := rl.reserve(time.Now())
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Pause останавливает всех воркеров минимум на duration.
+func (rl *rateLimiter) Pause(duration time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	until := time.Now().Add(duration)
+	if until.After(rl.pausedUntil) {
+		rl.pausedUntil = until
+	}
+	rl.tokens = 0
+}
+
+// SetRate применяет ограничение, о котором сообщила система начислений.
+func (rl *rateLimiter) SetRate(rate float64) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.rate <= 0 || rate < rl.rate {
+		rl.rate = rate
+	}
+}
+
+// Backoff увеличивает паузу экспоненциально с каждой подряд идущей ошибкой сервера.
+func (rl *rateLimiter) Backoff() time.Duration {
+	rl.mu.Lock()
+	rl.failures++
+	delay := baseBackoff << (rl.failures - 1)
+	if delay > rl.maxBackoff || delay <= 0 {
+		delay = rl.maxBackoff
+	}
+	rl.mu.Unlock()
+
+	rl.Pause(delay)
+	return delay
+}
+
+func (rl *rateLimiter) Success() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.failures = 0
+}
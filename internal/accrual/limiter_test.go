@@ -0,0 +1,61 @@
+package accrual
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	rl := newRateLimiter(10, 2, time.Second)
+	now := time.Now()
+	rl.lastRefill = now
+
+	assert.Equal(t, time.Duration(0), rl.reserve(now), "Burst token available")
+	assert.Equal(t, time.Duration(0), rl.reserve(now), "Second burst token available")
+	assert.Equal(t, 100*time.Millisecond, rl.reserve(now), "Wait for refill when bucket is empty")
+	assert.Equal(t, time.Duration(0), rl.reserve(now.Add(100*time.Millisecond)), "Token refilled")
+}
+
+func TestRateLimiter_Unlimited(t *testing.T) {
+	rl := newRateLimiter(0, 1, time.Second)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, time.Duration(0), rl.reserve(time.Now()))
+	}
+}
+
+func TestRateLimiter_Pause(t *testing.T) {
+	rl := newRateLimiter(0, 1, time.Second)
+	rl.Pause(time.Hour)
+
+	assert.Greater(t, rl.reserve(time.Now()), 59*time.Minute, "Paused limiter holds all workers")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, rl.Wait(ctx), context.DeadlineExceeded, "Wait stops with context")
+}
+
+func TestRateLimiter_Backoff(t *testing.T) {
+	rl := newRateLimiter(0, 1, 300*time.Millisecond)
+
+	assert.Equal(t, baseBackoff, rl.Backoff())
+	assert.Equal(t, 2*baseBackoff, rl.Backoff())
+	assert.Equal(t, 300*time.Millisecond, rl.Backoff(), "Backoff is capped")
+	for i := 0; i < 100; i++ {
+		rl.Backoff()
+	}
+	assert.Equal(t, 300*time.Millisecond, rl.Backoff(), "Backoff does not overflow")
+
+	rl.Success()
+	assert.Equal(t, baseBackoff, rl.Backoff(), "Success resets backoff")
+}
+
+func TestRateLimiter_SetRate(t *testing.T) {
+	rl := newRateLimiter(0, 1, time.Second)
+	rl.SetRate(2)
+	assert.Equal(t, float64(2), rl.rate, "Unlimited limiter takes reported rate")
+	rl.SetRate(5)
+	assert.Equal(t, float64(2), rl.rate, "Limiter keeps the stricter rate")
+}
@@ -2,33 +2,95 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
+	"strings"
+
+	"github.com/PaBah/gofermart/internal/problem"
 )
 
 type key int
 
 const (
 	ContextUserKey key = iota
+	ContextSessionKey
 )
 
-func AuthorizedMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authCookie, err := r.Cookie("Authorization")
+// SessionStore проверяет, что сессия из jti не отозвана.
+type SessionStore interface {
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+// BearerToken возвращает токен из заголовка Authorization: Bearer или пустую строку.
+func BearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, code string, message string) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	problem.Error(w, r, http.StatusUnauthorized, code, message)
+}
+
+// AuthorizedMiddleware принимает токен из заголовка Authorization: Bearer, а при его отсутствии — из cookie.
+func AuthorizedMiddleware(sessions SessionStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := BearerToken(r)
+			if token == "" {
+				if authCookie, err := r.Cookie("Authorization"); err == nil {
+					token = authCookie.Value
+				}
+			}
+
+			if token == "" {
+				unauthorized(w, r, problem.CodeUnauthorized, "Unauthorized requests forbidden")
+				return
+			}
 
-		if err != nil || authCookie == nil || authCookie.Value == "" {
-			http.Error(w, "Unauthorized requests forbidden", http.StatusUnauthorized)
-			return
-		}
+			claims, err := ParseToken(token)
+			if err != nil || claims.UserID == "" || claims.ID == "" {
+				unauthorized(w, r, problem.CodeUnauthorized, "Unauthorized requests forbidden")
+				return
+			}
 
-		userID := GetUserID(authCookie.Value)
+			active, err := sessions.IsSessionActive(r.Context(), claims.ID)
+			if err != nil {
+				problem.Internal(w, r, err)
+				return
+			}
+			if !active {
+				unauthorized(w, r, problem.CodeSessionRevoked, "Session revoked")
+				return
+			}
 
-		if userID == "" {
-			http.Error(w, "Unauthorized requests forbidden", http.StatusUnauthorized)
-			return
-		}
+			ctx := context.WithValue(r.Context(), ContextUserKey, claims.UserID)
+			ctx = context.WithValue(ctx, ContextSessionKey, claims.ID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
 
-		ctx := context.WithValue(r.Context(), ContextUserKey, userID)
+const AdminTokenHeader = "X-Admin-Token"
 
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// AdminMiddleware пропускает запросы с токеном администратора в заголовке X-Admin-Token.
+// Без настроенного токена административный API недоступен.
+func AdminMiddleware(adminToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminToken == "" {
+				problem.Error(w, r, http.StatusNotFound, problem.CodeNotFound, "Not found")
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(AdminTokenHeader)), []byte(adminToken)) != 1 {
+				problem.Error(w, r, http.StatusForbidden, problem.CodeForbidden, "Admin token required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
 }
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/PaBah/gofermart/internal/problem"
+)
+
+const (
+	CSRFCookie = "CSRF-Token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// NewCSRFToken возвращает случайный токен для схемы double submit cookie.
+func NewCSRFToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// ValidCSRF проверяет, что заголовок X-CSRF-Token совпадает с cookie CSRF-Token.
+// Чужой сайт может заставить браузер отправить cookie, но не может их прочитать.
+func ValidCSRF(r *http.Request) bool {
+	csrfCookie, err := r.Cookie(CSRFCookie)
+	if err != nil || csrfCookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(csrfCookie.Value)) == 1
+}
+
+// CSRFMiddleware требует CSRF-токен у изменяющих запросов, авторизованных cookie.
+// Запросы с заголовком Authorization: Bearer браузер сам не отправляет, поэтому они не проверяются.
+func CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) || BearerToken(r) != "" || ValidCSRF(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		problem.Error(w, r, http.StatusForbidden, problem.CodeCSRFFailed, "CSRF token missing or invalid")
+	})
+}
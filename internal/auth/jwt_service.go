@@ -14,20 +14,21 @@ type Claims struct {
 	UserID string
 }
 
-const (
-	TokenExp  = time.Hour * 3
-	SecretKey = "supersecretkey"
-)
+const TokenExp = time.Minute * 15
 
-func BuildJWTString(userID string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+// BuildJWTString выпускает access-токен сессии; идентификатор сессии хранится в jti.
+func BuildJWTString(userID string, sessionID string) (string, error) {
+	keys := Keys()
+	if keys == nil {
+		return "", ErrKeyNotConfigured
+	}
+	tokenString, err := keys.sign(Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenExp)),
 		},
 		UserID: userID,
 	})
-
-	tokenString, err := token.SignedString([]byte(SecretKey))
 	if err != nil {
 		return "", err
 	}
@@ -35,21 +36,28 @@ func BuildJWTString(userID string) (string, error) {
 	return tokenString, nil
 }
 
-func GetUserID(tokenString string) string {
+func ParseToken(tokenString string) (*Claims, error) {
+	keys := Keys()
+	if keys == nil {
+		return nil, ErrKeyNotConfigured
+	}
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(SecretKey), nil
-		})
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc)
 	if err != nil {
-		return ""
+		return nil, err
 	}
 
 	if !token.Valid {
 		logger.Log().Error("Token is not valid", zap.String("token", token.Raw))
+		return nil, fmt.Errorf("token is not valid")
+	}
+
+	return claims, nil
+}
+
+func GetUserID(tokenString string) string {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
 		return ""
 	}
 
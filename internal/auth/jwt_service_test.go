@@ -1,12 +1,43 @@
 package auth
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	keyring, err := NewEphemeralKeyring()
+	if err != nil {
+		panic(err)
+	}
+	SetKeyring(keyring)
+	os.Exit(m.Run())
+}
+
 func TestJWTService(t *testing.T) {
 	userID := GetUserID("1")
 	assert.Equal(t, "", userID)
 }
+
+func TestBuildJWTString(t *testing.T) {
+	token, err := BuildJWTString("user", "session")
+	assert.NoError(t, err)
+
+	claims, err := ParseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", claims.UserID)
+	assert.Equal(t, "session", claims.ID, "Session ID stored in jti")
+	assert.Equal(t, "user", GetUserID(token))
+}
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	assert.NoError(t, err)
+	assert.Equal(t, HashRefreshToken(token), hash)
+	assert.NotEqual(t, token, hash, "Raw token is not stored")
+
+	otherToken, _, _ := NewRefreshToken()
+	assert.NotEqual(t, token, otherToken)
+}
@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/problem"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidKey           = errors.New("invalid signing key")
+	ErrUnknownKeyID         = errors.New("unknown key id")
+	ErrKeyNotConfigured     = errors.New("signing key is not configured")
+)
+
+// SigningKey — ключ из связки. Ключ без закрытой части годится только для проверки подписи.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+	canSign   bool
+}
+
+// NewSigningKey разбирает ключ алгоритма algorithm: секрет для HS256, PEM для RS256 и EdDSA.
+// Для асимметричных алгоритмов допускается открытый ключ — такой ключ только проверяет подпись.
+// Пустой kid вычисляется по содержимому ключа.
+func NewSigningKey(kid string, algorithm string, material []byte) (*SigningKey, error) {
+	key := &SigningKey{ID: kid}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		if len(material) == 0 {
+			return nil, ErrInvalidKey
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.signKey, key.verifyKey, key.canSign = material, material, true
+	case AlgorithmRS256:
+		key.Method = jwt.SigningMethodRS256
+		if privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(material); err == nil {
+			key.signKey, key.verifyKey, key.canSign = privateKey, &privateKey.PublicKey, true
+		} else if publicKey, err := jwt.ParseRSAPublicKeyFromPEM(material); err == nil {
+			key.verifyKey = publicKey
+		} else {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidKey, err)
+		}
+	case AlgorithmEdDSA:
+		key.Method = jwt.SigningMethodEdDSA
+		if privateKey, err := jwt.ParseEdPrivateKeyFromPEM(material); err == nil {
+			key.signKey, key.verifyKey, key.canSign = privateKey, privateKey.(ed25519.PrivateKey).Public(), true
+		} else if publicKey, err := jwt.ParseEdPublicKeyFromPEM(material); err == nil {
+			key.verifyKey = publicKey
+		} else {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidKey, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	if key.ID == "" {
+		key.ID = deriveKeyID(algorithm, material)
+	}
+	return key, nil
+}
+
+func deriveKeyID(algorithm string, material []byte) string {
+	hash := sha256.Sum256(append([]byte(algorithm+":"), material...))
+	return hex.EncodeToString(hash[:8])
+}
+
+// Keyring подписывает токены текущим ключом и проверяет их любым ключом связки,
+// поэтому после ротации токены, выпущенные предыдущим ключом, остаются действительными.
+type Keyring struct {
+	current *SigningKey
+	keys    map[string]*SigningKey
+}
+
+func NewKeyring(current *SigningKey, previous ...*SigningKey) (*Keyring, error) {
+	if current == nil || !current.canSign {
+		return nil, fmt.Errorf("%w: current key must contain private part", ErrInvalidKey)
+	}
+
+	keyring := &Keyring{current: current, keys: map[string]*SigningKey{current.ID: current}}
+	for _, key := range previous {
+		if _, ok := keyring.keys[key.ID]; ok {
+			return nil, fmt.Errorf("%w: duplicate key id %s", ErrInvalidKey, key.ID)
+		}
+		keyring.keys[key.ID] = key
+	}
+	return keyring, nil
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.current.Method, claims)
+	token.Header["kid"] = k.current.ID
+	return token.SignedString(k.current.signKey)
+}
+
+func (k *Keyring) keyFunc(t *jwt.Token) (interface{}, error) {
+	key := k.current
+	if kid, ok := t.Header["kid"].(string); ok {
+		if key, ok = k.keys[kid]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, kid)
+		}
+	}
+
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return key.verifyKey, nil
+}
+
+// JSONWebKey — открытый ключ в формате RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS возвращает открытые ключи связки. Секреты HS256 не публикуются.
+func (k *Keyring) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0)}
+	for _, key := range k.orderedKeys() {
+		jwk := JSONWebKey{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// orderedKeys возвращает текущий ключ первым, остальные — в порядке kid.
+func (k *Keyring) orderedKeys() []*SigningKey {
+	keys := []*SigningKey{k.current}
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		if id != k.current.ID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		keys = append(keys, k.keys[id])
+	}
+	return keys
+}
+
+// activeKeyring задаётся при запуске через SetKeyring; до этого токены не выпускаются и не проверяются.
+var (
+	keyringMu     sync.RWMutex
+	activeKeyring *Keyring
+)
+
+// NewEphemeralKeyring создаёт случайный ключ HS256, живущий до перезапуска процесса. Годится только для разработки и тестов.
+func NewEphemeralKeyring() (*Keyring, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	key, err := NewSigningKey("", AlgorithmHS256, secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(key)
+}
+
+// SetKeyring заменяет связку ключей, которой подписываются и проверяются токены.
+func SetKeyring(keyring *Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	activeKeyring = keyring
+}
+
+func Keys() *Keyring {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return activeKeyring
+}
+
+// NewKeyringFromOptions собирает связку из настроек. Ключ берётся из JWTKey или из файла JWTKeyFile,
+// предыдущие ключи перечисляются в JWTPreviousKeys как "kid:ALG:path" через запятую.
+// Без ключа случайный ключ используется только в режиме разработки (Dev): токены не переживут перезапуск.
+func NewKeyringFromOptions(options *config.Options) (*Keyring, error) {
+	material := []byte(options.Auth.JWTKey)
+	if options.Auth.JWTKeyFile != "" {
+		var err error
+		material, err = os.ReadFile(options.Auth.JWTKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(material) == 0 {
+		if !options.Dev {
+			return nil, ErrKeyNotConfigured
+		}
+		logger.Log().Warn("JWT signing key is not configured, using ephemeral key in development mode")
+		return NewEphemeralKeyring()
+	}
+
+	algorithm := options.Auth.JWTAlgorithm
+	if algorithm == "" {
+		algorithm = AlgorithmHS256
+	}
+	if algorithm == AlgorithmHS256 {
+		material = []byte(strings.TrimSpace(string(material)))
+	}
+	current, err := NewSigningKey(options.Auth.JWTKeyID, algorithm, material)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []*SigningKey
+	for _, entry := range strings.Split(options.Auth.JWTPreviousKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%w: previous key must be kid:ALG:path, got %q", ErrInvalidKey, entry)
+		}
+		keyMaterial, err := os.ReadFile(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		if parts[1] == AlgorithmHS256 {
+			keyMaterial = []byte(strings.TrimSpace(string(keyMaterial)))
+		}
+		key, err := NewSigningKey(parts[0], parts[1], keyMaterial)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	logger.Log().Info("JWT keyring loaded", zap.String("kid", current.ID), zap.String("alg", algorithm), zap.Int("previous", len(previous)))
+	return NewKeyring(current, previous...)
+}
+
+// JWKSHandler отдаёт /.well-known/jwks.json, по которому другие сервисы проверяют токены gophermart.
+func JWKSHandler(res http.ResponseWriter, req *http.Request) {
+	keys := Keys()
+	if keys == nil {
+		problem.Internal(res, req, ErrKeyNotConfigured)
+		return
+	}
+	resBody, err := json.Marshal(keys.JWKS())
+	if err != nil {
+		problem.Internal(res, req, err)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "public, max-age=300")
+	_, err = res.Write(resBody)
+	if err != nil {
+		logger.Log().Error("Can not write JWKS", zap.Error(err))
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodePEM(t *testing.T, blockType string, key interface{}) []byte {
+	var der []byte
+	var err error
+	if blockType == "PRIVATE KEY" {
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	} else {
+		der, err = x509.MarshalPKIXPublicKey(key)
+	}
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func useKeyring(t *testing.T, keyring *Keyring) {
+	previous := Keys()
+	SetKeyring(keyring)
+	t.Cleanup(func() { SetKeyring(previous) })
+}
+
+func TestKeyring_Algorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		algorithm string
+		material  []byte
+	}{
+		{name: "HS256", algorithm: AlgorithmHS256, material: []byte("secret")},
+		{name: "RS256", algorithm: AlgorithmRS256, material: encodePEM(t, "PRIVATE KEY", rsaKey)},
+		{name: "EdDSA", algorithm: AlgorithmEdDSA, material: encodePEM(t, "PRIVATE KEY", edKey)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			key, err := NewSigningKey("", tc.algorithm, tc.material)
+			require.NoError(t, err)
+			keyring, err := NewKeyring(key)
+			require.NoError(t, err)
+			useKeyring(t, keyring)
+
+			token, err := BuildJWTString("user", "session")
+			require.NoError(t, err)
+			assert.Equal(t, "user", GetUserID(token), "Token verified by the same keyring")
+		})
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldKey, _ := NewSigningKey("old", AlgorithmHS256, []byte("old secret"))
+	newKey, _ := NewSigningKey("new", AlgorithmHS256, []byte("new secret"))
+
+	oldKeyring, _ := NewKeyring(oldKey)
+	useKeyring(t, oldKeyring)
+	oldToken, err := BuildJWTString("user", "session")
+	require.NoError(t, err)
+
+	rotatedKeyring, err := NewKeyring(newKey, oldKey)
+	require.NoError(t, err)
+	SetKeyring(rotatedKeyring)
+	assert.Equal(t, "user", GetUserID(oldToken), "Token of previous key accepted")
+
+	newKeyring, _ := NewKeyring(newKey)
+	SetKeyring(newKeyring)
+	assert.Equal(t, "", GetUserID(oldToken), "Token of dropped key rejected")
+}
+
+func TestKeyring_RejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicPEM := encodePEM(t, "PUBLIC KEY", &rsaKey.PublicKey)
+
+	key, err := NewSigningKey("rsa", AlgorithmRS256, encodePEM(t, "PRIVATE KEY", rsaKey))
+	require.NoError(t, err)
+	keyring, _ := NewKeyring(key)
+	useKeyring(t, keyring)
+
+	// Открытый ключ, использованный как секрет HS256, не должен проходить проверку
+	forgedKey, _ := NewSigningKey("rsa", AlgorithmHS256, publicPEM)
+	forgedKeyring, _ := NewKeyring(forgedKey)
+	forgedToken, err := forgedKeyring.sign(Claims{UserID: "attacker"})
+	require.NoError(t, err)
+	assert.Equal(t, "", GetUserID(forgedToken))
+
+	publicKey, err := NewSigningKey("public", AlgorithmRS256, publicPEM)
+	require.NoError(t, err)
+	_, err = NewKeyring(publicKey)
+	assert.ErrorIs(t, err, ErrInvalidKey, "Public key can not sign")
+}
+
+func TestKeyring_JWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	current, _ := NewSigningKey("rsa", AlgorithmRS256, encodePEM(t, "PRIVATE KEY", rsaKey))
+	previous, _ := NewSigningKey("ed", AlgorithmEdDSA, encodePEM(t, "PUBLIC KEY", edPublic))
+	secret, _ := NewSigningKey("hmac", AlgorithmHS256, []byte("secret"))
+	keyring, err := NewKeyring(current, previous, secret)
+	require.NoError(t, err)
+	useKeyring(t, keyring)
+
+	w := httptest.NewRecorder()
+	JWKSHandler(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var set JSONWebKeySet
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 2, "HS256 secret is not published")
+	assert.Equal(t, "rsa", set.Keys[0].KeyID, "Current key goes first")
+	assert.Equal(t, "RSA", set.Keys[0].KeyType)
+	assert.Equal(t, "AQAB", set.Keys[0].E)
+	assert.Equal(t, "ed", set.Keys[1].KeyID)
+	assert.Equal(t, "OKP", set.Keys[1].KeyType)
+	assert.Equal(t, "Ed25519", set.Keys[1].Curve)
+}
+
+func TestNewKeyringFromOptions(t *testing.T) {
+	dir := t.TempDir()
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edPath := filepath.Join(dir, "ed25519.pem")
+	require.NoError(t, os.WriteFile(edPath, encodePEM(t, "PRIVATE KEY", edKey), 0o600))
+	oldPath := filepath.Join(dir, "old.key")
+	require.NoError(t, os.WriteFile(oldPath, []byte("old secret\n"), 0o600))
+
+	keyring, err := NewKeyringFromOptions(&config.Options{Auth: config.AuthOptions{
+		JWTAlgorithm:    AlgorithmEdDSA,
+		JWTKeyID:        "2024-05",
+		JWTKeyFile:      edPath,
+		JWTPreviousKeys: "2024-04:HS256:" + oldPath,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "2024-05", keyring.current.ID)
+	assert.Contains(t, keyring.keys, "2024-04")
+
+	_, err = NewKeyringFromOptions(&config.Options{Auth: config.AuthOptions{JWTKey: "secret", JWTPreviousKeys: "broken"}})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = NewKeyringFromOptions(&config.Options{Auth: config.AuthOptions{JWTKey: "secret", JWTAlgorithm: "none"}})
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+	_, err = NewKeyringFromOptions(&config.Options{})
+	assert.ErrorIs(t, err, ErrKeyNotConfigured, "Key is required outside dev mode")
+
+	keyring, err = NewKeyringFromOptions(&config.Options{Dev: true})
+	require.NoError(t, err, "Ephemeral key used in dev mode")
+	assert.Equal(t, AlgorithmHS256, keyring.current.Method.Alg())
+}
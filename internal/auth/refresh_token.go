@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+const RefreshTokenExp = time.Hour * 24 * 30
+
+// NewRefreshToken возвращает случайный refresh-токен для клиента и его хэш для хранения в базе.
+func NewRefreshToken() (token string, hash string, err error) {
+	raw := make([]byte, 32)
+	_, err = rand.Read(raw)
+	if err != nil {
+		return
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	hash = HashRefreshToken(token)
+	return
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
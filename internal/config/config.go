@@ -1,8 +1,170 @@
 package config
 
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+const (
+	DatabaseDriverSQL     = "sql"
+	DatabaseDriverPgxPool = "pgxpool"
+)
+
+// Options — настройки сервиса. Каждое поле задаётся в файле (ключ yaml), флагом (flag) и переменной окружения (env);
+// приоритет: значение по умолчанию < файл < флаг < переменная окружения. Поля с тегом secret скрываются при выводе.
 type Options struct {
-	RunAddress           string
-	DatabaseURI          string
-	AccrualSystemAddress string
-	LogsLevel            string
+	RunAddress   string `yaml:"run_address" flag:"a" env:"RUN_ADDRESS" usage:"host:port on which server run"`
+	AdminAddress string `yaml:"admin_address" flag:"admin-a" env:"ADMIN_ADDRESS" usage:"host:port of admin listener with /metrics and probes, disabled when empty"`
+	LogsLevel    string `yaml:"log_level" flag:"l" env:"LOG_LEVEL" usage:"logs level"`
+	Dev          bool   `yaml:"dev" flag:"dev" env:"DEV" usage:"development mode: allow plain http and private network webhook endpoints and an ephemeral JWT key"`
+
+	HTTP     HTTPOptions     `yaml:"http"`
+	Database DatabaseOptions `yaml:"database"`
+	Accrual  AccrualOptions  `yaml:"accrual"`
+	Auth     AuthOptions     `yaml:"auth"`
+	Webhook  WebhookOptions  `yaml:"webhook"`
+	Tracing  TracingOptions  `yaml:"tracing"`
+}
+
+type HTTPOptions struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" flag:"http-read-header-timeout" env:"HTTP_READ_HEADER_TIMEOUT" usage:"max time to read request headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" flag:"http-read-timeout" env:"HTTP_READ_TIMEOUT" usage:"max time to read the whole request, 0 means no limit"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" flag:"http-write-timeout" env:"HTTP_WRITE_TIMEOUT" usage:"max time to write a response, event streams are not limited"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" flag:"http-idle-timeout" env:"HTTP_IDLE_TIMEOUT" usage:"max time to keep idle keep-alive connection"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" flag:"shutdown-timeout" env:"SHUTDOWN_TIMEOUT" usage:"max time to drain in-flight requests on shutdown"`
+	ShutdownDelay     time.Duration `yaml:"shutdown_delay" flag:"shutdown-delay" env:"SHUTDOWN_DELAY" usage:"time between reporting not ready and closing listeners on shutdown"`
+	TrustedProxies    string        `yaml:"trusted_proxies" flag:"trusted-proxies" env:"TRUSTED_PROXIES" usage:"comma separated IPs or CIDRs of reverse proxies whose X-Forwarded-For is used as client IP"`
+	EventsRetention   time.Duration `yaml:"events_retention" flag:"events-retention" env:"EVENTS_RETENTION" usage:"how long user events are kept for resuming event streams with Last-Event-ID"`
+}
+
+// TrustedProxyPrefixes разбирает TrustedProxies; одиночный адрес превращается в подсеть из одного адреса.
+func (o HTTPOptions) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range strings.Split(o.TrustedProxies, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(value); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("must be comma separated IPs or CIDRs, got %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+type DatabaseOptions struct {
+	URI             string        `yaml:"uri" flag:"d" env:"DATABASE_URI" usage:"database DSN address, in-memory storage when empty" secret:"dsn"`
+	Driver          string        `yaml:"driver" flag:"db-driver" env:"DB_DRIVER" usage:"database client: sql (database/sql over pgx) or pgxpool (native pgx pool)"`
+	MaxOpenConns    int           `yaml:"max_open_conns" flag:"db-max-open-conns" env:"DB_MAX_OPEN_CONNS" usage:"max open database connections, 0 means unlimited for sql driver"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" flag:"db-max-idle-conns" env:"DB_MAX_IDLE_CONNS" usage:"max idle database connections of sql driver"`
+	MinConns        int           `yaml:"min_conns" flag:"db-min-conns" env:"DB_MIN_CONNS" usage:"min open database connections kept by pgxpool driver"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" flag:"db-conn-max-lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"max time a database connection is reused, 0 means forever"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" flag:"db-conn-max-idle-time" env:"DB_CONN_MAX_IDLE_TIME" usage:"max time a database connection stays idle, 0 means forever"`
+	StatementCache  int           `yaml:"statement_cache_capacity" flag:"db-statement-cache" env:"DB_STATEMENT_CACHE" usage:"prepared statements cached per connection, 0 disables prepared statements cache"`
+	QueryTimeout    time.Duration `yaml:"query_timeout" flag:"db-query-timeout" env:"DB_QUERY_TIMEOUT" usage:"timeout of one database query, 0 means no limit"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout" flag:"db-connect-timeout" env:"DB_CONNECT_TIMEOUT" usage:"how long to retry connecting to database at startup, 0 means single attempt"`
+	NoAutoMigrate   bool          `yaml:"no_auto_migrate" flag:"no-auto-migrate" env:"NO_AUTO_MIGRATE" usage:"do not apply migrations at startup, schema must be migrated with gophermart migrate up"`
+}
+
+type AccrualOptions struct {
+	Address        string        `yaml:"address" flag:"r" env:"ACCRUAL_SYSTEM_ADDRESS" usage:"base URL of accrual system"`
+	Workers        int           `yaml:"workers" flag:"accrual-workers" env:"ACCRUAL_WORKERS" usage:"number of concurrent accrual pollers"`
+	RateLimit      float64       `yaml:"rate_limit" flag:"accrual-rate-limit" env:"ACCRUAL_RATE_LIMIT" usage:"max requests per second to accrual system, 0 means unlimited"`
+	RequestTimeout time.Duration `yaml:"request_timeout" flag:"accrual-request-timeout" env:"ACCRUAL_REQUEST_TIMEOUT" usage:"timeout of one request to accrual system"`
+	IdleInterval   time.Duration `yaml:"idle_interval" flag:"accrual-idle-interval" env:"ACCRUAL_IDLE_INTERVAL" usage:"pause between accrual polls when there is nothing to process"`
+	MaxBackoff     time.Duration `yaml:"max_backoff" flag:"accrual-max-backoff" env:"ACCRUAL_MAX_BACKOFF" usage:"max pause after accrual system server errors"`
+	ReadyWindow    time.Duration `yaml:"ready_window" flag:"accrual-ready-window" env:"ACCRUAL_READY_WINDOW" usage:"service is not ready when accrual system has not answered for this long"`
+}
+
+type AuthOptions struct {
+	JWTAlgorithm       string        `yaml:"jwt_alg" flag:"jwt-alg" env:"JWT_ALG" usage:"JWT signing algorithm: HS256, RS256 or EdDSA"`
+	JWTKeyID           string        `yaml:"jwt_kid" flag:"jwt-kid" env:"JWT_KID" usage:"JWT signing key id, derived from the key when empty"`
+	JWTKey             string        `yaml:"jwt_key" flag:"jwt-key" env:"JWT_KEY" usage:"JWT HS256 secret" secret:"true"`
+	JWTKeyFile         string        `yaml:"jwt_key_file" flag:"jwt-key-file" env:"JWT_KEY_FILE" usage:"file with JWT HS256 secret or PEM private key"`
+	JWTPreviousKeys    string        `yaml:"jwt_previous_keys" flag:"jwt-previous-keys" env:"JWT_PREVIOUS_KEYS" usage:"comma separated kid:ALG:path keys still accepted after rotation"`
+	CookieSecure       bool          `yaml:"cookie_secure" flag:"cookie-secure" env:"COOKIE_SECURE" usage:"set Secure attribute on auth cookies, enable when the service is served over HTTPS"`
+	CSRFProtection     bool          `yaml:"csrf_protection" flag:"csrf-protection" env:"CSRF_PROTECTION" usage:"require X-CSRF-Token header on changing requests authorized by cookie"`
+	StrictCredentials  bool          `yaml:"strict_credentials" flag:"strict-credentials" env:"STRICT_CREDENTIALS" usage:"require login of 3-64 latin letters, digits or . _ @ - and password of 8+ bytes with letters and digits on registration"`
+	LoginMaxFailures   int           `yaml:"login_max_failures" flag:"login-max-failures" env:"LOGIN_MAX_FAILURES" usage:"failed logins before the login is locked out, 0 disables lockout"`
+	LoginIPMaxFailures int           `yaml:"login_ip_max_failures" flag:"login-ip-max-failures" env:"LOGIN_IP_MAX_FAILURES" usage:"failed logins from one IP before it is locked out, 0 disables lockout"`
+	LoginLockout       time.Duration `yaml:"login_lockout" flag:"login-lockout" env:"LOGIN_LOCKOUT" usage:"lockout duration after too many failed logins"`
+	LoginWindow        time.Duration `yaml:"login_window" flag:"login-window" env:"LOGIN_WINDOW" usage:"failed logins are forgotten after this period without new failures"`
+	LoginDelay         time.Duration `yaml:"login_delay" flag:"login-delay" env:"LOGIN_DELAY" usage:"delay after the first failed login, doubled with every next failure"`
+	LoginMaxDelay      time.Duration `yaml:"login_max_delay" flag:"login-max-delay" env:"LOGIN_MAX_DELAY" usage:"max delay between failed logins"`
+	AdminToken         string        `yaml:"admin_token" flag:"admin-token" env:"ADMIN_TOKEN" usage:"token for admin API, admin API is disabled when empty" secret:"true"`
+}
+
+type WebhookOptions struct {
+	Workers     int           `yaml:"workers" flag:"webhook-workers" env:"WEBHOOK_WORKERS" usage:"number of concurrent webhook senders"`
+	MaxAttempts int           `yaml:"max_attempts" flag:"webhook-max-attempts" env:"WEBHOOK_MAX_ATTEMPTS" usage:"delivery attempts before a webhook goes to dead letter"`
+	Timeout     time.Duration `yaml:"timeout" flag:"webhook-timeout" env:"WEBHOOK_TIMEOUT" usage:"timeout of one webhook delivery request"`
+	MaxBackoff  time.Duration `yaml:"max_backoff" flag:"webhook-max-backoff" env:"WEBHOOK_MAX_BACKOFF" usage:"max pause between webhook delivery attempts"`
+}
+
+type TracingOptions struct {
+	Exporter string `yaml:"exporter" flag:"tracing-exporter" env:"TRACING_EXPORTER" usage:"trace exporter: otlp, stdout or none"`
+	Endpoint string `yaml:"endpoint" flag:"tracing-endpoint" env:"TRACING_ENDPOINT" usage:"OTLP/HTTP traces endpoint URL, OTEL_EXPORTER_OTLP_* variables are used when empty"`
+}
+
+// Default возвращает значения, с которыми сервис запускается без файла, флагов и переменных окружения.
+func Default() Options {
+	return Options{
+		RunAddress:   ":8081",
+		AdminAddress: ":9091",
+		LogsLevel:    "info",
+		HTTP: HTTPOptions{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			EventsRetention:   7 * 24 * time.Hour,
+		},
+		Database: DatabaseOptions{
+			Driver:          DatabaseDriverSQL,
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			MinConns:        2,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			StatementCache:  512,
+			QueryTimeout:    10 * time.Second,
+			ConnectTimeout:  30 * time.Second,
+		},
+		Accrual: AccrualOptions{
+			Address:        "http://localhost:8080",
+			Workers:        4,
+			RateLimit:      50,
+			RequestTimeout: 10 * time.Second,
+			IdleInterval:   time.Second,
+			MaxBackoff:     time.Minute,
+			ReadyWindow:    time.Minute,
+		},
+		Auth: AuthOptions{
+			JWTAlgorithm:       "HS256",
+			LoginMaxFailures:   5,
+			LoginIPMaxFailures: 50,
+			LoginLockout:       15 * time.Minute,
+			LoginWindow:        15 * time.Minute,
+			LoginDelay:         time.Second,
+			LoginMaxDelay:      30 * time.Second,
+		},
+		Webhook: WebhookOptions{
+			Workers:     2,
+			MaxAttempts: 10,
+			Timeout:     10 * time.Second,
+			MaxBackoff:  time.Hour,
+		},
+		Tracing: TracingOptions{
+			Exporter: "none",
+		},
+	}
 }
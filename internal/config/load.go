@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configFlag = "c"
+	configEnv  = "CONFIG"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// field — лист дерева Options с тегами, по которым он задаётся.
+type field struct {
+	path   string
+	flag   string
+	env    string
+	usage  string
+	secret string
+	value  reflect.Value
+}
+
+func fields(options *Options) []field {
+	var result []field
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			structField := v.Type().Field(i)
+			path := prefix + structField.Tag.Get("yaml")
+			if structField.Type.Kind() == reflect.Struct {
+				walk(path+".", v.Field(i))
+				continue
+			}
+			result = append(result, field{
+				path:   path,
+				flag:   structField.Tag.Get("flag"),
+				env:    structField.Tag.Get("env"),
+				usage:  structField.Tag.Get("usage"),
+				secret: structField.Tag.Get("secret"),
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(options).Elem())
+	return result
+}
+
+func (f field) set(raw string) error {
+	switch {
+	case f.value.Type() == durationType:
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(duration))
+	case f.value.Kind() == reflect.String:
+		f.value.SetString(raw)
+	case f.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetBool(b)
+	case f.value.Kind() == reflect.Int:
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		f.value.SetInt(int64(i))
+	case f.value.Kind() == reflect.Float64:
+		number, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		f.value.SetFloat(number)
+	default:
+		return fmt.Errorf("unsupported option type %s", f.value.Type())
+	}
+	return nil
+}
+
+func (f field) String() string {
+	if f.value.Type() == durationType {
+		return time.Duration(f.value.Int()).String()
+	}
+	return fmt.Sprint(f.value.Interface())
+}
+
+// flagValue запоминает значение флага: флаги применяются после файла, хотя путь к файлу задаётся флагом.
+type flagValue struct {
+	defaultValue string
+	isBool       bool
+	raw          *string
+}
+
+func (v flagValue) String() string {
+	if v.raw != nil && *v.raw != "" {
+		return *v.raw
+	}
+	return v.defaultValue
+}
+
+func (v flagValue) Set(raw string) error {
+	*v.raw = raw
+	return nil
+}
+
+func (v flagValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
+// Load собирает настройки из значений по умолчанию, файла из -c или CONFIG, флагов args и переменных окружения.
+// Ошибки разбора возвращаются сразу; проверку значений выполняет Validate.
+func Load(name string, args []string, lookupEnv func(string) (string, bool), output io.Writer) (*Options, error) {
+	options := Default()
+	optionFields := fields(&options)
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	configPath := flags.String(configFlag, "", "path to YAML or JSON config file, CONFIG env is used when empty")
+	rawFlags := make(map[string]*string, len(optionFields))
+	for _, f := range optionFields {
+		raw := new(string)
+		rawFlags[f.flag] = raw
+		flags.Var(flagValue{defaultValue: f.String(), isBool: f.value.Kind() == reflect.Bool, raw: raw}, f.flag, f.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", flags.Args())
+	}
+
+	if *configPath == "" {
+		*configPath, _ = lookupEnv(configEnv)
+	}
+	if *configPath != "" {
+		if err := loadFile(&options, *configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	specified := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { specified[f.Name] = true })
+	for _, f := range optionFields {
+		if !specified[f.flag] {
+			continue
+		}
+		if err := f.set(*rawFlags[f.flag]); err != nil {
+			return nil, fmt.Errorf("invalid value of flag -%s: %w", f.flag, err)
+		}
+	}
+
+	for _, f := range optionFields {
+		raw, ok := lookupEnv(f.env)
+		if !ok {
+			continue
+		}
+		if err := f.set(raw); err != nil {
+			return nil, fmt.Errorf("invalid value of %s: %w", f.env, err)
+		}
+	}
+	return &options, nil
+}
+
+// loadFile накладывает файл на options. JSON разбирается тем же декодером: он является подмножеством YAML.
+func loadFile(options *Options, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can not read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err = decoder.Decode(options)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+var dsnPassword = regexp.MustCompile(`(password=)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// Redacted возвращает копию настроек, в которой секреты заменены; в DSN скрывается только пароль.
+func (o Options) Redacted() Options {
+	for _, f := range fields(&o) {
+		value := f.value.String()
+		switch {
+		case value == "":
+		case f.secret == "true":
+			f.value.SetString(redacted)
+		case f.secret == "dsn":
+			f.value.SetString(redactDSN(value))
+		}
+	}
+	return o
+}
+
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+// Print выводит настройки в формате файла конфигурации, секреты скрыты.
+func (o Options) Print(w io.Writer) error {
+	redactedOptions := o.Redacted()
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+	for _, f := range fields(&redactedOptions) {
+		parent := root
+		key := f.path
+		if section, name, nested := strings.Cut(f.path, "."); nested {
+			if sections[section] == nil {
+				sections[section] = &yaml.Node{Kind: yaml.MappingNode}
+				root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, sections[section])
+			}
+			parent, key = sections[section], name
+		}
+
+		value := &yaml.Node{}
+		var err error
+		if f.value.Type() == durationType {
+			err = value.Encode(time.Duration(f.value.Int()).String())
+		} else {
+			err = value.Encode(f.value.Interface())
+		}
+		if err != nil {
+			return err
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Validate проверяет значения после Load; каждая ошибка называет ключ файла, чтобы её было легко найти при любом источнике значения.
+func (o *Options) Validate() error {
+	var errs []error
+	check := func(ok bool, path string, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+		}
+	}
+	positive := func(path string, d time.Duration) {
+		check(d > 0, path, "must be positive, got %s", d)
+	}
+	nonNegative := func(path string, d time.Duration) {
+		check(d >= 0, path, "must not be negative, got %s", d)
+	}
+
+	check(isHostPort(o.RunAddress), "run_address", "must be host:port, got %q", o.RunAddress)
+	check(o.AdminAddress == "" || isHostPort(o.AdminAddress), "admin_address", "must be host:port or empty, got %q", o.AdminAddress)
+	_, err := zapcore.ParseLevel(o.LogsLevel)
+	check(err == nil, "log_level", "unknown level %q", o.LogsLevel)
+
+	positive("http.read_header_timeout", o.HTTP.ReadHeaderTimeout)
+	nonNegative("http.read_timeout", o.HTTP.ReadTimeout)
+	nonNegative("http.write_timeout", o.HTTP.WriteTimeout)
+	nonNegative("http.idle_timeout", o.HTTP.IdleTimeout)
+	positive("http.shutdown_timeout", o.HTTP.ShutdownTimeout)
+	nonNegative("http.shutdown_delay", o.HTTP.ShutdownDelay)
+	positive("http.events_retention", o.HTTP.EventsRetention)
+	_, err = o.HTTP.TrustedProxyPrefixes()
+	check(err == nil, "http.trusted_proxies", "%v", err)
+
+	switch o.Database.Driver {
+	case DatabaseDriverSQL:
+		check(o.Database.MaxOpenConns >= 0, "database.max_open_conns", "must not be negative, got %d", o.Database.MaxOpenConns)
+	case DatabaseDriverPgxPool:
+		check(o.Database.MaxOpenConns >= 1, "database.max_open_conns", "must be at least 1 for pgxpool driver, got %d", o.Database.MaxOpenConns)
+		check(o.Database.MinConns <= o.Database.MaxOpenConns, "database.min_conns", "must not be greater than database.max_open_conns, got %d", o.Database.MinConns)
+	default:
+		check(false, "database.driver", "must be sql or pgxpool, got %q", o.Database.Driver)
+	}
+	check(o.Database.MaxIdleConns >= 0, "database.max_idle_conns", "must not be negative, got %d", o.Database.MaxIdleConns)
+	check(o.Database.MinConns >= 0, "database.min_conns", "must not be negative, got %d", o.Database.MinConns)
+	nonNegative("database.conn_max_lifetime", o.Database.ConnMaxLifetime)
+	nonNegative("database.conn_max_idle_time", o.Database.ConnMaxIdleTime)
+	check(o.Database.StatementCache >= 0, "database.statement_cache_capacity", "must not be negative, got %d", o.Database.StatementCache)
+	nonNegative("database.query_timeout", o.Database.QueryTimeout)
+	nonNegative("database.connect_timeout", o.Database.ConnectTimeout)
+
+	check(isHTTPURL(o.Accrual.Address), "accrual.address", "must be http(s) URL, got %q", o.Accrual.Address)
+	check(o.Accrual.Workers >= 1, "accrual.workers", "must be at least 1, got %d", o.Accrual.Workers)
+	check(o.Accrual.RateLimit >= 0, "accrual.rate_limit", "must not be negative, got %g", o.Accrual.RateLimit)
+	positive("accrual.request_timeout", o.Accrual.RequestTimeout)
+	positive("accrual.idle_interval", o.Accrual.IdleInterval)
+	check(o.Accrual.MaxBackoff >= o.Accrual.IdleInterval, "accrual.max_backoff", "must not be less than accrual.idle_interval, got %s", o.Accrual.MaxBackoff)
+	positive("accrual.ready_window", o.Accrual.ReadyWindow)
+
+	check(o.Dev || o.Auth.JWTKey != "" || o.Auth.JWTKeyFile != "", "auth.jwt_key", "must be set (or auth.jwt_key_file) unless dev mode is enabled")
+	switch o.Auth.JWTAlgorithm {
+	case "HS256", "RS256", "EdDSA":
+	default:
+		check(false, "auth.jwt_alg", "must be HS256, RS256 or EdDSA, got %q", o.Auth.JWTAlgorithm)
+	}
+	check(o.Auth.LoginMaxFailures >= 0, "auth.login_max_failures", "must not be negative, got %d", o.Auth.LoginMaxFailures)
+	check(o.Auth.LoginIPMaxFailures >= 0, "auth.login_ip_max_failures", "must not be negative, got %d", o.Auth.LoginIPMaxFailures)
+	nonNegative("auth.login_lockout", o.Auth.LoginLockout)
+	positive("auth.login_window", o.Auth.LoginWindow)
+	nonNegative("auth.login_delay", o.Auth.LoginDelay)
+	check(o.Auth.LoginMaxDelay >= o.Auth.LoginDelay, "auth.login_max_delay", "must not be less than auth.login_delay, got %s", o.Auth.LoginMaxDelay)
+
+	check(o.Webhook.Workers >= 1, "webhook.workers", "must be at least 1, got %d", o.Webhook.Workers)
+	check(o.Webhook.MaxAttempts >= 1, "webhook.max_attempts", "must be at least 1, got %d", o.Webhook.MaxAttempts)
+	positive("webhook.timeout", o.Webhook.Timeout)
+	positive("webhook.max_backoff", o.Webhook.MaxBackoff)
+
+	switch o.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		check(false, "tracing.exporter", "must be otlp, stdout or none, got %q", o.Tracing.Exporter)
+	}
+	check(o.Tracing.Endpoint == "" || isHTTPURL(o.Tracing.Endpoint), "tracing.endpoint", "must be http(s) URL or empty, got %q", o.Tracing.Endpoint)
+
+	return errors.Join(errs...)
+}
+
+func isHostPort(address string) bool {
+	_, port, err := net.SplitHostPort(address)
+	return err == nil && port != ""
+}
+
+func isHTTPURL(address string) bool {
+	u, err := url.Parse(address)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
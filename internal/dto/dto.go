@@ -3,6 +3,8 @@ package dto
 import (
 	"fmt"
 	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
 )
 
 type JSONTime time.Time
@@ -24,26 +26,65 @@ type (
 		Password string `json:"password"`
 	}
 
+	RefreshTokenRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	TokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		CSRFToken    string `json:"csrf_token"`
+	}
+
+	UnlockLoginRequest struct {
+		Login string `json:"login"`
+		IP    string `json:"ip"`
+	}
+
 	ActualOrderStateResponse struct {
-		Number     string   `json:"number"`
-		Status     string   `json:"status"`
-		Accrual    float64  `json:"accrual,omitempty"`
-		UploadedAt JSONTime `json:"uploaded_at"`
+		Number     string       `json:"number"`
+		Status     string       `json:"status"`
+		Accrual    models.Money `json:"accrual,omitempty"`
+		UploadedAt JSONTime     `json:"uploaded_at"`
+	}
+
+	OrderStatusResponse struct {
+		Number           string       `json:"number"`
+		Status           string       `json:"status"`
+		Accrual          models.Money `json:"accrual,omitempty"`
+		UploadedAt       JSONTime     `json:"uploaded_at"`
+		AccrualCheckedAt *JSONTime    `json:"accrual_checked_at,omitempty"`
 	}
 
 	UserBalanceResponse struct {
-		Current   float64 `json:"current"`
-		Withdrawn float64 `json:"withdrawn"`
+		Current   models.Money `json:"current"`
+		Withdrawn models.Money `json:"withdrawn"`
 	}
 
 	WithdrawalRequest struct {
-		Number string  `json:"order"`
-		Sum    float64 `json:"sum"`
+		Number string       `json:"order"`
+		Sum    models.Money `json:"sum"`
 	}
 
 	WithdrawalsResponse struct {
-		OrderNumber string   `json:"order"`
-		Sum         float64  `json:"sum"`
-		ProcessedAt JSONTime `json:"processed_at"`
+		OrderNumber string       `json:"order"`
+		Sum         models.Money `json:"sum"`
+		ProcessedAt JSONTime     `json:"processed_at"`
+	}
+
+	WebhookEndpointRequest struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+
+	WebhookEndpointResponse struct {
+		ID        string   `json:"id"`
+		URL       string   `json:"url"`
+		Events    []string `json:"events"`
+		Secret    string   `json:"secret,omitempty"`
+		CreatedAt JSONTime `json:"created_at"`
 	}
 )
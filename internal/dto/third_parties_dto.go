@@ -1,9 +1,11 @@
 package dto
 
+import "github.com/PaBah/gofermart/internal/models"
+
 type (
 	AccrualOrderResponse struct {
-		Order   string  `json:"order"`
-		Status  string  `json:"status"`
-		Accrual float64 `json:"accrual"`
+		Order   string              `json:"order"`
+		Status  string              `json:"status"`
+		Accrual models.RoundedMoney `json:"accrual"`
 	}
 )
@@ -0,0 +1,134 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	listenRetryDelay    = time.Second
+	listenMaxRetryDelay = 30 * time.Second
+	cleanupInterval     = time.Hour
+)
+
+// Broker будит подписки пользователя, когда в его журнале появляются новые события.
+// Сами события подписчик читает из хранилища, поэтому сигналы можно склеивать и терять.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscribers: make(map[string]map[chan struct{}]struct{}), done: make(chan struct{})}
+}
+
+// Close сообщает подписчикам об остановке сервиса: потоки событий должны завершиться,
+// иначе они не дадут http.Server.Shutdown дождаться конца запросов.
+func (b *Broker) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+// Done закрывается вызовом Close.
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// Subscribe возвращает канал сигналов о новых событиях userID и функцию отписки.
+func (b *Broker) Subscribe(userID string) (<-chan struct{}, func()) {
+	wake := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	b.subscribers[userID][wake] = struct{}{}
+	b.mu.Unlock()
+
+	return wake, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[userID], wake)
+		if len(b.subscribers[userID]) == 0 {
+			delete(b.subscribers, userID)
+		}
+	}
+}
+
+func (b *Broker) Notify(userID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for wake := range b.subscribers[userID] {
+		signal(wake)
+	}
+}
+
+// NotifyAll будит всех подписчиков: после переподключения LISTEN уведомления могли быть пропущены.
+func (b *Broker) NotifyAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, subscribers := range b.subscribers {
+		for wake := range subscribers {
+			signal(wake)
+		}
+	}
+}
+
+func signal(wake chan struct{}) {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run слушает уведомления хранилища и переподключается с нарастающей паузой, пока не отменён ctx.
+func (b *Broker) Run(ctx context.Context, store storage.Repository) {
+	delay := listenRetryDelay
+	for {
+		started := time.Now()
+		err := store.ListenUserEvents(ctx, b.Notify)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > listenMaxRetryDelay {
+			delay = listenRetryDelay
+		}
+		logger.Log().Warn("user events listener stopped", zap.Error(err), zap.Duration("retry_in", delay))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > listenMaxRetryDelay {
+			delay = listenMaxRetryDelay
+		}
+		b.NotifyAll()
+	}
+}
+
+// RunCleanup периодически удаляет события старше retention, пока не завершится ctx.
+func RunCleanup(ctx context.Context, store storage.Repository, retention time.Duration) {
+	for {
+		deleted, err := store.DeleteExpiredUserEvents(ctx, retention)
+		if err != nil && ctx.Err() == nil {
+			logger.Log().Error("Can not delete expired user events", zap.Error(err))
+		}
+		if deleted > 0 {
+			logger.Log().Info("Expired user events deleted", zap.Int64("count", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cleanupInterval):
+		}
+	}
+}
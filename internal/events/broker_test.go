@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/mock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestBroker_Notify(t *testing.T) {
+	b := NewBroker()
+	first, unsubscribeFirst := b.Subscribe("user")
+	second, unsubscribeSecond := b.Subscribe("user")
+	other, unsubscribeOther := b.Subscribe("other")
+	defer unsubscribeOther()
+
+	b.Notify("user")
+	b.Notify("user")
+	assert.Len(t, first, 1, "Signals are coalesced")
+	assert.Len(t, second, 1, "Every subscription of the user is woken")
+	assert.Len(t, other, 0, "Other users are not woken")
+
+	<-first
+	unsubscribeFirst()
+	unsubscribeSecond()
+	b.Notify("user")
+	assert.Len(t, first, 0, "Unsubscribed channel is not signalled")
+	assert.NotContains(t, b.subscribers, "user")
+
+	b.NotifyAll()
+	assert.Len(t, other, 1)
+}
+
+func TestBroker_RunReconnects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+
+	b := NewBroker()
+	wake, unsubscribe := b.Subscribe("user")
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gomock.InOrder(
+		rm.EXPECT().ListenUserEvents(gomock.Any(), gomock.Any()).Return(errors.New("connection reset")),
+		rm.EXPECT().ListenUserEvents(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, notify func(userID string)) error {
+				notify("user")
+				cancel()
+				return ctx.Err()
+			}),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx, rm)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run did not stop after context cancel")
+	}
+	assert.Len(t, wake, 1, "Subscribers are woken after reconnect")
+}
+
+func TestBroker_Close(t *testing.T) {
+	broker := NewBroker()
+	broker.Close()
+	broker.Close()
+
+	select {
+	case <-broker.Done():
+	default:
+		t.Fatal("Done is not closed")
+	}
+}
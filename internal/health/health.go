@@ -0,0 +1,160 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	StatusDraining = "draining"
+
+	checkTimeout = 3 * time.Second
+)
+
+// CheckFunc проверяет одну зависимость; details попадают в ответ /readyz как есть.
+type CheckFunc func(ctx context.Context) (details map[string]interface{}, err error)
+
+type Component struct {
+	Status  string                 `json:"status"`
+	Error   string                 `json:"error,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components"`
+}
+
+// Checker собирает проверки готовности. После SetDraining сервис сообщает о неготовности,
+// чтобы балансировщик перестал направлять новые запросы до остановки.
+type Checker struct {
+	mu       sync.Mutex
+	checks   map[string]CheckFunc
+	draining atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{checks: make(map[string]CheckFunc)}
+}
+
+func (c *Checker) Add(name string, check CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// Check выполняет проверки параллельно, каждую не дольше checkTimeout.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make(map[string]CheckFunc, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{Status: StatusReady, Components: make(map[string]Component, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+			details, err := check(ctx)
+			component := Component{Status: StatusUp, Details: details}
+			if err != nil {
+				component.Status = StatusDown
+				component.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = component
+			if err != nil {
+				report.Status = StatusNotReady
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	if c.draining.Load() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+// ReadyHandler отвечает 200, если все зависимости доступны, иначе 503; тело содержит статус каждой.
+func (c *Checker) ReadyHandler(res http.ResponseWriter, req *http.Request) {
+	report := c.Check(req.Context())
+	status := http.StatusOK
+	if report.Status != StatusReady {
+		status = http.StatusServiceUnavailable
+		logger.Log().Warn("service is not ready", zap.Any("report", report))
+	}
+	writeReport(res, status, report)
+}
+
+// LiveHandler сообщает только о том, что процесс обслуживает запросы.
+func LiveHandler(res http.ResponseWriter, _ *http.Request) {
+	writeReport(res, http.StatusOK, map[string]string{"status": StatusUp})
+}
+
+func writeReport(res http.ResponseWriter, status int, report interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(report); err != nil {
+		logger.Log().Error("Can not write health report", zap.Error(err))
+	}
+}
+
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+func DatabaseCheck(db Pinger) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, db.Ping(ctx)
+	}
+}
+
+type MigrationVersioner interface {
+	MigrationVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+// MigrationsCheck требует, чтобы схема была ровно той версии, под которую собран сервис.
+func MigrationsCheck(db MigrationVersioner, expected uint) CheckFunc {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		version, dirty, err := db.MigrationVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]interface{}{"version": version, "expected": expected}
+		switch {
+		case dirty:
+			return details, fmt.Errorf("migration %d is dirty", version)
+		case version != expected:
+			return details, fmt.Errorf("schema version %d, expected %d", version, expected)
+		}
+		return details, nil
+	}
+}
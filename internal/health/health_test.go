@@ -0,0 +1,67 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type migrationsStub struct {
+	version uint
+	dirty   bool
+}
+
+func (m migrationsStub) MigrationVersion(context.Context) (uint, bool, error) {
+	return m.version, m.dirty, nil
+}
+
+func ready(checker *Checker) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	checker.ReadyHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return w
+}
+
+func TestChecker_ReadyHandler(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("database", func(context.Context) (map[string]interface{}, error) { return nil, nil })
+	checker.Add("migrations", MigrationsCheck(migrationsStub{version: 13}, 13))
+
+	w := ready(checker)
+	assert.Equal(t, http.StatusOK, w.Code, "Код ответа не совпадает с ожидаемым")
+	assert.JSONEq(t, `{"status":"ready","components":{"database":{"status":"up"},"migrations":{"status":"up","details":{"version":13,"expected":13}}}}`, w.Body.String())
+
+	checker.Add("accrual", func(context.Context) (map[string]interface{}, error) { return nil, errors.New("connection refused") })
+	w = ready(checker)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Код ответа не совпадает с ожидаемым")
+	assert.JSONEq(t, `{"status":"not_ready","components":{"database":{"status":"up"},"migrations":{"status":"up","details":{"version":13,"expected":13}},"accrual":{"status":"down","error":"connection refused"}}}`, w.Body.String())
+}
+
+func TestChecker_Draining(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("database", func(context.Context) (map[string]interface{}, error) { return nil, nil })
+	checker.SetDraining()
+
+	w := ready(checker)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Not ready while draining even with healthy dependencies")
+	assert.JSONEq(t, `{"status":"draining","components":{"database":{"status":"up"}}}`, w.Body.String())
+}
+
+func TestMigrationsCheck(t *testing.T) {
+	_, err := MigrationsCheck(migrationsStub{version: 12}, 13)(context.Background())
+	assert.EqualError(t, err, "schema version 12, expected 13")
+
+	_, err = MigrationsCheck(migrationsStub{version: 13, dirty: true}, 13)(context.Background())
+	assert.EqualError(t, err, "migration 13 is dirty")
+}
+
+func TestLiveHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	LiveHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"up"}`, w.Body.String())
+}
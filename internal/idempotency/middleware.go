@@ -0,0 +1,178 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/problem"
+	"github.com/PaBah/gofermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+	maxKeyLength   = 255
+
+	// ReservationTTL — сколько ключ считается занятым выполняющимся запросом
+	ReservationTTL = 5 * time.Minute
+	// ResponseTTL — сколько хранится ответ для повтора
+	ResponseTTL     = 24 * time.Hour
+	cleanupInterval = time.Hour
+)
+
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recordingResponseWriter) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recordingResponseWriter) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func requestHash(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + " " + req.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, record models.IdempotencyRecord) {
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(record.StatusCode)
+	_, err := w.Write(record.Body)
+	if err != nil {
+		logger.Log().Error("Can not replay idempotent response", zap.Error(err))
+	}
+}
+
+// Middleware — повторяет сохранённый ответ для запроса с уже использованным Idempotency-Key.
+// Должен стоять после auth.AuthorizedMiddleware: ключи хранятся в разрезе пользователя.
+func Middleware(store storage.Repository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderKey)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxKeyLength {
+				problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidParameter, "Idempotency-Key is too long")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				problem.Error(w, r, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, "Request body too large")
+				return
+			}
+			if err != nil {
+				problem.Internal(w, r, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			record := models.IdempotencyRecord{
+				Key:         key,
+				UserID:      r.Context().Value(auth.ContextUserKey).(string),
+				RequestHash: requestHash(r, body),
+			}
+			storedRecord, err := store.ReserveIdempotencyKey(r.Context(), record, ReservationTTL)
+			if errors.Is(err, storage.ErrAlreadyExists) {
+				switch {
+				case storedRecord.RequestHash != record.RequestHash:
+					problem.Error(w, r, http.StatusUnprocessableEntity, problem.CodeIdempotencyMismatch, "Idempotency-Key already used with another request")
+				case storedRecord.StatusCode == 0:
+					problem.Error(w, r, http.StatusConflict, problem.CodeIdempotencyInFlight, "Request with this Idempotency-Key is still in progress")
+				default:
+					replay(w, storedRecord)
+				}
+				return
+			}
+			if err != nil {
+				problem.Internal(w, r, err)
+				return
+			}
+
+			// Запись результата не должна срываться из-за отключившегося клиента
+			ctx := context.WithoutCancel(r.Context())
+			release := true
+			// Ключ освобождается и при панике обработчика, иначе он остался бы занятым до истечения резерва
+			defer func() {
+				if !release {
+					return
+				}
+				err := store.DeleteIdempotencyKey(ctx, record.UserID, record.Key)
+				if err != nil {
+					logger.Log().Error("Can not release idempotency key", zap.String("key", key), zap.Error(err))
+				}
+			}()
+
+			rw := &recordingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+			if rw.status == 0 {
+				rw.status = http.StatusOK
+			}
+
+			// Ответы 5xx не фиксируются, чтобы клиент мог повторить запрос
+			if rw.status >= http.StatusInternalServerError {
+				return
+			}
+
+			// Запрос уже выполнен: если ответ не сохранится, ключ останется занятым до истечения ReservationTTL
+			release = false
+			record.StatusCode = rw.status
+			record.ContentType = rw.Header().Get("Content-Type")
+			record.Body = rw.body.Bytes()
+			err = store.SaveIdempotencyResponse(ctx, record, ResponseTTL)
+			if err != nil {
+				logger.Log().Error("Can not save idempotent response", zap.String("key", key), zap.Error(err))
+			}
+		})
+	}
+}
+
+// RunCleanup периодически удаляет истёкшие ключи, пока не завершится ctx.
+func RunCleanup(ctx context.Context, store storage.Repository) {
+	for {
+		deleted, err := store.DeleteExpiredIdempotencyKeys(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Log().Error("Can not delete expired idempotency keys", zap.Error(err))
+		}
+		if deleted > 0 {
+			logger.Log().Info("Expired idempotency keys deleted", zap.Int64("count", deleted))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cleanupInterval):
+		}
+	}
+}
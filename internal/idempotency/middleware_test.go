@@ -0,0 +1,125 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/mock"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func newRequest(key string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(HeaderKey, key)
+	}
+	return r.WithContext(context.WithValue(r.Context(), auth.ContextUserKey, "test"))
+}
+
+func TestMiddleware(t *testing.T) {
+	body := `{"order":"2377225624","sum":1}`
+	hash := requestHash(newRequest("", body), []byte(body))
+
+	testCases := []struct {
+		name           string
+		key            string
+		body           string
+		reserved       models.IdempotencyRecord
+		reserveErr     error
+		expectSave     bool
+		expectDelete   bool
+		handlerStatus  int
+		expectedCode   int
+		expectedBody   string
+		expectedCalls  int
+		expectedReplay bool
+	}{
+		{name: "no key", body: body, handlerStatus: http.StatusOK, expectedCode: http.StatusOK, expectedBody: "handled", expectedCalls: 1},
+		{name: "first request", key: "k1", body: body, handlerStatus: http.StatusOK, expectSave: true, expectedCode: http.StatusOK, expectedBody: "handled", expectedCalls: 1},
+		{name: "server error releases key", key: "k2", body: body, handlerStatus: http.StatusInternalServerError, expectDelete: true, expectedCode: http.StatusInternalServerError, expectedCalls: 1},
+		{name: "replay", key: "k3", body: body, reserved: models.IdempotencyRecord{RequestHash: hash, StatusCode: http.StatusPaymentRequired, ContentType: "text/plain", Body: []byte("stored")}, reserveErr: storage.ErrAlreadyExists, expectedCode: http.StatusPaymentRequired, expectedBody: "stored", expectedReplay: true},
+		{name: "different body", key: "k4", body: `{"order":"2377225624","sum":2}`, reserved: models.IdempotencyRecord{RequestHash: hash, StatusCode: http.StatusOK}, reserveErr: storage.ErrAlreadyExists, expectedCode: http.StatusUnprocessableEntity},
+		{name: "in progress", key: "k5", body: body, reserved: models.IdempotencyRecord{RequestHash: hash}, reserveErr: storage.ErrAlreadyExists, expectedCode: http.StatusConflict},
+		{name: "too long key", key: strings.Repeat("k", maxKeyLength+1), body: body, expectedCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			rm := mock.NewMockRepository(ctrl)
+			if tc.key != "" && len(tc.key) <= maxKeyLength {
+				rm.EXPECT().ReserveIdempotencyKey(gomock.Any(), gomock.Any(), ReservationTTL).Return(tc.reserved, tc.reserveErr).Times(1)
+			}
+			if tc.expectSave {
+				rm.EXPECT().
+					SaveIdempotencyResponse(gomock.Any(), models.IdempotencyRecord{Key: tc.key, UserID: "test", RequestHash: hash, StatusCode: tc.handlerStatus, ContentType: "text/plain", Body: []byte("handled")}, ResponseTTL).
+					Return(nil).Times(1)
+			}
+			if tc.expectDelete {
+				rm.EXPECT().DeleteIdempotencyKey(gomock.Any(), "test", tc.key).Return(nil).Times(1)
+			}
+
+			calls := 0
+			handler := Middleware(rm)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(tc.handlerStatus)
+				_, _ = w.Write([]byte("handled"))
+			}))
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, newRequest(tc.key, tc.body))
+
+			assert.Equal(t, tc.expectedCode, w.Code, "Код ответа не совпадает с ожидаемым")
+			assert.Equal(t, tc.expectedCalls, calls, "Handler calls count")
+			if tc.expectedBody != "" {
+				assert.Equal(t, tc.expectedBody, w.Body.String(), "Тело ответа не совпадает с ожидаемым")
+			}
+			if tc.expectedReplay {
+				assert.Equal(t, "true", w.Header().Get(HeaderReplayed))
+			}
+		})
+	}
+}
+
+func TestMiddleware_PanicReleasesKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	rm.EXPECT().ReserveIdempotencyKey(gomock.Any(), gomock.Any(), ReservationTTL).Return(models.IdempotencyRecord{}, nil)
+	rm.EXPECT().DeleteIdempotencyKey(gomock.Any(), "test", "k1").Return(nil).Times(1)
+
+	handler := Middleware(rm)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("handler failed")
+	}))
+
+	assert.PanicsWithValue(t, "handler failed", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("k1", "{}"))
+	}, "Panic is passed to the recoverer")
+}
+
+func TestMiddleware_SavesAfterClientGone(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	rm.EXPECT().ReserveIdempotencyKey(gomock.Any(), gomock.Any(), ReservationTTL).Return(models.IdempotencyRecord{}, nil)
+	rm.EXPECT().SaveIdempotencyResponse(gomock.Any(), gomock.Any(), ResponseTTL).
+		DoAndReturn(func(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) error {
+			return ctx.Err()
+		}).Times(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := Middleware(rm)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), newRequest("k1", "{}").WithContext(context.WithValue(ctx, auth.ContextUserKey, "test")))
+	assert.Error(t, ctx.Err())
+}
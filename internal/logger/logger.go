@@ -30,6 +30,17 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// Flush нужен потоковым ответам (SSE) за обёрткой логера.
+func (r *loggingResponseWriter) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 //var Log *zap.Logger = zap.NewNop()
 
 func Log() *zap.Logger {
@@ -0,0 +1,87 @@
+package loginguard
+
+import (
+	"context"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Guard ограничивает подбор пароля. Счётчики хранятся в базе, поэтому ограничения
+// действуют сразу на все реплики сервиса.
+type Guard struct {
+	options *config.Options
+	storage storage.Repository
+}
+
+func New(options *config.Options, storage storage.Repository) Guard {
+	return Guard{options: options, storage: storage}
+}
+
+func (g Guard) policy(maxFailures int) models.LoginPolicy {
+	return models.LoginPolicy{
+		MaxFailures: maxFailures,
+		Lockout:     g.options.Auth.LoginLockout,
+		Window:      g.options.Auth.LoginWindow,
+		Delay:       g.options.Auth.LoginDelay,
+		MaxDelay:    g.options.Auth.LoginMaxDelay,
+	}
+}
+
+// Attempt проверяет попытку входа до проверки пароля и возвращает, сколько нужно подождать, если она не разрешена.
+// Счётчик IP-адреса только проверяется: за одним адресом может быть много пользователей, поэтому неудача
+// учитывается в Failure, когда пароль уже не подошёл. Попытка по логину учитывается сразу, чтобы параллельные
+// запросы не подбирали пароль одного пользователя в обход лимита; верный пароль сбрасывает её в Success.
+func (g Guard) Attempt(ctx context.Context, login string, ip string) (time.Duration, error) {
+	attempt, err := g.storage.CheckLogin(ctx, models.LoginScopeIP, ip, g.policy(g.options.Auth.LoginIPMaxFailures))
+	if err != nil {
+		return 0, err
+	}
+	if !attempt.Allowed {
+		return attempt.RetryAfter, nil
+	}
+
+	attempt, err = g.storage.AttemptLogin(ctx, models.LoginScopeLogin, login, g.policy(g.options.Auth.LoginMaxFailures))
+	if err != nil {
+		return 0, err
+	}
+	if !attempt.Allowed {
+		// Пауза могла истечь между запросами к базе, но отклонённая попытка всё равно получает 429
+		return max(attempt.RetryAfter, time.Second), nil
+	}
+	logLockout(models.LoginScopeLogin, login, attempt)
+	return 0, nil
+}
+
+// Failure учитывает неверный пароль в счётчике IP-адреса.
+func (g Guard) Failure(ctx context.Context, ip string) {
+	policy := g.policy(g.options.Auth.LoginIPMaxFailures)
+	// Пауза уже проверена в Attempt, а неудача должна учитываться, даже если параллельная попытка успела раньше
+	policy.Delay = 0
+	attempt, err := g.storage.AttemptLogin(ctx, models.LoginScopeIP, ip, policy)
+	if err != nil {
+		logger.Log().Error("Can not record login failure", zap.String("scope", models.LoginScopeIP), zap.Error(err))
+		return
+	}
+	logLockout(models.LoginScopeIP, ip, attempt)
+}
+
+// Success сбрасывает счётчик логина после верного пароля. Счётчик IP-адреса не сбрасывается, иначе вход в свою
+// учётную запись между попытками подбора обнулял бы его; неудачи по адресу истекают по окну.
+func (g Guard) Success(ctx context.Context, login string) {
+	err := g.storage.ResetLoginFailures(ctx, models.LoginScopeLogin, login)
+	if err != nil {
+		logger.Log().Error("Can not reset login failures", zap.String("scope", models.LoginScopeLogin), zap.Error(err))
+	}
+}
+
+func logLockout(scope string, key string, attempt models.LoginAttempt) {
+	if attempt.Allowed && !attempt.LockedUntil.IsZero() {
+		logger.Log().Warn("Login locked out after failed attempts",
+			zap.String("scope", scope), zap.String("key", key), zap.Time("locked_until", attempt.LockedUntil))
+	}
+}
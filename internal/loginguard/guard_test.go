@@ -0,0 +1,74 @@
+package loginguard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/mock"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestGuard_Attempt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	options := &config.Options{Auth: config.AuthOptions{
+		LoginMaxFailures: 5, LoginIPMaxFailures: 50, LoginLockout: time.Minute, LoginWindow: time.Hour, LoginDelay: time.Second, LoginMaxDelay: time.Minute,
+	}}
+	guard := New(options, rm)
+	loginPolicy := models.LoginPolicy{MaxFailures: 5, Lockout: time.Minute, Window: time.Hour, Delay: time.Second, MaxDelay: time.Minute}
+	ipPolicy := loginPolicy
+	ipPolicy.MaxFailures = 50
+
+	testCases := []struct {
+		name         string
+		ipCheck      models.LoginAttempt
+		loginAttempt *models.LoginAttempt
+		expectedWait time.Duration
+	}{
+		{name: "allowed", ipCheck: models.LoginAttempt{Allowed: true}, loginAttempt: &models.LoginAttempt{Allowed: true, Failures: 1}},
+		{name: "ip rejected skips login counter", ipCheck: models.LoginAttempt{Failures: 3, RetryAfter: 3 * time.Second}, expectedWait: 3 * time.Second},
+		{name: "login locked", ipCheck: models.LoginAttempt{Allowed: true}, loginAttempt: &models.LoginAttempt{RetryAfter: time.Minute}, expectedWait: time.Minute},
+		{name: "expired pause still waits", ipCheck: models.LoginAttempt{Allowed: true}, loginAttempt: &models.LoginAttempt{}, expectedWait: time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rm.EXPECT().CheckLogin(gomock.Any(), models.LoginScopeIP, "ip", ipPolicy).Return(tc.ipCheck, nil).Times(1)
+			if tc.loginAttempt != nil {
+				rm.EXPECT().AttemptLogin(gomock.Any(), models.LoginScopeLogin, "login", loginPolicy).Return(*tc.loginAttempt, nil).Times(1)
+			}
+
+			wait, err := guard.Attempt(context.Background(), "login", "ip")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedWait, wait)
+		})
+	}
+}
+
+func TestGuard_Failure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	options := &config.Options{Auth: config.AuthOptions{
+		LoginIPMaxFailures: 50, LoginLockout: time.Minute, LoginWindow: time.Hour, LoginDelay: time.Second, LoginMaxDelay: time.Minute,
+	}}
+	guard := New(options, rm)
+
+	policy := models.LoginPolicy{MaxFailures: 50, Lockout: time.Minute, Window: time.Hour, MaxDelay: time.Minute}
+	rm.EXPECT().AttemptLogin(gomock.Any(), models.LoginScopeIP, "ip", policy).Return(models.LoginAttempt{Allowed: true, Failures: 1}, nil).Times(1)
+
+	guard.Failure(context.Background(), "ip")
+}
+
+func TestGuard_Success(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	guard := New(&config.Options{}, rm)
+
+	rm.EXPECT().ResetLoginFailures(gomock.Any(), models.LoginScopeLogin, "login").Return(nil).Times(1)
+
+	guard.Success(context.Background(), "login")
+}
@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+const (
+	namespace = "gophermart"
+	// unmatchedRoute не даёт произвольным адресам размножать ряды метрик
+	unmatchedRoute = "unmatched"
+	queueTimeout   = 5 * time.Second
+)
+
+// Registry хранит все метрики сервиса; отдаётся обработчиком Handler.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by method, chi route pattern and status code.",
+	}, []string{"method", "route", "code"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "HTTP request latency by method and chi route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	accrualRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "requests_total",
+		Help:      "Requests to accrual system by response status code, error when no response was received.",
+	}, []string{"code"})
+	accrualRateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "rate_limited_total",
+		Help:      "Responses 429 Too Many Requests from accrual system.",
+	})
+	accrualTimeToProcessed = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "accrual",
+		Name:      "time_to_processed_seconds",
+		Help:      "Time from order upload to its final status.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 300, 900, 3600, 6 * 3600, 24 * 3600},
+	}, []string{"status"})
+	accrualQueueDepth = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "accrual", "queue_orders"),
+		"Orders waiting for accrual by status.",
+		[]string{"status"}, nil)
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration,
+		accrualRequests, accrualRateLimited, accrualTimeToProcessed,
+	)
+}
+
+// Handler отдаёт метрики в формате Prometheus.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware считает запросы и их длительность по шаблону маршрута chi, а не по адресу запроса.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := unmatchedRoute
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// ObserveAccrualResponse учитывает ответ системы расчёта; statusCode 0 — ответ не получен.
+func ObserveAccrualResponse(statusCode int) {
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	accrualRequests.WithLabelValues(code).Inc()
+}
+
+func ObserveAccrualRateLimited() {
+	accrualRateLimited.Inc()
+}
+
+// ObserveOrderProcessed учитывает время от загрузки заказа до окончательного статуса.
+func ObserveOrderProcessed(status string, uploadedAt time.Time) {
+	if uploadedAt.IsZero() {
+		return
+	}
+	accrualTimeToProcessed.WithLabelValues(status).Observe(time.Since(uploadedAt).Seconds())
+}
+
+// OrderCounter считает заказы в заданных статусах.
+type OrderCounter interface {
+	CountOrdersByStatus(ctx context.Context, statuses ...string) (map[string]int64, error)
+}
+
+type queueCollector struct {
+	store    OrderCounter
+	statuses []string
+}
+
+// NewAccrualQueueCollector публикует число заказов, ожидающих начисления; запрос к базе выполняется при сборе метрик.
+func NewAccrualQueueCollector(store OrderCounter, statuses ...string) prometheus.Collector {
+	return queueCollector{store: store, statuses: statuses}
+}
+
+func (c queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- accrualQueueDepth
+}
+
+func (c queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueTimeout)
+	defer cancel()
+
+	counts, err := c.store.CountOrdersByStatus(ctx, c.statuses...)
+	if err != nil {
+		logger.Log().Error("can not count orders waiting for accrual", zap.Error(err))
+		ch <- prometheus.NewInvalidMetric(accrualQueueDepth, err)
+		return
+	}
+	for _, status := range c.statuses {
+		ch <- prometheus.MustNewConstMetric(accrualQueueDepth, prometheus.GaugeValue, float64(counts[status]), status)
+	}
+}
+
+var (
+	pgxPoolMaxConns      = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "max_conns"), "Maximum size of the pool.", nil, nil)
+	pgxPoolTotalConns    = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "total_conns"), "Connections in the pool, idle, acquired and being constructed.", nil, nil)
+	pgxPoolAcquiredConns = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "acquired_conns"), "Connections currently in use.", nil, nil)
+	pgxPoolIdleConns     = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "idle_conns"), "Idle connections in the pool.", nil, nil)
+	pgxPoolAcquires      = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "acquires_total"), "Successful connection acquires.", nil, nil)
+	pgxPoolEmptyAcquires = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "empty_acquires_total"), "Acquires that waited for a connection because the pool was empty.", nil, nil)
+	pgxPoolAcquireTime   = prometheus.NewDesc(prometheus.BuildFQName(namespace, "pgxpool", "acquire_duration_seconds_total"), "Total time spent acquiring connections.", nil, nil)
+)
+
+type pgxPoolCollector struct {
+	pool *pgxpool.Pool
+}
+
+// NewPgxPoolCollector публикует статистику пула pgxpool — аналог go_sql_* для драйвера database/sql.
+func NewPgxPoolCollector(pool *pgxpool.Pool) prometheus.Collector {
+	return pgxPoolCollector{pool: pool}
+}
+
+func (c pgxPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pgxPoolMaxConns
+	ch <- pgxPoolTotalConns
+	ch <- pgxPoolAcquiredConns
+	ch <- pgxPoolIdleConns
+	ch <- pgxPoolAcquires
+	ch <- pgxPoolEmptyAcquires
+	ch <- pgxPoolAcquireTime
+}
+
+func (c pgxPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(pgxPoolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(pgxPoolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(pgxPoolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(pgxPoolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(pgxPoolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(pgxPoolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(pgxPoolAcquireTime, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PaBah/gofermart/internal/mock"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Get("/api/user/balance", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{}"))
+	})
+
+	for _, target := range []string{"/api/user/orders/1", "/api/user/orders/2", "/api/user/balance", "/unknown/1"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/user/orders/{number}", "404")), "Requests grouped by route pattern")
+	assert.Equal(t, float64(1), testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/user/balance", "200")), "Implicit 200 counted")
+	assert.Equal(t, float64(1), testutil.ToFloat64(httpRequests.WithLabelValues("GET", unmatchedRoute, "404")), "Unknown paths share one series")
+	assert.Equal(t, 3, testutil.CollectAndCount(httpDuration), "Latency observed per route")
+}
+
+func TestObserveAccrualResponse(t *testing.T) {
+	ObserveAccrualResponse(http.StatusTooManyRequests)
+	ObserveAccrualResponse(0)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(accrualRequests.WithLabelValues("429")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(accrualRequests.WithLabelValues("error")), "Failed request counted without status code")
+}
+
+func TestAccrualQueueCollector(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	rm.EXPECT().CountOrdersByStatus(gomock.Any(), "NEW", "PROCESSING").Return(map[string]int64{"PROCESSING": 3}, nil)
+
+	expected := `
+# HELP gophermart_accrual_queue_orders Orders waiting for accrual by status.
+# TYPE gophermart_accrual_queue_orders gauge
+gophermart_accrual_queue_orders{status="NEW"} 0
+gophermart_accrual_queue_orders{status="PROCESSING"} 3
+`
+	err := testutil.CollectAndCompare(NewAccrualQueueCollector(rm, "NEW", "PROCESSING"), strings.NewReader(expected))
+	assert.NoError(t, err)
+
+	rm.EXPECT().CountOrdersByStatus(gomock.Any(), "NEW").Return(nil, errors.New("connection refused"))
+	_, err = testutil.CollectAndLint(NewAccrualQueueCollector(rm, "NEW"))
+	assert.Error(t, err, "Database error fails the scrape instead of reporting zero")
+}
+
+func TestPgxPoolCollector(t *testing.T) {
+	// Пул не подключается к базе, пока соединение не запрошено
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost:1/gophermart?pool_max_conns=3")
+	require.NoError(t, err)
+	defer pool.Close()
+
+	expected := `
+# HELP gophermart_pgxpool_max_conns Maximum size of the pool.
+# TYPE gophermart_pgxpool_max_conns gauge
+gophermart_pgxpool_max_conns 3
+# HELP gophermart_pgxpool_acquired_conns Connections currently in use.
+# TYPE gophermart_pgxpool_acquired_conns gauge
+gophermart_pgxpool_acquired_conns 0
+`
+	err = testutil.CollectAndCompare(NewPgxPoolCollector(pool), strings.NewReader(expected), "gophermart_pgxpool_max_conns", "gophermart_pgxpool_acquired_conns")
+	assert.NoError(t, err)
+}
+
+func TestHandler(t *testing.T) {
+	ObserveAccrualRateLimited()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "gophermart_accrual_rate_limited_total")
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
@@ -7,8 +7,10 @@ package mock
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	models "github.com/PaBah/gofermart/internal/models"
+	pagination "github.com/PaBah/gofermart/internal/pagination"
 	"go.uber.org/mock/gomock"
 )
 
@@ -35,6 +37,21 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// AttemptLogin mocks base method.
+func (m *MockRepository) AttemptLogin(ctx context.Context, scope, key string, policy models.LoginPolicy) (models.LoginAttempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AttemptLogin", ctx, scope, key, policy)
+	ret0, _ := ret[0].(models.LoginAttempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AttemptLogin indicates an expected call of AttemptLogin.
+func (mr *MockRepositoryMockRecorder) AttemptLogin(ctx, scope, key, policy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AttemptLogin", reflect.TypeOf((*MockRepository)(nil).AttemptLogin), ctx, scope, key, policy)
+}
+
 // AuthorizeUser mocks base method.
 func (m *MockRepository) AuthorizeUser(ctx context.Context, login string) (models.User, error) {
 	m.ctrl.T.Helper()
@@ -50,6 +67,100 @@ func (mr *MockRepositoryMockRecorder) AuthorizeUser(ctx, login interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthorizeUser", reflect.TypeOf((*MockRepository)(nil).AuthorizeUser), ctx, login)
 }
 
+// CheckLogin mocks base method.
+func (m *MockRepository) CheckLogin(ctx context.Context, scope, key string, policy models.LoginPolicy) (models.LoginAttempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckLogin", ctx, scope, key, policy)
+	ret0, _ := ret[0].(models.LoginAttempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckLogin indicates an expected call of CheckLogin.
+func (mr *MockRepositoryMockRecorder) CheckLogin(ctx, scope, key, policy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckLogin", reflect.TypeOf((*MockRepository)(nil).CheckLogin), ctx, scope, key, policy)
+}
+
+// ClaimAccrualJobs mocks base method.
+func (m *MockRepository) ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimAccrualJobs", ctx, limit, lease)
+	ret0, _ := ret[0].([]models.AccrualJob)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimAccrualJobs indicates an expected call of ClaimAccrualJobs.
+func (mr *MockRepositoryMockRecorder) ClaimAccrualJobs(ctx, limit, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimAccrualJobs", reflect.TypeOf((*MockRepository)(nil).ClaimAccrualJobs), ctx, limit, lease)
+}
+
+// ClaimWebhookDeliveries mocks base method.
+func (m *MockRepository) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimWebhookDeliveries", ctx, limit, lease)
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimWebhookDeliveries indicates an expected call of ClaimWebhookDeliveries.
+func (mr *MockRepositoryMockRecorder) ClaimWebhookDeliveries(ctx, limit, lease interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimWebhookDeliveries", reflect.TypeOf((*MockRepository)(nil).ClaimWebhookDeliveries), ctx, limit, lease)
+}
+
+// CompleteWebhookDelivery mocks base method.
+func (m *MockRepository) CompleteWebhookDelivery(ctx context.Context, deliveryID string, statusCode int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteWebhookDelivery", ctx, deliveryID, statusCode)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteWebhookDelivery indicates an expected call of CompleteWebhookDelivery.
+func (mr *MockRepositoryMockRecorder) CompleteWebhookDelivery(ctx, deliveryID, statusCode interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteWebhookDelivery", reflect.TypeOf((*MockRepository)(nil).CompleteWebhookDelivery), ctx, deliveryID, statusCode)
+}
+
+// CountOrdersByStatus mocks base method.
+func (m *MockRepository) CountOrdersByStatus(ctx context.Context, statuses ...string) (map[string]int64, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx}
+	for _, a := range statuses {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "CountOrdersByStatus", varargs...)
+	ret0, _ := ret[0].(map[string]int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountOrdersByStatus indicates an expected call of CountOrdersByStatus.
+func (mr *MockRepositoryMockRecorder) CountOrdersByStatus(ctx interface{}, statuses ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx}, statuses...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountOrdersByStatus", reflect.TypeOf((*MockRepository)(nil).CountOrdersByStatus), varargs...)
+}
+
+// CreateSession mocks base method.
+func (m *MockRepository) CreateSession(ctx context.Context, session models.Session) (models.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSession", ctx, session)
+	ret0, _ := ret[0].(models.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSession indicates an expected call of CreateSession.
+func (mr *MockRepositoryMockRecorder) CreateSession(ctx, session interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSession", reflect.TypeOf((*MockRepository)(nil).CreateSession), ctx, session)
+}
+
 // CreateUser mocks base method.
 func (m *MockRepository) CreateUser(ctx context.Context, user models.User) (models.User, error) {
 	m.ctrl.T.Helper()
@@ -65,94 +176,285 @@ func (mr *MockRepositoryMockRecorder) CreateUser(ctx, user interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockRepository)(nil).CreateUser), ctx, user)
 }
 
-// CreateWithdrawal mocks base method.
-func (m *MockRepository) CreateWithdrawal(ctx context.Context, withdrawal models.Withdrawal) (models.Withdrawal, error) {
+// CreateWebhookEndpoint mocks base method.
+func (m *MockRepository) CreateWebhookEndpoint(ctx context.Context, endpoint models.WebhookEndpoint) (models.WebhookEndpoint, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateWithdrawal", ctx, withdrawal)
-	ret0, _ := ret[0].(models.Withdrawal)
+	ret := m.ctrl.Call(m, "CreateWebhookEndpoint", ctx, endpoint)
+	ret0, _ := ret[0].(models.WebhookEndpoint)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// CreateWithdrawal indicates an expected call of CreateWithdrawal.
-func (mr *MockRepositoryMockRecorder) CreateWithdrawal(ctx, withdrawal interface{}) *gomock.Call {
+// CreateWebhookEndpoint indicates an expected call of CreateWebhookEndpoint.
+func (mr *MockRepositoryMockRecorder) CreateWebhookEndpoint(ctx, endpoint interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWebhookEndpoint", reflect.TypeOf((*MockRepository)(nil).CreateWebhookEndpoint), ctx, endpoint)
+}
+
+// DeadLetterWebhookDelivery mocks base method.
+func (m *MockRepository) DeadLetterWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeadLetterWebhookDelivery", ctx, deliveryID, statusCode, message)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeadLetterWebhookDelivery indicates an expected call of DeadLetterWebhookDelivery.
+func (mr *MockRepositoryMockRecorder) DeadLetterWebhookDelivery(ctx, deliveryID, statusCode, message interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateWithdrawal", reflect.TypeOf((*MockRepository)(nil).CreateWithdrawal), ctx, withdrawal)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeadLetterWebhookDelivery", reflect.TypeOf((*MockRepository)(nil).DeadLetterWebhookDelivery), ctx, deliveryID, statusCode, message)
 }
 
-// GetAllOrdersIDs mocks base method.
-func (m *MockRepository) GetAllOrdersIDs(ctx context.Context) ([]string, error) {
+// DeleteExpiredIdempotencyKeys mocks base method.
+func (m *MockRepository) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllOrdersIDs", ctx)
-	ret0, _ := ret[0].([]string)
+	ret := m.ctrl.Call(m, "DeleteExpiredIdempotencyKeys", ctx)
+	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetAllOrdersIDs indicates an expected call of GetAllOrdersIDs.
-func (mr *MockRepositoryMockRecorder) GetAllOrdersIDs(ctx interface{}) *gomock.Call {
+// DeleteExpiredIdempotencyKeys indicates an expected call of DeleteExpiredIdempotencyKeys.
+func (mr *MockRepositoryMockRecorder) DeleteExpiredIdempotencyKeys(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllOrdersIDs", reflect.TypeOf((*MockRepository)(nil).GetAllOrdersIDs), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredIdempotencyKeys", reflect.TypeOf((*MockRepository)(nil).DeleteExpiredIdempotencyKeys), ctx)
 }
 
-// GetUsersBalance mocks base method.
-func (m *MockRepository) GetUsersBalance(ctx context.Context) (float64, error) {
+// DeleteExpiredUserEvents mocks base method.
+func (m *MockRepository) DeleteExpiredUserEvents(ctx context.Context, retention time.Duration) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUsersBalance", ctx)
-	ret0, _ := ret[0].(float64)
+	ret := m.ctrl.Call(m, "DeleteExpiredUserEvents", ctx, retention)
+	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetUsersBalance indicates an expected call of GetUsersBalance.
-func (mr *MockRepositoryMockRecorder) GetUsersBalance(ctx interface{}) *gomock.Call {
+// DeleteExpiredUserEvents indicates an expected call of DeleteExpiredUserEvents.
+func (mr *MockRepositoryMockRecorder) DeleteExpiredUserEvents(ctx, retention interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersBalance", reflect.TypeOf((*MockRepository)(nil).GetUsersBalance), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredUserEvents", reflect.TypeOf((*MockRepository)(nil).DeleteExpiredUserEvents), ctx, retention)
 }
 
-// GetUsersOrders mocks base method.
-func (m *MockRepository) GetUsersOrders(ctx context.Context) ([]models.Order, error) {
+// DeleteIdempotencyKey mocks base method.
+func (m *MockRepository) DeleteIdempotencyKey(ctx context.Context, userID, key string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUsersOrders", ctx)
-	ret0, _ := ret[0].([]models.Order)
+	ret := m.ctrl.Call(m, "DeleteIdempotencyKey", ctx, userID, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIdempotencyKey indicates an expected call of DeleteIdempotencyKey.
+func (mr *MockRepositoryMockRecorder) DeleteIdempotencyKey(ctx, userID, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIdempotencyKey", reflect.TypeOf((*MockRepository)(nil).DeleteIdempotencyKey), ctx, userID, key)
+}
+
+// DeleteWebhookEndpoint mocks base method.
+func (m *MockRepository) DeleteWebhookEndpoint(ctx context.Context, userID, endpointID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteWebhookEndpoint", ctx, userID, endpointID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteWebhookEndpoint indicates an expected call of DeleteWebhookEndpoint.
+func (mr *MockRepositoryMockRecorder) DeleteWebhookEndpoint(ctx, userID, endpointID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteWebhookEndpoint", reflect.TypeOf((*MockRepository)(nil).DeleteWebhookEndpoint), ctx, userID, endpointID)
+}
+
+// GetBalance mocks base method.
+func (m *MockRepository) GetBalance(ctx context.Context) (models.Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalance", ctx)
+	ret0, _ := ret[0].(models.Balance)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetUsersOrders indicates an expected call of GetUsersOrders.
-func (mr *MockRepositoryMockRecorder) GetUsersOrders(ctx interface{}) *gomock.Call {
+// GetBalance indicates an expected call of GetBalance.
+func (mr *MockRepositoryMockRecorder) GetBalance(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersOrders", reflect.TypeOf((*MockRepository)(nil).GetUsersOrders), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockRepository)(nil).GetBalance), ctx)
 }
 
-// GetUsersWithdraw mocks base method.
-func (m *MockRepository) GetUsersWithdraw(ctx context.Context) (float64, error) {
+// GetLastUserEventID mocks base method.
+func (m *MockRepository) GetLastUserEventID(ctx context.Context, userID string) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUsersWithdraw", ctx)
-	ret0, _ := ret[0].(float64)
+	ret := m.ctrl.Call(m, "GetLastUserEventID", ctx, userID)
+	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetUsersWithdraw indicates an expected call of GetUsersWithdraw.
-func (mr *MockRepositoryMockRecorder) GetUsersWithdraw(ctx interface{}) *gomock.Call {
+// GetLastUserEventID indicates an expected call of GetLastUserEventID.
+func (mr *MockRepositoryMockRecorder) GetLastUserEventID(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersWithdraw", reflect.TypeOf((*MockRepository)(nil).GetUsersWithdraw), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLastUserEventID", reflect.TypeOf((*MockRepository)(nil).GetLastUserEventID), ctx, userID)
 }
 
-// GetUsersWithdrawals mocks base method.
-func (m *MockRepository) GetUsersWithdrawals(ctx context.Context) ([]models.Withdrawal, error) {
+// GetLoginLockouts mocks base method.
+func (m *MockRepository) GetLoginLockouts(ctx context.Context, limit int) ([]models.LoginLockout, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUsersWithdrawals", ctx)
-	ret0, _ := ret[0].([]models.Withdrawal)
+	ret := m.ctrl.Call(m, "GetLoginLockouts", ctx, limit)
+	ret0, _ := ret[0].([]models.LoginLockout)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLoginLockouts indicates an expected call of GetLoginLockouts.
+func (mr *MockRepositoryMockRecorder) GetLoginLockouts(ctx, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLoginLockouts", reflect.TypeOf((*MockRepository)(nil).GetLoginLockouts), ctx, limit)
+}
+
+// GetUserEvents mocks base method.
+func (m *MockRepository) GetUserEvents(ctx context.Context, userID string, afterID int64, limit int) ([]models.UserEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserEvents", ctx, userID, afterID, limit)
+	ret0, _ := ret[0].([]models.UserEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserEvents indicates an expected call of GetUserEvents.
+func (mr *MockRepositoryMockRecorder) GetUserEvents(ctx, userID, afterID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserEvents", reflect.TypeOf((*MockRepository)(nil).GetUserEvents), ctx, userID, afterID, limit)
+}
+
+// GetUsersOrder mocks base method.
+func (m *MockRepository) GetUsersOrder(ctx context.Context, orderNumber string) (models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUsersOrder", ctx, orderNumber)
+	ret0, _ := ret[0].(models.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsersOrder indicates an expected call of GetUsersOrder.
+func (mr *MockRepositoryMockRecorder) GetUsersOrder(ctx, orderNumber interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersOrder", reflect.TypeOf((*MockRepository)(nil).GetUsersOrder), ctx, orderNumber)
+}
+
+// GetWebhookDeliveries mocks base method.
+func (m *MockRepository) GetWebhookDeliveries(ctx context.Context, userID, endpointID string, limit int) ([]models.WebhookDelivery, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhookDeliveries", ctx, userID, endpointID, limit)
+	ret0, _ := ret[0].([]models.WebhookDelivery)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetWebhookDeliveries indicates an expected call of GetWebhookDeliveries.
+func (mr *MockRepositoryMockRecorder) GetWebhookDeliveries(ctx, userID, endpointID, limit interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookDeliveries", reflect.TypeOf((*MockRepository)(nil).GetWebhookDeliveries), ctx, userID, endpointID, limit)
+}
+
+// GetWebhookEndpoints mocks base method.
+func (m *MockRepository) GetWebhookEndpoints(ctx context.Context, userID string) ([]models.WebhookEndpoint, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWebhookEndpoints", ctx, userID)
+	ret0, _ := ret[0].([]models.WebhookEndpoint)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetUsersWithdrawals indicates an expected call of GetUsersWithdrawals.
-func (mr *MockRepositoryMockRecorder) GetUsersWithdrawals(ctx interface{}) *gomock.Call {
+// GetWebhookEndpoints indicates an expected call of GetWebhookEndpoints.
+func (mr *MockRepositoryMockRecorder) GetWebhookEndpoints(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersWithdrawals", reflect.TypeOf((*MockRepository)(nil).GetUsersWithdrawals), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWebhookEndpoints", reflect.TypeOf((*MockRepository)(nil).GetWebhookEndpoints), ctx, userID)
+}
+
+// IsSessionActive mocks base method.
+func (m *MockRepository) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IsSessionActive", ctx, sessionID)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsSessionActive indicates an expected call of IsSessionActive.
+func (mr *MockRepositoryMockRecorder) IsSessionActive(ctx, sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsSessionActive", reflect.TypeOf((*MockRepository)(nil).IsSessionActive), ctx, sessionID)
+}
+
+// ListUsersOrders mocks base method.
+func (m *MockRepository) ListUsersOrders(ctx context.Context, query pagination.Query) ([]models.Order, *pagination.Cursor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsersOrders", ctx, query)
+	ret0, _ := ret[0].([]models.Order)
+	ret1, _ := ret[1].(*pagination.Cursor)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListUsersOrders indicates an expected call of ListUsersOrders.
+func (mr *MockRepositoryMockRecorder) ListUsersOrders(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsersOrders", reflect.TypeOf((*MockRepository)(nil).ListUsersOrders), ctx, query)
+}
+
+// ListUsersWithdrawals mocks base method.
+func (m *MockRepository) ListUsersWithdrawals(ctx context.Context, query pagination.Query) ([]models.Withdrawal, *pagination.Cursor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUsersWithdrawals", ctx, query)
+	ret0, _ := ret[0].([]models.Withdrawal)
+	ret1, _ := ret[1].(*pagination.Cursor)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListUsersWithdrawals indicates an expected call of ListUsersWithdrawals.
+func (mr *MockRepositoryMockRecorder) ListUsersWithdrawals(ctx, query interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsersWithdrawals", reflect.TypeOf((*MockRepository)(nil).ListUsersWithdrawals), ctx, query)
+}
+
+// ListenUserEvents mocks base method.
+func (m *MockRepository) ListenUserEvents(ctx context.Context, notify func(userID string)) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListenUserEvents", ctx, notify)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ListenUserEvents indicates an expected call of ListenUserEvents.
+func (mr *MockRepositoryMockRecorder) ListenUserEvents(ctx, notify interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListenUserEvents", reflect.TypeOf((*MockRepository)(nil).ListenUserEvents), ctx, notify)
+}
+
+// MarkAccrualChecked mocks base method.
+func (m *MockRepository) MarkAccrualChecked(ctx context.Context, orderNumber string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkAccrualChecked", ctx, orderNumber)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkAccrualChecked indicates an expected call of MarkAccrualChecked.
+func (mr *MockRepositoryMockRecorder) MarkAccrualChecked(ctx, orderNumber interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkAccrualChecked", reflect.TypeOf((*MockRepository)(nil).MarkAccrualChecked), ctx, orderNumber)
+}
+
+// RedeliverWebhook mocks base method.
+func (m *MockRepository) RedeliverWebhook(ctx context.Context, userID, endpointID, deliveryID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RedeliverWebhook", ctx, userID, endpointID, deliveryID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RedeliverWebhook indicates an expected call of RedeliverWebhook.
+func (mr *MockRepositoryMockRecorder) RedeliverWebhook(ctx, userID, endpointID, deliveryID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RedeliverWebhook", reflect.TypeOf((*MockRepository)(nil).RedeliverWebhook), ctx, userID, endpointID, deliveryID)
 }
 
 // RegisterOrder mocks base method.
@@ -170,6 +472,134 @@ func (mr *MockRepositoryMockRecorder) RegisterOrder(ctx, orderNumber interface{}
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterOrder", reflect.TypeOf((*MockRepository)(nil).RegisterOrder), ctx, orderNumber)
 }
 
+// RescheduleAccrualJob mocks base method.
+func (m *MockRepository) RescheduleAccrualJob(ctx context.Context, orderNumber string, delay time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RescheduleAccrualJob", ctx, orderNumber, delay)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RescheduleAccrualJob indicates an expected call of RescheduleAccrualJob.
+func (mr *MockRepositoryMockRecorder) RescheduleAccrualJob(ctx, orderNumber, delay interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RescheduleAccrualJob", reflect.TypeOf((*MockRepository)(nil).RescheduleAccrualJob), ctx, orderNumber, delay)
+}
+
+// ReserveIdempotencyKey mocks base method.
+func (m *MockRepository) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) (models.IdempotencyRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveIdempotencyKey", ctx, record, ttl)
+	ret0, _ := ret[0].(models.IdempotencyRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReserveIdempotencyKey indicates an expected call of ReserveIdempotencyKey.
+func (mr *MockRepositoryMockRecorder) ReserveIdempotencyKey(ctx, record, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveIdempotencyKey", reflect.TypeOf((*MockRepository)(nil).ReserveIdempotencyKey), ctx, record, ttl)
+}
+
+// ResetLoginFailures mocks base method.
+func (m *MockRepository) ResetLoginFailures(ctx context.Context, scope, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetLoginFailures", ctx, scope, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetLoginFailures indicates an expected call of ResetLoginFailures.
+func (mr *MockRepositoryMockRecorder) ResetLoginFailures(ctx, scope, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetLoginFailures", reflect.TypeOf((*MockRepository)(nil).ResetLoginFailures), ctx, scope, key)
+}
+
+// RetryWebhookDelivery mocks base method.
+func (m *MockRepository) RetryWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string, delay time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RetryWebhookDelivery", ctx, deliveryID, statusCode, message, delay)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RetryWebhookDelivery indicates an expected call of RetryWebhookDelivery.
+func (mr *MockRepositoryMockRecorder) RetryWebhookDelivery(ctx, deliveryID, statusCode, message, delay interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RetryWebhookDelivery", reflect.TypeOf((*MockRepository)(nil).RetryWebhookDelivery), ctx, deliveryID, statusCode, message, delay)
+}
+
+// RevokeSession mocks base method.
+func (m *MockRepository) RevokeSession(ctx context.Context, sessionID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeSession", ctx, sessionID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeSession indicates an expected call of RevokeSession.
+func (mr *MockRepositoryMockRecorder) RevokeSession(ctx, sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeSession", reflect.TypeOf((*MockRepository)(nil).RevokeSession), ctx, sessionID)
+}
+
+// RevokeUserSessions mocks base method.
+func (m *MockRepository) RevokeUserSessions(ctx context.Context, userID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeUserSessions", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeUserSessions indicates an expected call of RevokeUserSessions.
+func (mr *MockRepositoryMockRecorder) RevokeUserSessions(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeUserSessions", reflect.TypeOf((*MockRepository)(nil).RevokeUserSessions), ctx, userID)
+}
+
+// RotateSession mocks base method.
+func (m *MockRepository) RotateSession(ctx context.Context, refreshTokenHash, newRefreshTokenHash string, expiresAt time.Time) (models.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateSession", ctx, refreshTokenHash, newRefreshTokenHash, expiresAt)
+	ret0, _ := ret[0].(models.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateSession indicates an expected call of RotateSession.
+func (mr *MockRepositoryMockRecorder) RotateSession(ctx, refreshTokenHash, newRefreshTokenHash, expiresAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateSession", reflect.TypeOf((*MockRepository)(nil).RotateSession), ctx, refreshTokenHash, newRefreshTokenHash, expiresAt)
+}
+
+// SaveIdempotencyResponse mocks base method.
+func (m *MockRepository) SaveIdempotencyResponse(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveIdempotencyResponse", ctx, record, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveIdempotencyResponse indicates an expected call of SaveIdempotencyResponse.
+func (mr *MockRepositoryMockRecorder) SaveIdempotencyResponse(ctx, record, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveIdempotencyResponse", reflect.TypeOf((*MockRepository)(nil).SaveIdempotencyResponse), ctx, record, ttl)
+}
+
+// UnlockLogin mocks base method.
+func (m *MockRepository) UnlockLogin(ctx context.Context, scope, key, unlockedBy string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnlockLogin", ctx, scope, key, unlockedBy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnlockLogin indicates an expected call of UnlockLogin.
+func (mr *MockRepositoryMockRecorder) UnlockLogin(ctx, scope, key, unlockedBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnlockLogin", reflect.TypeOf((*MockRepository)(nil).UnlockLogin), ctx, scope, key, unlockedBy)
+}
+
 // UpdateOrder mocks base method.
 func (m *MockRepository) UpdateOrder(ctx context.Context, order models.Order) (models.Order, error) {
 	m.ctrl.T.Helper()
@@ -184,3 +614,18 @@ func (mr *MockRepositoryMockRecorder) UpdateOrder(ctx, order interface{}) *gomoc
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockRepository)(nil).UpdateOrder), ctx, order)
 }
+
+// Withdraw mocks base method.
+func (m *MockRepository) Withdraw(ctx context.Context, userID, orderNumber string, sum models.Money) (models.Withdrawal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Withdraw", ctx, userID, orderNumber, sum)
+	ret0, _ := ret[0].(models.Withdrawal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Withdraw indicates an expected call of Withdraw.
+func (mr *MockRepositoryMockRecorder) Withdraw(ctx, userID, orderNumber, sum interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Withdraw", reflect.TypeOf((*MockRepository)(nil).Withdraw), ctx, userID, orderNumber, sum)
+}
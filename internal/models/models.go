@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/PaBah/gofermart/internal/utils"
@@ -16,16 +17,161 @@ type Order struct {
 	Number     string    `json:"number"`
 	UserID     string    `json:"-"`
 	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual"`
+	Accrual    Money     `json:"accrual"`
 	UploadedAt time.Time `json:"uploaded_at"`
+	// AccrualCheckedAt — время последнего ответа системы расчёта по заказу; нулевое, если ответа ещё не было.
+	AccrualCheckedAt time.Time `json:"accrual_checked_at"`
 }
 
 type Withdrawal struct {
 	OrderNumber string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	Sum         Money     `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
 }
 
+type Balance struct {
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
+}
+
+type Session struct {
+	ID               string
+	UserID           string
+	RefreshTokenHash string
+	ExpiresAt        time.Time
+}
+
+const (
+	LoginScopeLogin = "login"
+	LoginScopeIP    = "ip"
+)
+
+// LoginThrottle — счётчик неудачных попыток входа для логина или IP-адреса.
+type LoginThrottle struct {
+	Scope         string
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// LoginPolicy — ограничения попыток входа для одного счётчика.
+type LoginPolicy struct {
+	// MaxFailures — число попыток подряд, после которого ключ блокируется; 0 отключает блокировку
+	MaxFailures int
+	Lockout     time.Duration
+	// Window — через сколько после последней неудачи счётчик начинается заново
+	Window   time.Duration
+	Delay    time.Duration
+	MaxDelay time.Duration
+}
+
+// DelayAfter возвращает паузу перед следующей попыткой: Delay, удваиваемый с каждой неудачей до MaxDelay.
+func (p LoginPolicy) DelayAfter(failures int) time.Duration {
+	if failures < 1 || p.Delay <= 0 {
+		return 0
+	}
+
+	delay := p.Delay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// LoginAttempt — результат учёта попытки входа. Отклонённая попытка не учитывается, повторить её можно через RetryAfter.
+type LoginAttempt struct {
+	Allowed     bool
+	Failures    int
+	LockedUntil time.Time
+	RetryAfter  time.Duration
+}
+
+type LoginLockout struct {
+	ID          int64      `json:"id"`
+	Scope       string     `json:"scope"`
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LockedUntil time.Time  `json:"locked_until"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UnlockedAt  *time.Time `json:"unlocked_at,omitempty"`
+	UnlockedBy  string     `json:"unlocked_by,omitempty"`
+}
+
+const (
+	UserEventOrder   = "order"
+	UserEventBalance = "balance"
+)
+
+// UserEvent — запись журнала событий пользователя; ID монотонно растёт и служит Last-Event-ID.
+type UserEvent struct {
+	ID        int64
+	UserID    string
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+const (
+	WebhookEventOrderProcessed    = "order.processed"
+	WebhookEventOrderInvalid      = "order.invalid"
+	WebhookEventWithdrawalCreated = "withdrawal.created"
+
+	WebhookDeliveryPending   = "PENDING"
+	WebhookDeliveryDelivered = "DELIVERED"
+	WebhookDeliveryDead      = "DEAD"
+)
+
+// WebhookEvents — события, на которые можно подписать эндпоинт.
+var WebhookEvents = []string{WebhookEventOrderProcessed, WebhookEventOrderInvalid, WebhookEventWithdrawalCreated}
+
+// WebhookEndpoint — адрес для исходящих уведомлений. Пустой UserID у эндпоинтов администратора.
+type WebhookEndpoint struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery — попытка доставить событие на эндпоинт; URL и Secret заполняются только при выборке в очередь.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	EndpointID     string          `json:"endpoint_id"`
+	URL            string          `json:"-"`
+	Secret         string          `json:"-"`
+	EventType      string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+type AccrualJob struct {
+	OrderNumber string
+	Attempts    int
+	UploadedAt  time.Time
+	// TraceParent связывает опросы заказа с трассой запроса, загрузившего его
+	TraceParent string
+}
+
+type IdempotencyRecord struct {
+	Key         string
+	UserID      string
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
 func NewUser(login string, originalPassword string) User {
 	return User{Login: login, Password: utils.PasswordHash(originalPassword)}
 }
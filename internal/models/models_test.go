@@ -0,0 +1,20 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginPolicy_DelayAfter(t *testing.T) {
+	policy := LoginPolicy{Delay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, time.Duration(0), policy.DelayAfter(0))
+	assert.Equal(t, time.Second, policy.DelayAfter(1))
+	assert.Equal(t, 2*time.Second, policy.DelayAfter(2))
+	assert.Equal(t, 8*time.Second, policy.DelayAfter(4))
+	assert.Equal(t, 10*time.Second, policy.DelayAfter(5), "Delay capped")
+	assert.Equal(t, 10*time.Second, policy.DelayAfter(100), "Delay capped")
+	assert.Equal(t, time.Duration(0), LoginPolicy{}.DelayAfter(3), "Delay disabled")
+}
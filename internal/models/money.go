@@ -0,0 +1,178 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money — денежная сумма в минимальных единицах (сотых долях балла) без потерь точности float64.
+type Money int64
+
+// RoundedMoney — сумма из ответов внешних систем: допускает экспоненту и лишние знаки, округляя до сотых.
+type RoundedMoney Money
+
+const (
+	moneyScale = 100
+	// maxMoneyExponent ограничивает экспоненту, чтобы "1e999999999" не раздувал big.Rat
+	maxMoneyExponent = 1000
+)
+
+var (
+	ErrInvalidMoney = errors.New("invalid money amount")
+
+	jsonNumberPattern = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][+-]?\d+)?$`)
+)
+
+// ParseMoney разбирает десятичную запись вида "123", "-1.5" или "729.98". Больше двух знаков после точки не допускается.
+func ParseMoney(value string) (Money, error) {
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+	if value == "" {
+		return 0, ErrInvalidMoney
+	}
+
+	whole, fraction, hasFraction := strings.Cut(value, ".")
+	if whole == "" || (hasFraction && fraction == "") {
+		return 0, ErrInvalidMoney
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > 2 {
+		return 0, fmt.Errorf("%w: %s has more than 2 fractional digits", ErrInvalidMoney, value)
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+
+	units, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidMoney, value)
+	}
+	cents, err := strconv.ParseUint(fraction, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidMoney, value)
+	}
+
+	if units > (math.MaxInt64-cents)/moneyScale {
+		return 0, fmt.Errorf("%w: %s is out of range", ErrInvalidMoney, value)
+	}
+	amount := Money(units*moneyScale + cents)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// ParseMoneyRounded разбирает JSON-число вроде "729.975" или "1e2" и округляет его до сотых по банковскому правилу.
+func ParseMoneyRounded(value string) (Money, error) {
+	value = strings.TrimSpace(value)
+	if !jsonNumberPattern.MatchString(value) {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidMoney, value)
+	}
+	if _, exponent, ok := strings.Cut(strings.ToLower(value), "e"); ok {
+		exp, err := strconv.Atoi(exponent)
+		if err != nil || exp > maxMoneyExponent || exp < -maxMoneyExponent {
+			return 0, fmt.Errorf("%w: %s is out of range", ErrInvalidMoney, value)
+		}
+	}
+
+	amount, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidMoney, value)
+	}
+	amount.Mul(amount, big.NewRat(moneyScale, 1))
+
+	// Округление половины к чётному: 0.125 -> 0.12, 0.135 -> 0.14
+	cents, remainder := new(big.Int).QuoRem(amount.Num(), amount.Denom(), new(big.Int))
+	remainder.Abs(remainder).Lsh(remainder, 1)
+	if cmp := remainder.Cmp(amount.Denom()); cmp > 0 || (cmp == 0 && cents.Bit(0) == 1) {
+		cents.Add(cents, big.NewInt(int64(amount.Sign())))
+	}
+	if !cents.IsInt64() {
+		return 0, fmt.Errorf("%w: %s is out of range", ErrInvalidMoney, value)
+	}
+	return Money(cents.Int64()), nil
+}
+
+func (m Money) String() string {
+	sign := ""
+	abs := int64(m)
+	if abs < 0 {
+		sign = "-"
+		abs = -abs
+	}
+
+	units, cents := abs/moneyScale, abs%moneyScale
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+
+	parsed, err := ParseMoney(strings.Trim(value, `"`))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m RoundedMoney) MarshalJSON() ([]byte, error) {
+	return Money(m).MarshalJSON()
+}
+
+func (m *RoundedMoney) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+
+	parsed, err := ParseMoneyRounded(strings.Trim(value, `"`))
+	if err != nil {
+		return err
+	}
+	*m = RoundedMoney(parsed)
+	return nil
+}
+
+// Scan читает NUMERIC из базы; NULL превращается в ноль.
+func (m *Money) Scan(src interface{}) (err error) {
+	switch value := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(value * moneyScale)
+	case float64:
+		*m, err = ParseMoney(strconv.FormatFloat(value, 'f', -1, 64))
+	case string:
+		*m, err = ParseMoney(value)
+	case []byte:
+		*m, err = ParseMoney(string(value))
+	default:
+		err = fmt.Errorf("%w: unsupported type %T", ErrInvalidMoney, src)
+	}
+	return
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Money
+		expectOK bool
+	}{
+		{"0", 0, true},
+		{"42", 4200, true},
+		{"500.5", 50050, true},
+		{"729.98", 72998, true},
+		{"0.1", 10, true},
+		{"1.230", 123, true},
+		{"-123.4", -12340, true},
+		{"0.001", 0, false},
+		{"", 0, false},
+		{"-", 0, false},
+		{".5", 0, false},
+		{"1.", 0, false},
+		{"1e2", 0, false},
+		{"abc", 0, false},
+		{"92233720368547758.07", 9223372036854775807, true},
+		{"92233720368547758.08", 0, false},
+		{"184467440737095516.16", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			amount, err := ParseMoney(test.value)
+			if !test.expectOK {
+				assert.ErrorIs(t, err, ErrInvalidMoney)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, amount)
+		})
+	}
+}
+
+func TestParseMoneyRounded(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected Money
+		expectOK bool
+	}{
+		{"42", 4200, true},
+		{"729.98", 72998, true},
+		{"729.975", 72998, true},
+		{"729.985", 72998, true},
+		{"0.125", 12, true},
+		{"0.1251", 13, true},
+		{"-0.135", -14, true},
+		{"1e2", 10000, true},
+		{"1.5E-1", 15, true},
+		{"1e-50", 0, true},
+		{"92233720368547758.07", 9223372036854775807, true},
+		{"92233720368547758.08", 0, false},
+		{"1e1001", 0, false},
+		{"", 0, false},
+		{"1/2", 0, false},
+		{"0x10", 0, false},
+		{".5", 0, false},
+		{"Inf", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			amount, err := ParseMoneyRounded(test.value)
+			if !test.expectOK {
+				assert.ErrorIs(t, err, ErrInvalidMoney)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, amount)
+		})
+	}
+}
+
+func TestMoney_String(t *testing.T) {
+	assert.Equal(t, "0", Money(0).String())
+	assert.Equal(t, "42", Money(4200).String())
+	assert.Equal(t, "500.5", Money(50050).String())
+	assert.Equal(t, "0.05", Money(5).String())
+	assert.Equal(t, "-123.4", Money(-12340).String())
+}
+
+func TestMoney_JSON(t *testing.T) {
+	var withdrawal Withdrawal
+	err := json.Unmarshal([]byte(`{"order":"2377225624","sum":751.01}`), &withdrawal)
+	assert.NoError(t, err)
+	assert.Equal(t, Money(75101), withdrawal.Sum, "No float drift on unmarshal")
+
+	err = json.Unmarshal([]byte(`{"order":"2377225624","sum":751.015}`), &withdrawal)
+	assert.ErrorIs(t, err, ErrInvalidMoney, "User sums stay strict")
+
+	var accrual struct {
+		Accrual RoundedMoney `json:"accrual"`
+	}
+	err = json.Unmarshal([]byte(`{"accrual":729.975}`), &accrual)
+	assert.NoError(t, err)
+	assert.Equal(t, RoundedMoney(72998), accrual.Accrual, "External sums are rounded")
+
+	data, err := json.Marshal(Balance{Current: 50050, Withdrawn: 4200})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"current":500.5,"withdrawn":42}`, string(data), "Wire format kept")
+}
+
+func TestMoney_Scan(t *testing.T) {
+	var amount Money
+	assert.NoError(t, amount.Scan("123.45"))
+	assert.Equal(t, Money(12345), amount)
+	assert.NoError(t, amount.Scan([]byte("0.1")))
+	assert.Equal(t, Money(10), amount)
+	assert.NoError(t, amount.Scan(12.3))
+	assert.Equal(t, Money(1230), amount)
+	assert.NoError(t, amount.Scan(int64(7)))
+	assert.Equal(t, Money(700), amount)
+	assert.NoError(t, amount.Scan(nil))
+	assert.Equal(t, Money(0), amount)
+	assert.Error(t, amount.Scan(true))
+}
@@ -0,0 +1,147 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/problem"
+	"github.com/PaBah/gofermart/internal/validation"
+)
+
+// MaxLimit — наибольший размер страницы, который можно запросить через ?limit=.
+const MaxLimit = 1000
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor указывает на последнюю отданную запись: время и ключ, разрешающий совпадения по времени.
+type Cursor struct {
+	Time       time.Time `json:"t"`
+	Key        string    `json:"k"`
+	Descending bool      `json:"d,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodeCursor(value string) (cursor Cursor, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(raw, &cursor); err != nil || cursor.Time.IsZero() || cursor.Key == "" {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// Query — параметры выборки страницы списка. Нулевые Limit, From и To не ограничивают выборку.
+type Query struct {
+	Limit      int
+	Cursor     *Cursor
+	Statuses   []string
+	From       time.Time
+	To         time.Time
+	Descending bool
+}
+
+// ParseQuery разбирает ?limit=&cursor=&status=&from=&to=&sort=. Без ?limit= отдаётся весь список, как обещает
+// спецификация. Статусы принимаются только из allowedStatuses; если allowedStatuses пуст, фильтр по статусу недоступен.
+func ParseQuery(values url.Values, descendingByDefault bool, allowedStatuses ...string) (Query, error) {
+	query := Query{Descending: descendingByDefault}
+	errs := validation.Errors{}
+	add := func(field string, code string, message string) {
+		errs = append(errs, problem.FieldError{Field: field, Code: code, Message: message})
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			add("limit", "range", "limit must be an integer from 1 to "+strconv.Itoa(MaxLimit))
+		} else {
+			query.Limit = limit
+		}
+	}
+
+	switch values.Get("sort") {
+	case "":
+	case "asc":
+		query.Descending = false
+	case "desc":
+		query.Descending = true
+	default:
+		add("sort", "enum", "sort must be asc or desc")
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		cursor, err := DecodeCursor(value)
+		switch {
+		case err != nil:
+			add("cursor", "format", "cursor is malformed")
+		case cursor.Descending != query.Descending:
+			add("cursor", "sort", "cursor was issued for another sort direction")
+		default:
+			query.Cursor = &cursor
+		}
+	}
+
+	for _, value := range values["status"] {
+		for _, status := range strings.Split(value, ",") {
+			if len(allowedStatuses) == 0 {
+				add("status", "unsupported", "status filter is not supported")
+				break
+			}
+			if !contains(allowedStatuses, status) {
+				add("status", "enum", "status must be one of: "+strings.Join(allowedStatuses, ", "))
+				break
+			}
+			query.Statuses = append(query.Statuses, status)
+		}
+	}
+
+	for _, bound := range []struct {
+		field  string
+		target *time.Time
+	}{{"from", &query.From}, {"to", &query.To}} {
+		field, target := bound.field, bound.target
+		if value := values.Get(field); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				add(field, "format", field+" must be RFC 3339 date-time")
+			} else {
+				*target = parsed
+			}
+		}
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		add("to", "range", "to must be after from")
+	}
+
+	if len(errs) > 0 {
+		return query, errs
+	}
+	return query, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// NextURL возвращает адрес следующей страницы с теми же фильтрами.
+func NextURL(requestURL *url.URL, next Cursor) string {
+	values := requestURL.Query()
+	values.Set("cursor", next.Encode())
+	nextURL := url.URL{Path: requestURL.Path, RawQuery: values.Encode()}
+	return nextURL.String()
+}
@@ -0,0 +1,79 @@
+package pagination
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor_EncodeDecode(t *testing.T) {
+	cursor := Cursor{Time: time.Date(2024, 5, 1, 10, 0, 0, 123, time.UTC), Key: "12345678903", Descending: true}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	require.NoError(t, err)
+	assert.True(t, cursor.Time.Equal(decoded.Time), "Time keeps nanoseconds")
+	assert.Equal(t, cursor.Key, decoded.Key)
+	assert.True(t, decoded.Descending)
+
+	for _, value := range []string{"not base64!", "e30", Cursor{Key: "no time"}.Encode()} {
+		_, err = DecodeCursor(value)
+		assert.ErrorIs(t, err, ErrInvalidCursor, value)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	descCursor := Cursor{Time: from, Key: "1", Descending: true}
+
+	testCases := []struct {
+		name           string
+		query          string
+		descending     bool
+		expected       Query
+		expectedFields []string
+	}{
+		{name: "defaults", query: "", expected: Query{}},
+		{name: "default descending", query: "", descending: true, expected: Query{Descending: true}},
+		{name: "all parameters", query: "limit=10&sort=desc&status=NEW,PROCESSED&status=INVALID&from=2024-05-01T00:00:00Z&to=2024-06-01T00:00:00Z&cursor=" + descCursor.Encode(),
+			expected: Query{Limit: 10, Descending: true, Statuses: []string{"NEW", "PROCESSED", "INVALID"}, From: from, To: from.AddDate(0, 1, 0), Cursor: &descCursor}},
+		{name: "limit above max", query: "limit=1001", expectedFields: []string{"limit"}},
+		{name: "limit not a number", query: "limit=ten", expectedFields: []string{"limit"}},
+		{name: "unknown status", query: "status=DONE", expectedFields: []string{"status"}},
+		{name: "cursor direction", query: "cursor=" + descCursor.Encode(), expectedFields: []string{"cursor"}},
+		{name: "empty range", query: "from=2024-05-01T00:00:00Z&to=2024-05-01T00:00:00Z", expectedFields: []string{"to"}},
+		{name: "malformed dates", query: "from=2024-05-01&to=tomorrow", expectedFields: []string{"from", "to"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tc.query)
+			query, err := ParseQuery(values, tc.descending, "NEW", "PROCESSING", "INVALID", "PROCESSED")
+			if tc.expectedFields == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected, query)
+				return
+			}
+
+			assert.Zero(t, query.Limit, "Invalid limit is not applied")
+
+			var errs validation.Errors
+			require.ErrorAs(t, err, &errs)
+			fields := make([]string, 0)
+			for _, fieldError := range errs {
+				fields = append(fields, fieldError.Field)
+			}
+			assert.Equal(t, tc.expectedFields, fields)
+		})
+	}
+}
+
+func TestNextURL(t *testing.T) {
+	requestURL, _ := url.Parse("/api/user/orders?limit=2&cursor=old")
+	next := Cursor{Time: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), Key: "1"}
+
+	assert.Equal(t, "/api/user/orders?cursor="+next.Encode()+"&limit=2", NextURL(requestURL, next))
+}
@@ -0,0 +1,99 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/PaBah/gofermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	ContentType = "application/problem+json"
+	typePrefix  = "urn:gophermart:problem:"
+)
+
+// Машиночитаемые коды ошибок API.
+const (
+	CodeInvalidContentType   = "invalid_content_type"
+	CodeInvalidJSON          = "invalid_json"
+	CodeBodyTooLarge         = "body_too_large"
+	CodeValidationFailed     = "validation_failed"
+	CodeInvalidOrderNumber   = "invalid_order_number"
+	CodeLoginTaken           = "login_taken"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeTooManyLoginAttempts = "too_many_login_attempts"
+	CodeUnauthorized         = "unauthorized"
+	CodeSessionRevoked       = "session_revoked"
+	CodeInvalidRefreshToken  = "invalid_refresh_token"
+	CodeCSRFFailed           = "csrf_failed"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeOrderConflict        = "order_uploaded_by_another_user"
+	CodeInsufficientFunds    = "insufficient_funds"
+	CodeWithdrawalExists     = "withdrawal_already_registered"
+	CodeIdempotencyMismatch  = "idempotency_key_reused"
+	CodeIdempotencyInFlight  = "idempotency_key_in_progress"
+	CodeInvalidParameter     = "invalid_parameter"
+	CodeInternal             = "internal_error"
+)
+
+// FieldError описывает нарушенное правило для одного поля запроса.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Problem — тело ответа об ошибке по RFC 7807.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+func New(status int, code string, detail string) Problem {
+	return Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Write отправляет problem как ответ на запрос req.
+func (p Problem) Write(res http.ResponseWriter, req *http.Request) {
+	if req != nil {
+		p.Instance = req.URL.Path
+	}
+
+	resBody, err := json.Marshal(p)
+	if err != nil {
+		http.Error(res, p.Detail, p.Status)
+		return
+	}
+
+	res.Header().Set("Content-Type", ContentType)
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(p.Status)
+	_, err = res.Write(resBody)
+	if err != nil {
+		logger.Log().Error("Can not write problem response", zap.Error(err))
+	}
+}
+
+// Error — сокращение для New(status, code, detail).Write(res, req).
+func Error(res http.ResponseWriter, req *http.Request, status int, code string, detail string) {
+	New(status, code, detail).Write(res, req)
+}
+
+// Internal скрывает подробности внутренней ошибки от клиента и пишет их в лог.
+func Internal(res http.ResponseWriter, req *http.Request, err error) {
+	logger.Log().Error("Request failed", zap.String("path", req.URL.Path), zap.Error(err))
+	Error(res, req, http.StatusInternalServerError, CodeInternal, "Internal server error")
+}
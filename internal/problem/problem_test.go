@@ -0,0 +1,45 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProblem_Write(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/user/register", nil)
+
+	p := New(http.StatusUnprocessableEntity, CodeValidationFailed, "Request validation failed")
+	p.Errors = []FieldError{{Field: "login", Code: "required", Message: "login is required"}}
+	p.Write(w, r)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"type": "urn:gophermart:problem:validation_failed",
+		"title": "Unprocessable Entity",
+		"status": 422,
+		"detail": "Request validation failed",
+		"instance": "/api/user/register",
+		"code": "validation_failed",
+		"errors": [{"field": "login", "code": "required", "message": "login is required"}]
+	}`, w.Body.String())
+}
+
+func TestInternal(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+
+	Internal(w, r, errors.New("pq: connection refused"))
+
+	p := Problem{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+	assert.Equal(t, http.StatusInternalServerError, p.Status)
+	assert.Equal(t, CodeInternal, p.Code)
+	assert.NotContains(t, w.Body.String(), "connection refused", "Internal details are not exposed")
+}
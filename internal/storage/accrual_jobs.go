@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+)
+
+// ClaimAccrualJobs забирает до limit готовых к опросу заказов и блокирует их на время lease.
+// SKIP LOCKED позволяет нескольким экземплярам сервиса разбирать очередь без повторного опроса.
+func (ds *DBStorage) ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) (jobs []models.AccrualJob, err error) {
+	rows, err := ds.db.QueryContext(ctx,
+		`UPDATE accrual_jobs SET locked_until = now() + $1 * interval '1 millisecond', attempts = attempts + 1
+		WHERE order_number IN (
+			SELECT order_number FROM accrual_jobs
+			WHERE next_attempt_at <= now() AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED
+		) RETURNING order_number, attempts, (SELECT uploaded_at FROM orders WHERE number=order_number), coalesce(trace_parent, '')`, lease.Milliseconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs = make([]models.AccrualJob, 0)
+	var job models.AccrualJob
+
+	for rows.Next() {
+		err = rows.Scan(&job.OrderNumber, &job.Attempts, &job.UploadedAt, &job.TraceParent)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	err = rows.Err()
+	return
+}
+
+// RescheduleAccrualJob снимает блокировку и откладывает следующий опрос заказа на delay.
+func (ds *DBStorage) RescheduleAccrualJob(ctx context.Context, orderNumber string, delay time.Duration) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE accrual_jobs SET next_attempt_at = now() + $1 * interval '1 millisecond', locked_until = NULL WHERE order_number=$2`,
+		delay.Milliseconds(), orderNumber)
+	return err
+}
+
+// MarkAccrualChecked фиксирует ответ системы расчёта, не изменивший заказ.
+func (ds *DBStorage) MarkAccrualChecked(ctx context.Context, orderNumber string) error {
+	_, err := ds.db.ExecContext(ctx, `UPDATE orders SET accrual_checked_at=now() WHERE number=$1`, orderNumber)
+	return err
+}
+
+// CountOrdersByStatus считает заказы в каждом из statuses; статусы без заказов в ответ не попадают.
+func (ds *DBStorage) CountOrdersByStatus(ctx context.Context, statuses ...string) (counts map[string]int64, err error) {
+	rows, err := ds.db.QueryContext(ctx,
+		`SELECT status, count(*) FROM orders WHERE status::text = ANY($1) GROUP BY status`, statuses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts = make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	err = rows.Err()
+	return
+}
@@ -4,47 +4,46 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
-	"github.com/PaBah/gofermart/db"
 	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/config"
 	"github.com/PaBah/gofermart/internal/models"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/postgres"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/PaBah/gofermart/internal/tracing"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type DBStorage struct {
-	db *sql.DB
+	db dbHandle
 }
 
-func (ds *DBStorage) initialize(ctx context.Context, databaseDSN string) (err error) {
-
-	ds.db, err = sql.Open("pgx", databaseDSN)
+func (ds *DBStorage) initialize(ctx context.Context, options config.DatabaseOptions) (err error) {
+	ds.db, err = openHandle(ctx, options)
 	if err != nil {
 		return
 	}
-
-	driver, err := iofs.New(db.MigrationsFS, "migrations")
+	err = connect(ctx, ds.db.PingContext, options.ConnectTimeout)
 	if err != nil {
-		return err
+		return
 	}
 
-	d, err := postgres.WithInstance(ds.db, &postgres.Config{})
+	migrator, err := newMigrator(ctx, ds.db.stdDB())
 	if err != nil {
-		return err
+		return
 	}
+	defer func() {
+		err = errors.Join(err, migrator.Close())
+	}()
 
-	m, err := migrate.NewWithInstance("iofs", driver, "psql_db", d)
-	if err != nil {
-		return err
+	if !options.NoAutoMigrate {
+		if err = migrator.Up(); err != nil {
+			return fmt.Errorf("can not apply migrations: %w", err)
+		}
 	}
-
-	_ = m.Up()
-	return
+	return migrator.CheckVersion()
 }
 
 func (ds *DBStorage) CreateUser(ctx context.Context, user models.User) (createdUser models.User, err error) {
@@ -75,153 +74,192 @@ func (ds *DBStorage) AuthorizeUser(ctx context.Context, login string) (user mode
 
 func (ds *DBStorage) RegisterOrder(ctx context.Context, orderNumber string) (order models.Order, err error) {
 	userID := ctx.Value(auth.ContextUserKey).(string)
-	_, DBerr := ds.db.ExecContext(ctx,
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, DBerr := tx.ExecContext(ctx,
 		`INSERT INTO orders(number, user_id) VALUES ($1, $2)`, orderNumber, userID)
 
 	var pgErr *pgconn.PgError
 	if errors.As(DBerr, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
 		err = ErrAlreadyExists
+	} else if DBerr != nil {
+		return order, DBerr
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO accrual_jobs(order_number, trace_parent) VALUES ($1, NULLIF($2, ''))`, orderNumber, tracing.TraceParent(ctx))
+		if err != nil {
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			return
+		}
 	}
 
 	row := ds.db.QueryRowContext(ctx, `SELECT number, user_id, uploaded_at FROM orders WHERE number=$1`, orderNumber)
 	var number, ordersUserID string
 	var uploadedAt time.Time
 
-	_ = row.Scan(&number, &ordersUserID, &uploadedAt)
+	scanErr := row.Scan(&number, &ordersUserID, &uploadedAt)
+	if scanErr != nil {
+		return order, scanErr
+	}
 	order = models.Order{Number: number, UserID: ordersUserID, UploadedAt: uploadedAt}
-	return
-}
-
-func (ds *DBStorage) UpdateOrder(ctx context.Context, order models.Order) (updatedOrder models.Order, err error) {
-	_, err = ds.db.ExecContext(ctx,
-		`UPDATE orders SET accrual=$1, status=$2 WHERE number=$3`, order.Accrual, order.Status, order.Number)
-	if err == nil {
-		updatedOrder = order
+	if err != nil && ordersUserID != userID {
+		err = ErrOrderConflict
 	}
 	return
 }
 
-func (ds *DBStorage) GetUsersOrders(ctx context.Context) (orders []models.Order, err error) {
+// GetUsersOrder возвращает заказ текущего пользователя; чужой заказ неотличим от отсутствующего.
+func (ds *DBStorage) GetUsersOrder(ctx context.Context, orderNumber string) (order models.Order, err error) {
 	userID := ctx.Value(auth.ContextUserKey).(string)
 
-	rows, err := ds.db.QueryContext(ctx, `SELECT number, status, accrual, uploaded_at FROM orders where user_id=$1`, userID)
-	if err != nil {
-		return nil, err
+	var checkedAt sql.NullTime
+	row := ds.db.QueryRowContext(ctx,
+		`SELECT number, status, accrual, uploaded_at, accrual_checked_at FROM orders WHERE number=$1 AND user_id=$2`, orderNumber, userID)
+	err = row.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt, &checkedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, ErrNotFound
 	}
-	defer rows.Close()
-
-	orders = make([]models.Order, 0)
-	var accrual sql.NullFloat64
-	var uploadedAt time.Time
-	var status, number string
-
-	for rows.Next() {
-		err = rows.Scan(&number, &status, &accrual, &uploadedAt)
-		if err != nil {
-			return nil, err
-		}
-		orders = append(orders, models.Order{
-			Number:     number,
-			Status:     status,
-			Accrual:    accrual.Float64,
-			UploadedAt: uploadedAt,
-		})
+	if err != nil {
+		return
 	}
-	err = rows.Err()
+	order.UserID = userID
+	order.AccrualCheckedAt = checkedAt.Time
 	return
 }
 
-func (ds *DBStorage) GetUsersWithdrawals(ctx context.Context) (withdrawals []models.Withdrawal, err error) {
-	userID := ctx.Value(auth.ContextUserKey).(string)
+func (ds *DBStorage) UpdateOrder(ctx context.Context, order models.Order) (updatedOrder models.Order, err error) {
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
 
-	rows, err := ds.db.QueryContext(ctx, `SELECT number, sum, processed_at FROM withdrawals WHERE user_id=$1 ORDER BY processed_at DESC`, userID)
+	var userID string
+	row := tx.QueryRowContext(ctx,
+		`UPDATE orders SET accrual=$1, status=$2, accrual_checked_at=now() WHERE number=$3 RETURNING user_id`, order.Accrual, order.Status, order.Number)
+	err = row.Scan(&userID)
 	if err != nil {
-		return nil, err
+		return
 	}
-	defer rows.Close()
 
-	withdrawals = make([]models.Withdrawal, 0)
-	var sum sql.NullFloat64
-	var processedAt time.Time
-	var number string
+	if order.Status == "PROCESSED" && order.Accrual > 0 {
+		err = lockUserEvents(ctx, tx, userID)
+		if err != nil {
+			return
+		}
+		err = postLedgerEntry(ctx, tx, userID, order.Number, LedgerEntryAccrual, order.Accrual)
+		if err != nil {
+			return
+		}
+	}
 
-	for rows.Next() {
-		err = rows.Scan(&number, &sum, &processedAt)
+	if order.Status == "PROCESSED" || order.Status == "INVALID" {
+		_, err = tx.ExecContext(ctx, `DELETE FROM accrual_jobs WHERE order_number=$1`, order.Number)
 		if err != nil {
-			return nil, err
+			return
+		}
+
+		eventType := models.WebhookEventOrderProcessed
+		if order.Status == "INVALID" {
+			eventType = models.WebhookEventOrderInvalid
 		}
-		withdrawals = append(withdrawals, models.Withdrawal{
-			OrderNumber: number,
-			Sum:         sum.Float64,
-			ProcessedAt: processedAt,
+		err = enqueueWebhooks(ctx, tx, userID, eventType, map[string]interface{}{
+			"user_id": userID, "number": order.Number, "status": order.Status, "accrual": order.Accrual,
 		})
+		if err != nil {
+			return
+		}
 	}
-	err = rows.Err()
-	return
-}
 
-func (ds *DBStorage) CreateWithdrawal(ctx context.Context, withdrawal models.Withdrawal) (createdWithdrawal models.Withdrawal, err error) {
-	userID := ctx.Value(auth.ContextUserKey).(string)
-	_, err = ds.db.ExecContext(ctx,
-		`INSERT INTO withdrawals(number, sum, user_id) VALUES ($1, $2, $3)`, withdrawal.OrderNumber, withdrawal.Sum, userID)
+	err = tx.Commit()
+	if err == nil {
+		updatedOrder = order
+		updatedOrder.UserID = userID
+	}
 	return
 }
 
-func (ds *DBStorage) GetUsersBalance(ctx context.Context) (balance float64, err error) {
-	userID := ctx.Value(auth.ContextUserKey).(string)
-	row := ds.db.QueryRowContext(ctx, `SELECT SUM(accrual) FROM orders WHERE user_id=$1`, userID)
-	var nullBalance sql.NullFloat64
+func (ds *DBStorage) Withdraw(ctx context.Context, userID string, orderNumber string, sum models.Money) (withdrawal models.Withdrawal, err error) {
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
 
-	err = row.Scan(&nullBalance)
+	err = lockUserEvents(ctx, tx, userID)
 	if err != nil {
 		return
 	}
 
-	balance = nullBalance.Float64
-	return
-}
+	var current models.Money
+	row := tx.QueryRowContext(ctx, `SELECT current FROM balances WHERE user_id=$1 FOR UPDATE`, userID)
+	err = row.Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return
+	}
 
-func (ds *DBStorage) GetUsersWithdraw(ctx context.Context) (withdraw float64, err error) {
-	userID := ctx.Value(auth.ContextUserKey).(string)
-	row := ds.db.QueryRowContext(ctx, `SELECT SUM(sum) FROM withdrawals WHERE user_id=$1`, userID)
-	var nullWithdraw sql.NullFloat64
+	if current < sum {
+		err = ErrInsufficientFunds
+		return
+	}
 
-	err = row.Scan(&nullWithdraw)
+	var processedAt time.Time
+	row = tx.QueryRowContext(ctx,
+		`INSERT INTO withdrawals(number, sum, user_id) VALUES ($1, $2, $3) RETURNING processed_at`, orderNumber, sum, userID)
+	err = row.Scan(&processedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		err = ErrAlreadyExists
+		return
+	}
 	if err != nil {
 		return
 	}
 
-	withdraw = nullWithdraw.Float64
-	return
-}
-
-func (ds *DBStorage) GetAllOrdersIDs(ctx context.Context) (orderIDs []string, err error) {
-	rows, err := ds.db.QueryContext(ctx, `SELECT number FROM orders WHERE status in ('NEW', 'PROCESSING')`)
+	err = postLedgerEntry(ctx, tx, userID, orderNumber, LedgerEntryWithdrawal, -sum)
 	if err != nil {
-		return nil, err
+		return
 	}
-	defer rows.Close()
 
-	orderIDs = make([]string, 0)
-	var number string
+	err = enqueueWebhooks(ctx, tx, userID, models.WebhookEventWithdrawalCreated, map[string]interface{}{
+		"user_id": userID, "order": orderNumber, "sum": sum, "processed_at": processedAt,
+	})
+	if err != nil {
+		return
+	}
 
-	for rows.Next() {
-		err = rows.Scan(&number)
-		if err != nil {
-			return nil, err
-		}
-		orderIDs = append(orderIDs, number)
+	err = tx.Commit()
+	if err == nil {
+		withdrawal = models.Withdrawal{OrderNumber: orderNumber, Sum: sum, ProcessedAt: processedAt}
 	}
-	err = rows.Err()
 	return
 }
 
+// DB отдаёт пул соединений database/sql для сбора его статистики.
+func (ds *DBStorage) DB() *sql.DB {
+	return ds.db.stdDB()
+}
+
+// Pool отдаёт пул pgxpool для сбора его статистики; nil, если выбран драйвер sql.
+func (ds *DBStorage) Pool() *pgxpool.Pool {
+	if handle, ok := ds.db.(pgxHandle); ok {
+		return handle.pool
+	}
+	return nil
+}
+
 func (ds *DBStorage) Close() error {
 	return ds.db.Close()
 }
 
-func NewDBStorage(ctx context.Context, databaseDSN string) (DBStorage, error) {
+func NewDBStorage(ctx context.Context, options config.DatabaseOptions) (DBStorage, error) {
 	store := DBStorage{}
-	err := store.initialize(ctx, databaseDSN)
+	err := store.initialize(ctx, options)
 	return store, err
 }
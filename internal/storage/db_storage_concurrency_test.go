@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var databaseDrivers = []string{config.DatabaseDriverSQL, config.DatabaseDriverPgxPool}
+
+// testDBStorage подключается к настоящему Postgres: TEST_DATABASE_URI=postgres://... go test ./internal/storage/
+func testDBStorage(tb testing.TB, driver string) DBStorage {
+	databaseURI := os.Getenv("TEST_DATABASE_URI")
+	if databaseURI == "" {
+		tb.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	options := config.Default().Database
+	options.URI = databaseURI
+	options.Driver = driver
+	ds, err := NewDBStorage(context.Background(), options)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { _ = ds.Close() })
+	return ds
+}
+
+func TestDBStorage_WithdrawConcurrent(t *testing.T) {
+	for _, driver := range databaseDrivers {
+		t.Run(driver, func(t *testing.T) {
+			ds := testDBStorage(t, driver)
+			testWithdrawConcurrent(t, &ds)
+		})
+	}
+}
+
+func testWithdrawConcurrent(t *testing.T, ds Repository) {
+	suffix := time.Now().UnixNano()
+	user, err := ds.CreateUser(context.Background(), models.User{Login: fmt.Sprintf("concurrent-%d", suffix), Password: "test"})
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), auth.ContextUserKey, user.ID)
+	orderNumber := fmt.Sprintf("%d", suffix)
+	_, err = ds.RegisterOrder(ctx, orderNumber)
+	require.NoError(t, err)
+	_, err = ds.UpdateOrder(ctx, models.Order{Number: orderNumber, Status: "PROCESSED", Accrual: 10000})
+	require.NoError(t, err)
+
+	const attempts = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, rejected := 0, 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.Withdraw(context.Background(), user.ID, fmt.Sprintf("%d-%d", suffix, i), 1000)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrInsufficientFunds):
+				rejected++
+			default:
+				t.Errorf("unexpected withdrawal error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	balance, err := ds.GetBalance(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 10, succeeded, "Only funded withdrawals succeed")
+	assert.Equal(t, attempts-10, rejected, "Other withdrawals rejected")
+	assert.Equal(t, models.Money(0), balance.Current, "Balance never goes negative")
+	assert.Equal(t, models.Money(10000), balance.Withdrawn, "Withdrawn sum matches")
+}
+
+func TestDBStorage_UserEventsConcurrent(t *testing.T) {
+	for _, driver := range databaseDrivers {
+		t.Run(driver, func(t *testing.T) {
+			ds := testDBStorage(t, driver)
+			testUserEventsConcurrent(t, &ds)
+		})
+	}
+}
+
+// testUserEventsConcurrent читает журнал событий, продвигая курсор, пока начисления и списания одного пользователя
+// идут параллельно: событие, зафиксированное позже события с большим id, было бы пропущено.
+func testUserEventsConcurrent(t *testing.T, ds Repository) {
+	suffix := time.Now().UnixNano()
+	user, err := ds.CreateUser(context.Background(), models.User{Login: fmt.Sprintf("events-%d", suffix), Password: "test"})
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), auth.ContextUserKey, user.ID)
+	const operations = 20
+	for i := 0; i <= operations; i++ {
+		_, err = ds.RegisterOrder(ctx, fmt.Sprintf("%d%02d", suffix, i))
+		require.NoError(t, err)
+	}
+	_, err = ds.UpdateOrder(ctx, models.Order{Number: fmt.Sprintf("%d%02d", suffix, 0), Status: "PROCESSED", Accrual: 100000})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	seen := make(map[int64]struct{})
+	var readErr error
+	read := func(afterID int64) int64 {
+		for {
+			events, err := ds.GetUserEvents(context.Background(), user.ID, afterID, 100)
+			if err != nil {
+				readErr = err
+				return afterID
+			}
+			if len(events) == 0 {
+				return afterID
+			}
+			for _, event := range events {
+				seen[event.ID] = struct{}{}
+				afterID = event.ID
+			}
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var lastID int64
+		for {
+			select {
+			case <-done:
+				read(lastID)
+				return
+			default:
+				lastID = read(lastID)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 1; i <= operations; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.UpdateOrder(ctx, models.Order{Number: fmt.Sprintf("%d%02d", suffix, i), Status: "PROCESSED", Accrual: 100})
+			assert.NoError(t, err)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			_, err := ds.Withdraw(context.Background(), user.ID, fmt.Sprintf("w%d%02d", suffix, i), 100)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	<-stopped
+	require.NoError(t, readErr)
+
+	all, err := ds.GetUserEvents(context.Background(), user.ID, 0, 1000)
+	require.NoError(t, err)
+	for _, event := range all {
+		_, ok := seen[event.ID]
+		assert.True(t, ok, "Reader advancing the cursor sees event %d", event.ID)
+	}
+}
+
+// BenchmarkDBStorage_ListUsersOrders сравнивает драйверы на чтении списка заказов:
+// TEST_DATABASE_URI=postgres://... go test -run ^$ -bench DBStorage ./internal/storage/
+func BenchmarkDBStorage_ListUsersOrders(b *testing.B) {
+	for _, driver := range databaseDrivers {
+		b.Run(driver, func(b *testing.B) {
+			ds := testDBStorage(b, driver)
+			suffix := time.Now().UnixNano()
+			user, err := ds.CreateUser(context.Background(), models.User{Login: fmt.Sprintf("bench-%s-%d", driver, suffix), Password: "test"})
+			require.NoError(b, err)
+			ctx := context.WithValue(context.Background(), auth.ContextUserKey, user.ID)
+			for i := 0; i < 20; i++ {
+				_, err = ds.RegisterOrder(ctx, fmt.Sprintf("%d%02d", suffix, i))
+				require.NoError(b, err)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, err := ds.ListUsersOrders(ctx, pagination.Query{Limit: 20}); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ds := testDBStorage(t, config.DatabaseDriverSQL)
+	migrator, err := newMigrator(context.Background(), ds.DB())
+	require.NoError(t, err)
+	defer migrator.Close()
+
+	latest, err := LatestMigrationVersion()
+	require.NoError(t, err)
+	require.NoError(t, migrator.CheckVersion(), "Storage applies migrations at startup")
+
+	require.NoError(t, migrator.Down(1))
+	assert.ErrorIs(t, migrator.CheckVersion(), ErrSchemaBehind)
+
+	require.NoError(t, migrator.Goto(latest))
+	version, dirty, err := migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, latest, version)
+	assert.False(t, dirty)
+}
@@ -2,19 +2,26 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/PaBah/gofermart/db"
 	"github.com/PaBah/gofermart/internal/auth"
 	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDBStorage_Close(t *testing.T) {
 	db, mock, _ := sqlmock.New()
-	dbStorage := DBStorage{db: db}
+	dbStorage := DBStorage{db: newSQLHandle(db, 0)}
 	mock.ExpectClose().WillReturnError(nil)
 
 	err := dbStorage.Close()
@@ -24,7 +31,7 @@ func TestDBStorage_Close(t *testing.T) {
 func TestDBStorage_CreateAuthUser(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
 	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users(login, password) VALUES ($1, $2)")).
 		WithArgs("test", "test").WillReturnResult(sqlmock.NewResult(1, 1))
@@ -43,10 +50,14 @@ func TestDBStorage_CreateAuthUser(t *testing.T) {
 func TestDBStorage_RegisterOrder(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
+	mock.ExpectBegin()
 	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO orders(number, user_id) VALUES ($1, $2)")).
 		WithArgs("test", "test").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO accrual_jobs(order_number, trace_parent) VALUES ($1, NULLIF($2, ''))")).
+		WithArgs("test", "").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, user_id, uploaded_at FROM orders WHERE number=$1")).
 		WithArgs("test").
@@ -59,106 +70,694 @@ func TestDBStorage_RegisterOrder(t *testing.T) {
 	assert.Equal(t, "test", createdOrder.UserID, "Order owner store correctly")
 }
 
+func TestDBStorage_RegisterOrderConflict(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	ctx := context.WithValue(context.Background(), auth.ContextUserKey, "test")
+	insertQuery := regexp.QuoteMeta("INSERT INTO orders(number, user_id) VALUES ($1, $2)")
+	selectQuery := regexp.QuoteMeta("SELECT number, user_id, uploaded_at FROM orders WHERE number=$1")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(insertQuery).WithArgs("test", "test").WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	mock.ExpectQuery(selectQuery).WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"number", "user_id", "uploaded_at"}).AddRow("test", "other", time.Now()))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(insertQuery).WithArgs("test", "test").WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	mock.ExpectQuery(selectQuery).WithArgs("test").WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	order, err := ds.RegisterOrder(ctx, "test")
+	assert.ErrorIs(t, err, ErrOrderConflict, "Order of another user")
+	assert.Equal(t, "other", order.UserID)
+
+	_, err = ds.RegisterOrder(ctx, "test")
+	assert.ErrorIs(t, err, sql.ErrConnDone, "Database failure is not a conflict")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_GetUsersOrder(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	timestamp := time.Now()
+	query := regexp.QuoteMeta("SELECT number, status, accrual, uploaded_at, accrual_checked_at FROM orders WHERE number=$1 AND user_id=$2")
+	mock.ExpectQuery(query).
+		WithArgs("test", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual", "uploaded_at", "accrual_checked_at"}).
+			AddRow("test", "PROCESSED", "12.5", timestamp, nil))
+	mock.ExpectQuery(query).
+		WithArgs("other", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual", "uploaded_at", "accrual_checked_at"}))
+
+	ctx := context.WithValue(context.Background(), auth.ContextUserKey, "test")
+	order, err := ds.GetUsersOrder(ctx, "test")
+	assert.NoError(t, err, "Order loaded without error")
+	assert.Equal(t, models.Order{Number: "test", UserID: "test", Status: "PROCESSED", Accrual: 1250, UploadedAt: timestamp}, order, "Never checked order has zero check time")
+
+	_, err = ds.GetUsersOrder(ctx, "other")
+	assert.ErrorIs(t, err, ErrNotFound, "Order of another user is not found")
+}
+
 func TestDBStorage_UpdateOrder(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
-	mock.ExpectExec(regexp.QuoteMeta("UPDATE orders SET accrual=$1, status=$2 WHERE number=$3")).
-		WithArgs(123.4, "PROCESSED", "test").WillReturnResult(sqlmock.NewResult(1, 1))
-	order := models.Order{Number: "test", Accrual: 123.4, Status: "PROCESSED"}
-	updatedOrder, err := ds.UpdateOrder(context.WithValue(context.Background(), auth.ContextUserKey, "test"), order)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE orders SET accrual=$1, status=$2, accrual_checked_at=now() WHERE number=$3 RETURNING user_id")).
+		WithArgs("123.4", "PROCESSED", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("owner"))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT lock_user_events($1)")).WithArgs("owner").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO ledger_entries(user_id, order_number, entry_type, amount) VALUES ($1, $2, $3, $4) ON CONFLICT (order_number) WHERE entry_type = 'ACCRUAL' DO NOTHING")).
+		WithArgs("owner", "test", LedgerEntryAccrual, "123.4").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances(user_id, current, withdrawn) VALUES ($1, $2, $3)")).
+		WithArgs("owner", "123.4", "0").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM accrual_jobs WHERE order_number=$1")).
+		WithArgs("test").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO webhook_deliveries(endpoint_id, event_type, payload)")).
+		WithArgs(models.WebhookEventOrderProcessed, []byte(`{"accrual":123.4,"number":"test","status":"PROCESSED","user_id":"owner"}`), "owner").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	order := models.Order{Number: "test", Accrual: 12340, Status: "PROCESSED"}
+	updatedOrder, err := ds.UpdateOrder(context.Background(), order)
 	assert.NoError(t, err, "User created without error")
 	assert.Equal(t, "test", updatedOrder.Number, "Order store correctly")
 	assert.Equal(t, "PROCESSED", updatedOrder.Status, "Order status store correctly")
-	assert.Equal(t, 123.4, updatedOrder.Accrual, "Order accrual store correctly")
+	assert.Equal(t, models.Money(12340), updatedOrder.Accrual, "Order accrual store correctly")
+	assert.Equal(t, "owner", updatedOrder.UserID, "Order owner loaded correctly")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Accrual posted to ledger")
 }
 
-func TestDBStorage_GetUsersOrders(t *testing.T) {
+func TestDBStorage_UpdateOrderAlreadyPosted(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("UPDATE orders SET accrual=$1, status=$2, accrual_checked_at=now() WHERE number=$3 RETURNING user_id")).
+		WithArgs("123.4", "PROCESSED", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("owner"))
+	mock.ExpectExec(regexp.QuoteMeta("SELECT lock_user_events($1)")).WithArgs("owner").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO ledger_entries(user_id, order_number, entry_type, amount)")).
+		WithArgs("owner", "test", LedgerEntryAccrual, "123.4").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM accrual_jobs WHERE order_number=$1")).
+		WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO webhook_deliveries(endpoint_id, event_type, payload)")).
+		WithArgs(models.WebhookEventOrderProcessed, sqlmock.AnyArg(), "owner").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	order := models.Order{Number: "test", Accrual: 12340, Status: "PROCESSED"}
+	_, err := ds.UpdateOrder(context.Background(), order)
+	assert.NoError(t, err, "Repeated accrual does not fail")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Balance is not touched twice")
+}
+
+func TestDBStorage_ListUsersOrders(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
 	}
 	timestamp := time.Now()
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, status, accrual, uploaded_at FROM orders where user_id=$1")).
-		WithArgs("test").
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, status, accrual, uploaded_at FROM orders WHERE user_id=$1 ORDER BY uploaded_at ASC, number ASC LIMIT $2")).
+		WithArgs("test", 2).
 		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual", "uploaded_at"}).
-			AddRow("test", "NEW", 0, timestamp))
+			AddRow("test", "NEW", 0, timestamp).
+			AddRow("next", "NEW", 0, timestamp))
 
-	orders, err := ds.GetUsersOrders(context.WithValue(context.Background(), auth.ContextUserKey, "test"))
+	orders, next, err := ds.ListUsersOrders(context.WithValue(context.Background(), auth.ContextUserKey, "test"), pagination.Query{Limit: 1})
 	assert.NoError(t, err, "NO error on orders list")
 	assert.Equal(t, orders, []models.Order{models.Order{Number: "test", Status: "NEW", Accrual: 0, UploadedAt: timestamp}}, "Order lists equal")
+	assert.Equal(t, &pagination.Cursor{Time: timestamp, Key: "test"}, next, "Cursor points to the last order of the page")
+}
+
+func TestDBStorage_ListUsersOrdersFiltered(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	cursor := &pagination.Cursor{Time: to.Add(-time.Hour), Key: "12345678903", Descending: true}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, status, accrual, uploaded_at FROM orders WHERE user_id=$1 AND status::text IN ($2, $3) AND uploaded_at >= $4 AND uploaded_at < $5 AND (uploaded_at, number) < ($6, $7) ORDER BY uploaded_at DESC, number DESC LIMIT $8")).
+		WithArgs("test", "NEW", "PROCESSING", from, to, cursor.Time, cursor.Key, 11).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual", "uploaded_at"}))
+
+	query := pagination.Query{Limit: 10, Cursor: cursor, Statuses: []string{"NEW", "PROCESSING"}, From: from, To: to, Descending: true}
+	orders, next, err := ds.ListUsersOrders(context.WithValue(context.Background(), auth.ContextUserKey, "test"), query)
+	assert.NoError(t, err, "NO error on orders list")
+	assert.Empty(t, orders)
+	assert.Nil(t, next, "No next page")
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestDBStorage_GetUsersWithdrawals(t *testing.T) {
+func TestDBStorage_ListUsersWithdrawals(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
 	timestamp := time.Now()
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT number, sum, processed_at FROM withdrawals WHERE user_id=$1 ORDER BY processed_at DESC")).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, number, sum, processed_at FROM withdrawals WHERE user_id=$1 ORDER BY processed_at DESC, id DESC")).
 		WithArgs("test").
-		WillReturnRows(sqlmock.NewRows([]string{"number", "sum", "processed_at"}).
-			AddRow("test", 0, timestamp))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "number", "sum", "processed_at"}).
+			AddRow("id", "test", 0, timestamp))
 
-	withdrawals, err := ds.GetUsersWithdrawals(context.WithValue(context.Background(), auth.ContextUserKey, "test"))
+	withdrawals, next, err := ds.ListUsersWithdrawals(context.WithValue(context.Background(), auth.ContextUserKey, "test"), pagination.Query{Descending: true})
 	assert.NoError(t, err, "NO error on withdrawals list")
 	assert.Equal(t, withdrawals, []models.Withdrawal{models.Withdrawal{OrderNumber: "test", Sum: 0, ProcessedAt: timestamp}}, "Withdrawal lists equal")
+	assert.Nil(t, next, "No next page")
 }
 
-func TestDBStorage_CreateWithdrawal(t *testing.T) {
+func TestDBStorage_Withdraw(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
-	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO withdrawals(number, sum, user_id) VALUES ($1, $2, $3)")).
-		WithArgs("test", 123.4, "test").WillReturnResult(sqlmock.NewResult(1, 1))
+	timestamp := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT lock_user_events($1)")).WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT current FROM balances WHERE user_id=$1 FOR UPDATE")).
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"current"}).AddRow(200.0))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO withdrawals(number, sum, user_id) VALUES ($1, $2, $3) RETURNING processed_at")).
+		WithArgs("test", "123.4", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"processed_at"}).AddRow(timestamp))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO ledger_entries(user_id, order_number, entry_type, amount) VALUES ($1, $2, $3, $4)")).
+		WithArgs("test", "test", LedgerEntryWithdrawal, "-123.4").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO balances(user_id, current, withdrawn) VALUES ($1, $2, $3)")).
+		WithArgs("test", "-123.4", "123.4").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO webhook_deliveries(endpoint_id, event_type, payload)")).
+		WithArgs(models.WebhookEventWithdrawalCreated, sqlmock.AnyArg(), "test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
 
-	withdrawal := models.Withdrawal{OrderNumber: "test", Sum: 123.4}
-	_, err := ds.CreateWithdrawal(context.WithValue(context.Background(), auth.ContextUserKey, "test"), withdrawal)
-	assert.NoError(t, err, "User created without error")
+	withdrawal, err := ds.Withdraw(context.Background(), "test", "test", 12340)
+	assert.NoError(t, err, "Withdrawal created without error")
+	assert.Equal(t, models.Withdrawal{OrderNumber: "test", Sum: 12340, ProcessedAt: timestamp}, withdrawal, "Withdrawal returned correctly")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Withdrawal posted to ledger")
+}
+
+func TestDBStorage_WithdrawInsufficientFunds(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT lock_user_events($1)")).WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT current FROM balances WHERE user_id=$1 FOR UPDATE")).
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"current"}).AddRow(100.0))
+	mock.ExpectRollback()
+
+	_, err := ds.Withdraw(context.Background(), "test", "test", 12340)
+	assert.ErrorIs(t, err, ErrInsufficientFunds, "Withdrawal rejected")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Nothing written on rejection")
 }
 
-func TestDBStorage_GetUsersBalance(t *testing.T) {
+func TestDBStorage_WithdrawDuplicate(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT SUM(accrual) FROM orders WHERE user_id=$1")).
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT lock_user_events($1)")).WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT current FROM balances WHERE user_id=$1 FOR UPDATE")).
 		WithArgs("test").
-		WillReturnRows(sqlmock.NewRows([]string{"sum_accrual"}).
-			AddRow(123.7))
+		WillReturnRows(sqlmock.NewRows([]string{"current"}).AddRow(200.0))
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO withdrawals(number, sum, user_id) VALUES ($1, $2, $3) RETURNING processed_at")).
+		WithArgs("test", "123.4", "test").
+		WillReturnError(&pgconn.PgError{Code: pgerrcode.UniqueViolation})
+	mock.ExpectRollback()
 
-	balance, err := ds.GetUsersBalance(context.WithValue(context.Background(), auth.ContextUserKey, "test"))
+	_, err := ds.Withdraw(context.Background(), "test", "test", 12340)
+	assert.ErrorIs(t, err, ErrAlreadyExists, "Order number already used for withdrawal")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Nothing written on duplicate")
+}
+
+func TestDBStorage_WithdrawNoBalance(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT lock_user_events($1)")).WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT current FROM balances WHERE user_id=$1 FOR UPDATE")).
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"current"}))
+	mock.ExpectRollback()
+
+	_, err := ds.Withdraw(context.Background(), "test", "test", 100)
+	assert.ErrorIs(t, err, ErrInsufficientFunds, "Withdrawal without balance rejected")
+}
+
+func TestDBStorage_GetBalance(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT current, withdrawn FROM balances WHERE user_id=$1")).
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"current", "withdrawn"}).
+			AddRow(111.4, 12.3))
+
+	balance, err := ds.GetBalance(context.WithValue(context.Background(), auth.ContextUserKey, "test"))
 	assert.NoError(t, err, "NO error on balance")
-	assert.Equal(t, balance, 123.7, "Balances equal")
+	assert.Equal(t, models.Balance{Current: 11140, Withdrawn: 1230}, balance, "Balances equal")
+}
+
+func TestDBStorage_GetBalanceEmpty(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT current, withdrawn FROM balances WHERE user_id=$1")).
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"current", "withdrawn"}))
+
+	balance, err := ds.GetBalance(context.WithValue(context.Background(), auth.ContextUserKey, "test"))
+	assert.NoError(t, err, "NO error on empty balance")
+	assert.Equal(t, models.Balance{}, balance, "Empty balance is zero")
+}
+
+func TestDBStorage_ClaimAccrualJobs(t *testing.T) {
+	uploadedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("FOR UPDATE SKIP LOCKED")).
+		WithArgs(int64(60000), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"order_number", "attempts", "uploaded_at", "trace_parent"}).
+			AddRow("test1", 1, uploadedAt, traceParent).AddRow("test2", 3, uploadedAt, ""))
+
+	jobs, err := ds.ClaimAccrualJobs(context.Background(), 10, time.Minute)
+	assert.NoError(t, err, "NO error on claiming jobs")
+	assert.Equal(t, []models.AccrualJob{
+		{OrderNumber: "test1", Attempts: 1, UploadedAt: uploadedAt, TraceParent: traceParent},
+		{OrderNumber: "test2", Attempts: 3, UploadedAt: uploadedAt},
+	}, jobs, "Claimed jobs equal")
+}
+
+func TestDBStorage_CountOrdersByStatus(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT status, count(*) FROM orders WHERE status::text = ANY($1) GROUP BY status")).
+		WithArgs([]string{"NEW", "PROCESSING"}).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("PROCESSING", 7))
+
+	counts, err := ds.CountOrdersByStatus(context.Background(), "NEW", "PROCESSING")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int64{"PROCESSING": 7}, counts, "Statuses without orders are omitted")
+}
+
+func TestDBStorage_RescheduleAccrualJob(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE accrual_jobs SET next_attempt_at = now() + $1 * interval '1 millisecond', locked_until = NULL WHERE order_number=$2")).
+		WithArgs(int64(5000), "test").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := ds.RescheduleAccrualJob(context.Background(), "test", 5*time.Second)
+	assert.NoError(t, err, "NO error on rescheduling job")
+}
+
+func TestDBStorage_ReserveIdempotencyKey(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO idempotency_keys(key, user_id, request_hash, expires_at)")).
+		WithArgs("key", "test", "hash", int64(300000)).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	record := models.IdempotencyRecord{Key: "key", UserID: "test", RequestHash: "hash"}
+	storedRecord, err := ds.ReserveIdempotencyKey(context.Background(), record, 5*time.Minute)
+	assert.NoError(t, err, "Key reserved without error")
+	assert.Equal(t, record, storedRecord, "Reserved record returned")
+}
+
+func TestDBStorage_ReserveIdempotencyKeyExisting(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO idempotency_keys(key, user_id, request_hash, expires_at)")).
+		WithArgs("key", "test", "hash", int64(300000)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT request_hash, status_code, content_type, body FROM idempotency_keys WHERE user_id=$1 AND key=$2")).
+		WithArgs("test", "key").
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "body"}).
+			AddRow("hash", 200, "application/json", []byte("{}")))
+
+	record := models.IdempotencyRecord{Key: "key", UserID: "test", RequestHash: "hash"}
+	storedRecord, err := ds.ReserveIdempotencyKey(context.Background(), record, 5*time.Minute)
+	assert.ErrorIs(t, err, ErrAlreadyExists, "Existing key reported")
+	assert.Equal(t, models.IdempotencyRecord{Key: "key", UserID: "test", RequestHash: "hash", StatusCode: 200, ContentType: "application/json", Body: []byte("{}")}, storedRecord, "Stored response returned")
+}
+
+func TestDBStorage_SaveIdempotencyResponse(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE idempotency_keys SET status_code=$1, content_type=$2, body=$3, expires_at=now() + $4 * interval '1 millisecond' WHERE user_id=$5 AND key=$6")).
+		WithArgs(200, "application/json", []byte("{}"), int64(86400000), "test", "key").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	record := models.IdempotencyRecord{Key: "key", UserID: "test", StatusCode: 200, ContentType: "application/json", Body: []byte("{}")}
+	assert.NoError(t, ds.SaveIdempotencyResponse(context.Background(), record, 24*time.Hour), "Response saved without error")
+}
+
+func TestDBStorage_DeleteExpiredIdempotencyKeys(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE expires_at <= now()")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	deleted, err := ds.DeleteExpiredIdempotencyKeys(context.Background())
+	assert.NoError(t, err, "Expired keys deleted without error")
+	assert.Equal(t, int64(3), deleted)
+}
+
+func TestDBStorage_CreateSession(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO sessions(user_id, refresh_token_hash, expires_at) VALUES ($1, $2, $3) RETURNING id")).
+		WithArgs("test", "hash", expiresAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("session"))
+
+	session, err := ds.CreateSession(context.Background(), models.Session{UserID: "test", RefreshTokenHash: "hash", ExpiresAt: expiresAt})
+	assert.NoError(t, err, "Session created without error")
+	assert.Equal(t, "session", session.ID, "Session ID returned")
+}
+
+func TestDBStorage_RotateSession(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	query := regexp.QuoteMeta("UPDATE sessions SET refresh_token_hash=$1, expires_at=$2 WHERE refresh_token_hash=$3 AND revoked_at IS NULL AND expires_at > now() RETURNING id, user_id")
+	mock.ExpectQuery(query).
+		WithArgs("new", expiresAt, "old").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow("session", "test"))
+	mock.ExpectQuery(query).
+		WithArgs("newer", expiresAt, "old").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}))
+
+	session, err := ds.RotateSession(context.Background(), "old", "new", expiresAt)
+	assert.NoError(t, err, "Session rotated without error")
+	assert.Equal(t, models.Session{ID: "session", UserID: "test", RefreshTokenHash: "new", ExpiresAt: expiresAt}, session)
+
+	_, err = ds.RotateSession(context.Background(), "old", "newer", expiresAt)
+	assert.ErrorIs(t, err, ErrNotFound, "Rotated token can not be reused")
+}
+
+func TestDBStorage_RevokeSessions(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL")).
+		WithArgs("session").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL")).
+		WithArgs("test").WillReturnResult(sqlmock.NewResult(0, 2))
+
+	assert.NoError(t, ds.RevokeSession(context.Background(), "session"), "Session revoked without error")
+	assert.NoError(t, ds.RevokeUserSessions(context.Background(), "test"), "User sessions revoked without error")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_IsSessionActive(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM sessions WHERE id=$1 AND revoked_at IS NULL AND expires_at > now())")).
+		WithArgs("session").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	active, err := ds.IsSessionActive(context.Background(), "session")
+	assert.NoError(t, err, "Session checked without error")
+	assert.False(t, active, "Revoked session is not active")
+}
+
+func TestDBStorage_AttemptLogin(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	lockedUntil := time.Now().Add(time.Minute)
+	policy := models.LoginPolicy{MaxFailures: 3, Lockout: time.Minute, Window: time.Hour, Delay: time.Second, MaxDelay: 30 * time.Second}
+	attemptQuery := regexp.QuoteMeta(`WITH attempt AS (`)
+
+	mock.ExpectQuery(attemptQuery).
+		WithArgs(models.LoginScopeLogin, "test", 3, int64(60000), int64(3600000), int64(1000), int64(30000)).
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "locked_until"}).AddRow(3, lockedUntil))
+
+	mock.ExpectQuery(attemptQuery).
+		WithArgs(models.LoginScopeLogin, "test", 3, int64(60000), int64(3600000), int64(1000), int64(30000)).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT failures, locked_until, EXTRACT(EPOCH FROM GREATEST(locked_until,`)).
+		WithArgs(models.LoginScopeLogin, "test", int64(1000), int64(30000)).
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "locked_until", "retry_after"}).AddRow(3, lockedUntil, 59.5))
+
+	attempt, err := ds.AttemptLogin(context.Background(), models.LoginScopeLogin, "test", policy)
+	assert.NoError(t, err, "Attempt recorded without error")
+	assert.True(t, attempt.Allowed, "Attempt reaching max failures is allowed")
+	assert.Equal(t, lockedUntil, attempt.LockedUntil, "Locked on max failures")
+
+	attempt, err = ds.AttemptLogin(context.Background(), models.LoginScopeLogin, "test", policy)
+	assert.NoError(t, err, "Rejected attempt without error")
+	assert.False(t, attempt.Allowed, "Locked login is rejected")
+	assert.Equal(t, 59500*time.Millisecond, attempt.RetryAfter)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_CheckLogin(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	policy := models.LoginPolicy{Window: time.Hour, Delay: time.Second, MaxDelay: 30 * time.Second}
+	checkQuery := regexp.QuoteMeta(`SELECT failures, locked_until, EXTRACT(EPOCH FROM GREATEST(locked_until,`)
+
+	mock.ExpectQuery(checkQuery).
+		WithArgs(models.LoginScopeIP, "192.0.2.1", int64(1000), int64(30000), int64(3600000)).
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "locked_until", "retry_after"}).AddRow(2, nil, 1.5))
+	mock.ExpectQuery(checkQuery).
+		WithArgs(models.LoginScopeIP, "192.0.2.1", int64(1000), int64(30000), int64(3600000)).
+		WillReturnRows(sqlmock.NewRows([]string{"failures", "locked_until", "retry_after"}).AddRow(2, nil, nil))
+	mock.ExpectQuery(checkQuery).
+		WithArgs(models.LoginScopeIP, "198.51.100.1", int64(1000), int64(30000), int64(3600000)).
+		WillReturnError(sql.ErrNoRows)
+
+	attempt, err := ds.CheckLogin(context.Background(), models.LoginScopeIP, "192.0.2.1", policy)
+	assert.NoError(t, err)
+	assert.False(t, attempt.Allowed, "Delay after failure is not over")
+	assert.Equal(t, 1500*time.Millisecond, attempt.RetryAfter)
+
+	attempt, err = ds.CheckLogin(context.Background(), models.LoginScopeIP, "192.0.2.1", policy)
+	assert.NoError(t, err)
+	assert.Equal(t, models.LoginAttempt{Allowed: true, Failures: 2}, attempt, "Delay is over")
+
+	attempt, err = ds.CheckLogin(context.Background(), models.LoginScopeIP, "198.51.100.1", policy)
+	assert.NoError(t, err)
+	assert.True(t, attempt.Allowed, "Unknown key is allowed")
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestDBStorage_GetUsersWithdraw(t *testing.T) {
+func TestDBStorage_UnlockLogin(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT SUM(sum) FROM withdrawals WHERE user_id=$1")).
+	deleteQuery := regexp.QuoteMeta(`DELETE FROM login_throttles WHERE scope=$1 AND key=$2`)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteQuery).WithArgs(models.LoginScopeLogin, "test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE login_lockouts SET unlocked_at=now(), unlocked_by=$1 WHERE scope=$2 AND key=$3 AND unlocked_at IS NULL AND locked_until > now()`)).
+		WithArgs("admin", models.LoginScopeLogin, "test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteQuery).WithArgs(models.LoginScopeIP, "192.0.2.1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	assert.NoError(t, ds.UnlockLogin(context.Background(), models.LoginScopeLogin, "test", "admin"), "Login unlocked without error")
+	assert.ErrorIs(t, ds.UnlockLogin(context.Background(), models.LoginScopeIP, "192.0.2.1", "admin"), ErrNotFound, "Nothing to unlock")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_GetUserEvents(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	timestamp := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, event_type, payload, created_at FROM user_events WHERE user_id=$1 AND id > $2 ORDER BY id LIMIT $3")).
+		WithArgs("test", 5, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "created_at"}).
+			AddRow(6, models.UserEventBalance, []byte(`{"current":500.5,"withdrawn":0}`), timestamp))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(id), 0) FROM user_events WHERE user_id=$1")).
 		WithArgs("test").
-		WillReturnRows(sqlmock.NewRows([]string{"sum_sum"}).
-			AddRow(12.3))
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(6))
+
+	events, err := ds.GetUserEvents(context.Background(), "test", 5, 100)
+	assert.NoError(t, err, "Events loaded without error")
+	assert.Equal(t, []models.UserEvent{{ID: 6, UserID: "test", Type: models.UserEventBalance, Payload: []byte(`{"current":500.5,"withdrawn":0}`), CreatedAt: timestamp}}, events)
+
+	lastID, err := ds.GetLastUserEventID(context.Background(), "test")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), lastID)
+}
+
+func TestDBStorage_DeleteExpiredUserEvents(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_events WHERE created_at < now() - $1 * interval '1 millisecond'")).
+		WithArgs(int64(86400000)).WillReturnResult(sqlmock.NewResult(0, 3))
 
-	withdraw, err := ds.GetUsersWithdraw(context.WithValue(context.Background(), auth.ContextUserKey, "test"))
-	assert.NoError(t, err, "NO error on withdraw")
-	assert.Equal(t, withdraw, 12.3, "Withdraws equal")
+	deleted, err := ds.DeleteExpiredUserEvents(context.Background(), 24*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestDBStorage_GetAllOrdersIDs(t *testing.T) {
+// arrayConverter пропускает []string как есть, как это делает драйвер pgx.
+type arrayConverter struct{}
+
+func (arrayConverter) ConvertValue(v interface{}) (driver.Value, error) {
+	if array, ok := v.([]string); ok {
+		return array, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestDBStorage_WebhookEndpoints(t *testing.T) {
+	db, mock, _ := sqlmock.New(sqlmock.ValueConverterOption(arrayConverter{}))
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	timestamp := time.Now()
+	events := []string{models.WebhookEventOrderProcessed}
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO webhook_endpoints(user_id, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING id, created_at")).
+		WithArgs(nil, "https://partner.example/hook", "secret", events).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow("endpoint", timestamp))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM webhook_endpoints WHERE id=$1 AND user_id IS NOT DISTINCT FROM $2")).
+		WithArgs("endpoint", "test").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	endpoint, err := ds.CreateWebhookEndpoint(context.Background(), models.WebhookEndpoint{URL: "https://partner.example/hook", Secret: "secret", Events: events})
+	assert.NoError(t, err, "Admin endpoint created without owner")
+	assert.Equal(t, "endpoint", endpoint.ID)
+	assert.Equal(t, timestamp, endpoint.CreatedAt)
+
+	err = ds.DeleteWebhookEndpoint(context.Background(), "test", "endpoint")
+	assert.ErrorIs(t, err, ErrNotFound, "Endpoint of another owner is not deleted")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBStorage_GetWebhookDeliveries(t *testing.T) {
 	db, mock, _ := sqlmock.New()
 	ds := &DBStorage{
-		db: db,
+		db: newSQLHandle(db, 0),
 	}
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT number FROM orders WHERE status in ('NEW', 'PROCESSING')")).
-		WillReturnRows(sqlmock.NewRows([]string{"number"}).
-			AddRow("test1").AddRow("test2").AddRow("test3"))
+	timestamp := time.Now()
+	exists := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM webhook_endpoints WHERE id=$1 AND user_id IS NOT DISTINCT FROM $2)")
+	mock.ExpectQuery(exists).
+		WithArgs("endpoint", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM webhook_deliveries WHERE endpoint_id=$1 ORDER BY created_at DESC LIMIT $2")).
+		WithArgs("endpoint", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "payload", "status", "attempts", "last_status_code", "last_error", "next_attempt_at", "created_at", "delivered_at"}).
+			AddRow("delivery", models.WebhookEventOrderInvalid, []byte(`{}`), models.WebhookDeliveryDead, 10, 500, "endpoint answered 500", timestamp, timestamp, nil))
+	mock.ExpectQuery(exists).
+		WithArgs("other", "test").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	deliveries, err := ds.GetWebhookDeliveries(context.Background(), "test", "endpoint", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.WebhookDelivery{{ID: "delivery", EndpointID: "endpoint", EventType: models.WebhookEventOrderInvalid, Payload: []byte(`{}`),
+		Status: models.WebhookDeliveryDead, Attempts: 10, LastStatusCode: 500, LastError: "endpoint answered 500", NextAttemptAt: timestamp, CreatedAt: timestamp}}, deliveries)
+
+	_, err = ds.GetWebhookDeliveries(context.Background(), "test", "other", 10)
+	assert.ErrorIs(t, err, ErrNotFound, "Deliveries of another owner are hidden")
+}
+
+func TestDBStorage_WebhookDeliveryQueue(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	timestamp := time.Now()
+	mock.ExpectQuery(regexp.QuoteMeta("FOR UPDATE SKIP LOCKED")).
+		WithArgs(int64(60000), 8).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "endpoint_id", "url", "secret", "event_type", "payload", "attempts", "created_at"}).
+			AddRow("delivery", "endpoint", "https://partner.example/hook", "secret", models.WebhookEventWithdrawalCreated, []byte(`{}`), 2, timestamp))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE webhook_deliveries SET next_attempt_at = now() + $1 * interval '1 millisecond', locked_until=NULL, last_status_code=$2, last_error=$3 WHERE id=$4")).
+		WithArgs(int64(20000), nil, "connection refused", "delivery").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE webhook_deliveries SET status='DEAD', locked_until=NULL, last_status_code=$1, last_error=$2 WHERE id=$3")).
+		WithArgs(500, "endpoint answered 500", "delivery").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE webhook_deliveries SET status='DELIVERED', delivered_at=now(), locked_until=NULL, last_status_code=$1, last_error=NULL WHERE id=$2")).
+		WithArgs(200, "delivery").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	deliveries, err := ds.ClaimWebhookDeliveries(context.Background(), 8, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.WebhookDelivery{{ID: "delivery", EndpointID: "endpoint", URL: "https://partner.example/hook", Secret: "secret",
+		EventType: models.WebhookEventWithdrawalCreated, Payload: []byte(`{}`), Status: models.WebhookDeliveryPending, Attempts: 2, CreatedAt: timestamp}}, deliveries)
+
+	assert.NoError(t, ds.RetryWebhookDelivery(context.Background(), "delivery", 0, "connection refused", 20*time.Second))
+	assert.NoError(t, ds.DeadLetterWebhookDelivery(context.Background(), "delivery", 500, "endpoint answered 500"))
+	assert.NoError(t, ds.CompleteWebhookDelivery(context.Background(), "delivery", 200))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLatestMigrationVersion(t *testing.T) {
+	entries, err := db.MigrationsFS.ReadDir("migrations")
+	require.NoError(t, err)
+
+	version, err := LatestMigrationVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(len(entries)/2), version, "Every migration has up and down files")
+}
+
+func TestDBStorage_MigrationVersion(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	ds := &DBStorage{
+		db: newSQLHandle(db, 0),
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM schema_migrations LIMIT 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(12, true))
+
+	version, dirty, err := ds.MigrationVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint(12), version)
+	assert.True(t, dirty)
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	assert.NoError(t, checkSchemaVersion(13, false, 13))
+	assert.NoError(t, checkSchemaVersion(14, false, 13), "Schema migrated by a newer release is accepted")
 
-	orderIDs, err := ds.GetAllOrdersIDs(context.WithValue(context.Background(), auth.ContextUserKey, "test"))
-	assert.NoError(t, err, "NO error on orders IDs list")
-	assert.Equal(t, orderIDs, []string{"test1", "test2", "test3"}, "Order IDs lists equal")
+	err := checkSchemaVersion(12, false, 13)
+	assert.ErrorIs(t, err, ErrSchemaBehind)
+	assert.EqualError(t, err, "database schema is behind: version 12, expected 13, run migrate up")
+	assert.ErrorIs(t, checkSchemaVersion(0, false, 13), ErrSchemaBehind, "Empty schema is behind")
+	assert.ErrorIs(t, checkSchemaVersion(13, true, 13), ErrSchemaDirty)
 }
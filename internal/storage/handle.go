@@ -0,0 +1,441 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"go.uber.org/zap"
+)
+
+const (
+	connectBackoff    = 100 * time.Millisecond
+	connectMaxBackoff = 5 * time.Second
+	// unlimitedConnLifetime заменяет ноль для pgxpool: у него нулевой срок означает немедленное закрытие
+	unlimitedConnLifetime = 100 * 365 * 24 * time.Hour
+)
+
+// dbHandle — общее подмножество database/sql и pgxpool, через которое DBStorage выполняет запросы.
+// Каждый запрос ограничен database.query_timeout.
+type dbHandle interface {
+	dbQueryer
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (dbTx, error)
+	PingContext(ctx context.Context) error
+	Close() error
+
+	// withConn отдаёт соединение pgx в монопольное пользование; после fn оно закрывается, а не возвращается в пул.
+	withConn(ctx context.Context, fn func(conn *pgx.Conn) error) error
+	// arrayScanner возвращает обёртку приёмника text[]: database/sql отдаёт массив текстом, pgx — в двоичном формате.
+	arrayScanner() func(dst *[]string) interface{}
+	// stdDB нужен golang-migrate и сбору статистики database/sql.
+	stdDB() *sql.DB
+}
+
+type dbQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (dbRows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) dbRow
+}
+
+type dbTx interface {
+	dbQueryer
+	Commit() error
+	Rollback() error
+}
+
+type dbRows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
+}
+
+type dbRow interface {
+	Scan(dest ...interface{}) error
+}
+
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// configureConn применяет к соединению pgx общие для обоих драйверов настройки.
+func configureConn(connConfig *pgx.ConnConfig, options config.DatabaseOptions) {
+	connConfig.Tracer = tracing.QueryTracer{}
+	connConfig.StatementCacheCapacity = options.StatementCache
+	if options.StatementCache == 0 {
+		// Без кеша подготовленных выражений, например за pgbouncer в режиме транзакций
+		connConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheDescribe
+	}
+}
+
+func openHandle(ctx context.Context, options config.DatabaseOptions) (dbHandle, error) {
+	if options.Driver == config.DatabaseDriverPgxPool {
+		return openPgxHandle(ctx, options)
+	}
+	return openSQLHandle(options)
+}
+
+// connect ждёт доступности базы не дольше timeout, повторяя попытки с экспоненциальной паузой.
+func connect(ctx context.Context, ping func(ctx context.Context) error, timeout time.Duration) error {
+	if timeout <= 0 {
+		return ping(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := connectBackoff
+	for {
+		err := ping(ctx)
+		if err == nil {
+			return nil
+		}
+		logger.Log().Warn("Database is not available", zap.Duration("retry_in", backoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database is not available after %s: %w", timeout, err)
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, connectMaxBackoff)
+	}
+}
+
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type sqlQueryer struct {
+	q            sqlQuerier
+	queryTimeout time.Duration
+}
+
+func (q sqlQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx, q.queryTimeout)
+	defer cancel()
+	return q.q.ExecContext(ctx, query, args...)
+}
+
+func (q sqlQueryer) QueryContext(ctx context.Context, query string, args ...interface{}) (dbRows, error) {
+	ctx, cancel := withQueryTimeout(ctx, q.queryTimeout)
+	rows, err := q.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return sqlRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (q sqlQueryer) QueryRowContext(ctx context.Context, query string, args ...interface{}) dbRow {
+	ctx, cancel := withQueryTimeout(ctx, q.queryTimeout)
+	return sqlRow{row: q.q.QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+type sqlRows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r sqlRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+type sqlRow struct {
+	row    *sql.Row
+	cancel context.CancelFunc
+}
+
+func (r sqlRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.row.Scan(dest...)
+}
+
+// sqlHandle выполняет запросы через database/sql с драйвером pgx stdlib.
+type sqlHandle struct {
+	sqlQueryer
+	db *sql.DB
+}
+
+func newSQLHandle(db *sql.DB, queryTimeout time.Duration) sqlHandle {
+	return sqlHandle{sqlQueryer: sqlQueryer{q: db, queryTimeout: queryTimeout}, db: db}
+}
+
+func openSQLHandle(options config.DatabaseOptions) (dbHandle, error) {
+	connConfig, err := pgx.ParseConfig(options.URI)
+	if err != nil {
+		return nil, err
+	}
+	configureConn(connConfig, options)
+
+	db := stdlib.OpenDB(*connConfig)
+	db.SetMaxOpenConns(options.MaxOpenConns)
+	db.SetMaxIdleConns(options.MaxIdleConns)
+	db.SetConnMaxLifetime(options.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(options.ConnMaxIdleTime)
+	return newSQLHandle(db, options.QueryTimeout), nil
+}
+
+func (h sqlHandle) BeginTx(ctx context.Context, opts *sql.TxOptions) (dbTx, error) {
+	tx, err := h.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{sqlQueryer: sqlQueryer{q: tx, queryTimeout: h.queryTimeout}, tx: tx}, nil
+}
+
+func (h sqlHandle) PingContext(ctx context.Context) error {
+	return h.db.PingContext(ctx)
+}
+
+func (h sqlHandle) Close() error {
+	return h.db.Close()
+}
+
+func (h sqlHandle) withConn(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var fnErr error
+	_ = conn.Raw(func(driverConn interface{}) error {
+		fnErr = fn(driverConn.(*stdlib.Conn).Conn())
+		return driver.ErrBadConn
+	})
+	return fnErr
+}
+
+func (h sqlHandle) arrayScanner() func(dst *[]string) interface{} {
+	typeMap := pgtype.NewMap()
+	return func(dst *[]string) interface{} {
+		return typeMap.SQLScanner(dst)
+	}
+}
+
+func (h sqlHandle) stdDB() *sql.DB {
+	return h.db
+}
+
+type sqlTx struct {
+	sqlQueryer
+	tx *sql.Tx
+}
+
+func (t sqlTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t sqlTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+type pgxQuerier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+type pgxQueryer struct {
+	q            pgxQuerier
+	queryTimeout time.Duration
+}
+
+func (q pgxQueryer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx, q.queryTimeout)
+	defer cancel()
+	tag, err := q.q.Exec(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgxResult{tag: tag}, nil
+}
+
+func (q pgxQueryer) QueryContext(ctx context.Context, query string, args ...interface{}) (dbRows, error) {
+	ctx, cancel := withQueryTimeout(ctx, q.queryTimeout)
+	rows, err := q.q.Query(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return pgxRows{rows: rows, cancel: cancel}, nil
+}
+
+func (q pgxQueryer) QueryRowContext(ctx context.Context, query string, args ...interface{}) dbRow {
+	ctx, cancel := withQueryTimeout(ctx, q.queryTimeout)
+	return pgxRow{row: q.q.QueryRow(ctx, query, args...), cancel: cancel}
+}
+
+type pgxResult struct {
+	tag pgconn.CommandTag
+}
+
+func (r pgxResult) LastInsertId() (int64, error) {
+	return 0, errors.New("LastInsertId is not supported by PostgreSQL")
+}
+
+func (r pgxResult) RowsAffected() (int64, error) {
+	return r.tag.RowsAffected(), nil
+}
+
+type pgxRows struct {
+	rows   pgx.Rows
+	cancel context.CancelFunc
+}
+
+func (r pgxRows) Next() bool {
+	return r.rows.Next()
+}
+
+func (r pgxRows) Scan(dest ...interface{}) error {
+	return r.rows.Scan(dest...)
+}
+
+func (r pgxRows) Err() error {
+	return r.rows.Err()
+}
+
+func (r pgxRows) Close() error {
+	defer r.cancel()
+	r.rows.Close()
+	return nil
+}
+
+type pgxRow struct {
+	row    pgx.Row
+	cancel context.CancelFunc
+}
+
+// Scan возвращает sql.ErrNoRows, как database/sql, чтобы проверки в запросах не зависели от драйвера.
+func (r pgxRow) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	err := r.row.Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// pgxHandle выполняет запросы напрямую через pgxpool, минуя database/sql.
+type pgxHandle struct {
+	pgxQueryer
+	pool *pgxpool.Pool
+	db   *sql.DB
+}
+
+func openPgxHandle(ctx context.Context, options config.DatabaseOptions) (dbHandle, error) {
+	poolConfig, err := pgxpool.ParseConfig(options.URI)
+	if err != nil {
+		return nil, err
+	}
+	configureConn(poolConfig.ConnConfig, options)
+	poolConfig.MaxConns = int32(options.MaxOpenConns)
+	poolConfig.MinConns = int32(options.MinConns)
+	poolConfig.MaxConnLifetime = options.ConnMaxLifetime
+	if poolConfig.MaxConnLifetime == 0 {
+		poolConfig.MaxConnLifetime = unlimitedConnLifetime
+	}
+	poolConfig.MaxConnIdleTime = options.ConnMaxIdleTime
+	if poolConfig.MaxConnIdleTime == 0 {
+		poolConfig.MaxConnIdleTime = unlimitedConnLifetime
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	return pgxHandle{
+		pgxQueryer: pgxQueryer{q: pool, queryTimeout: options.QueryTimeout},
+		pool:       pool,
+		db:         stdlib.OpenDBFromPool(pool),
+	}, nil
+}
+
+func (h pgxHandle) BeginTx(ctx context.Context, opts *sql.TxOptions) (dbTx, error) {
+	var txOptions pgx.TxOptions
+	if opts != nil {
+		switch opts.Isolation {
+		case sql.LevelDefault:
+		case sql.LevelReadUncommitted:
+			txOptions.IsoLevel = pgx.ReadUncommitted
+		case sql.LevelReadCommitted:
+			txOptions.IsoLevel = pgx.ReadCommitted
+		case sql.LevelRepeatableRead, sql.LevelSnapshot:
+			txOptions.IsoLevel = pgx.RepeatableRead
+		case sql.LevelSerializable:
+			txOptions.IsoLevel = pgx.Serializable
+		default:
+			return nil, fmt.Errorf("unsupported isolation level %s", opts.Isolation)
+		}
+		if opts.ReadOnly {
+			txOptions.AccessMode = pgx.ReadOnly
+		}
+	}
+
+	tx, err := h.pool.BeginTx(ctx, txOptions)
+	if err != nil {
+		return nil, err
+	}
+	return pgxTx{pgxQueryer: pgxQueryer{q: tx, queryTimeout: h.queryTimeout}, tx: tx, ctx: ctx}, nil
+}
+
+func (h pgxHandle) PingContext(ctx context.Context) error {
+	return h.pool.Ping(ctx)
+}
+
+func (h pgxHandle) Close() error {
+	err := h.db.Close()
+	h.pool.Close()
+	return err
+}
+
+func (h pgxHandle) withConn(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	poolConn, err := h.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := poolConn.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+	return fn(conn)
+}
+
+func (h pgxHandle) arrayScanner() func(dst *[]string) interface{} {
+	return func(dst *[]string) interface{} {
+		return dst
+	}
+}
+
+func (h pgxHandle) stdDB() *sql.DB {
+	return h.db
+}
+
+type pgxTx struct {
+	pgxQueryer
+	tx  pgx.Tx
+	ctx context.Context
+}
+
+func (t pgxTx) Commit() error {
+	return t.tx.Commit(t.ctx)
+}
+
+// Rollback выполняется и после отмены ctx транзакции, иначе соединение закрылось бы вместо отката.
+func (t pgxTx) Rollback() error {
+	return t.tx.Rollback(context.WithoutCancel(t.ctx))
+}
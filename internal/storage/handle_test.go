@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnect(t *testing.T) {
+	attempts := 0
+	err := connect(context.Background(), func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts, "Connect retries until database answers")
+
+	err = connect(context.Background(), func(context.Context) error { return errors.New("connection refused") }, 250*time.Millisecond)
+	assert.EqualError(t, err, "database is not available after 250ms: connection refused")
+
+	attempts = 0
+	err = connect(context.Background(), func(context.Context) error {
+		attempts++
+		return errors.New("connection refused")
+	}, 0)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts, "Zero timeout means single attempt")
+}
+
+func TestSQLHandle_QueryTimeout(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	handle := newSQLHandle(db, 20*time.Millisecond)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT 1")).WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+	var one int
+	err := handle.QueryRowContext(context.Background(), "SELECT 1").Scan(&one)
+	assert.Error(t, err, "Slow query is canceled by query timeout")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions")).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+	tx, err := handle.BeginTx(context.Background(), nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(context.Background(), "DELETE FROM sessions")
+	assert.Error(t, err, "Query timeout applies to every statement of transaction")
+}
+
+type rowStub struct {
+	err error
+}
+
+func (r rowStub) Scan(...interface{}) error {
+	return r.err
+}
+
+func TestPgxRow_Scan(t *testing.T) {
+	cancelled := false
+	row := pgxRow{row: rowStub{err: pgx.ErrNoRows}, cancel: func() { cancelled = true }}
+
+	err := row.Scan()
+	assert.ErrorIs(t, err, sql.ErrNoRows, "pgx.ErrNoRows is reported as database/sql one")
+	assert.True(t, cancelled, "Query context is released after Scan")
+}
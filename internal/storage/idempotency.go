@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+)
+
+// ReserveIdempotencyKey резервирует ключ за пользователем на ttl до получения ответа; истёкший ключ занимается заново.
+// Если ключ уже был использован, возвращает сохранённую запись и ErrAlreadyExists.
+func (ds *DBStorage) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) (storedRecord models.IdempotencyRecord, err error) {
+	result, err := ds.db.ExecContext(ctx,
+		`INSERT INTO idempotency_keys(key, user_id, request_hash, expires_at) VALUES ($1, $2, $3, now() + $4 * interval '1 millisecond')
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_hash=EXCLUDED.request_hash, status_code=NULL, content_type=NULL, body=NULL, created_at=now(), expires_at=EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= now()`,
+		record.Key, record.UserID, record.RequestHash, ttl.Milliseconds())
+	if err != nil {
+		return
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return
+	}
+	if inserted == 1 {
+		return record, nil
+	}
+
+	row := ds.db.QueryRowContext(ctx,
+		`SELECT request_hash, status_code, content_type, body FROM idempotency_keys WHERE user_id=$1 AND key=$2`,
+		record.UserID, record.Key)
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	storedRecord = models.IdempotencyRecord{Key: record.Key, UserID: record.UserID}
+
+	err = row.Scan(&storedRecord.RequestHash, &statusCode, &contentType, &storedRecord.Body)
+	if err != nil {
+		return
+	}
+
+	storedRecord.StatusCode = int(statusCode.Int64)
+	storedRecord.ContentType = contentType.String
+	err = ErrAlreadyExists
+	return
+}
+
+// SaveIdempotencyResponse сохраняет ответ и продлевает жизнь ключа на ttl.
+func (ds *DBStorage) SaveIdempotencyResponse(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET status_code=$1, content_type=$2, body=$3, expires_at=now() + $4 * interval '1 millisecond' WHERE user_id=$5 AND key=$6`,
+		record.StatusCode, record.ContentType, record.Body, ttl.Milliseconds(), record.UserID, record.Key)
+	return err
+}
+
+func (ds *DBStorage) DeleteIdempotencyKey(ctx context.Context, userID string, key string) error {
+	_, err := ds.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id=$1 AND key=$2`, userID, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys удаляет истёкшие ключи и возвращает их количество.
+func (ds *DBStorage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := ds.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/models"
+)
+
+const (
+	LedgerEntryAccrual    = "ACCRUAL"
+	LedgerEntryWithdrawal = "WITHDRAWAL"
+)
+
+// postLedgerEntry записывает проводку в ledger_entries и применяет её к материализованному балансу пользователя.
+// Положительная сумма — начисление, отрицательная — списание. Повторное начисление по одному заказу игнорируется.
+func postLedgerEntry(ctx context.Context, tx dbTx, userID string, orderNumber string, entryType string, amount models.Money) error {
+	var result sql.Result
+	var err error
+	if entryType == LedgerEntryAccrual {
+		result, err = tx.ExecContext(ctx,
+			`INSERT INTO ledger_entries(user_id, order_number, entry_type, amount) VALUES ($1, $2, $3, $4) ON CONFLICT (order_number) WHERE entry_type = 'ACCRUAL' DO NOTHING`,
+			userID, orderNumber, entryType, amount)
+	} else {
+		result, err = tx.ExecContext(ctx,
+			`INSERT INTO ledger_entries(user_id, order_number, entry_type, amount) VALUES ($1, $2, $3, $4)`,
+			userID, orderNumber, entryType, amount)
+	}
+	if err != nil {
+		return err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil || inserted == 0 {
+		return err
+	}
+
+	var withdrawn models.Money
+	if amount < 0 {
+		withdrawn = -amount
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO balances(user_id, current, withdrawn) VALUES ($1, $2, $3) ON CONFLICT (user_id) DO UPDATE SET current = balances.current + EXCLUDED.current, withdrawn = balances.withdrawn + EXCLUDED.withdrawn`,
+		userID, amount, withdrawn)
+	return err
+}
+
+func (ds *DBStorage) GetBalance(ctx context.Context) (balance models.Balance, err error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+	row := ds.db.QueryRowContext(ctx, `SELECT current, withdrawn FROM balances WHERE user_id=$1`, userID)
+
+	err = row.Scan(&balance.Current, &balance.Withdrawn)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	return
+}
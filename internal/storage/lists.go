@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+)
+
+// listQuery собирает keyset-выборку страницы: фильтры, условие "после курсора" и сортировку по (timeColumn, keyColumn).
+type listQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (lq *listQuery) where(condition string, args ...interface{}) {
+	placeholders := make([]interface{}, len(args))
+	for i, arg := range args {
+		lq.args = append(lq.args, arg)
+		placeholders[i] = fmt.Sprintf("$%d", len(lq.args))
+	}
+	lq.conditions = append(lq.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+func buildListQuery(selectFrom string, timeColumn string, keyColumn string, userID string, query pagination.Query) (string, []interface{}) {
+	lq := &listQuery{}
+	lq.where("user_id=%s", userID)
+
+	if len(query.Statuses) > 0 {
+		placeholders := make([]string, len(query.Statuses))
+		statuses := make([]interface{}, len(query.Statuses))
+		for i, status := range query.Statuses {
+			placeholders[i] = "%s"
+			statuses[i] = status
+		}
+		lq.where("status::text IN ("+strings.Join(placeholders, ", ")+")", statuses...)
+	}
+	if !query.From.IsZero() {
+		lq.where(timeColumn+" >= %s", query.From)
+	}
+	if !query.To.IsZero() {
+		lq.where(timeColumn+" < %s", query.To)
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if query.Cursor != nil {
+		lq.where(fmt.Sprintf("(%s, %s) %s (%%s, %%s)", timeColumn, keyColumn, comparison), query.Cursor.Time, query.Cursor.Key)
+	}
+
+	sql := fmt.Sprintf("%s WHERE %s ORDER BY %s %s, %s %s",
+		selectFrom, strings.Join(lq.conditions, " AND "), timeColumn, direction, keyColumn, direction)
+	if query.Limit > 0 {
+		// Лишняя запись показывает, есть ли следующая страница
+		lq.args = append(lq.args, query.Limit+1)
+		sql += fmt.Sprintf(" LIMIT $%d", len(lq.args))
+	}
+	return sql, lq.args
+}
+
+// ListUsersOrders возвращает страницу заказов пользователя и курсор следующей страницы, если она есть.
+// Нулевой query.Limit возвращает все заказы.
+func (ds *DBStorage) ListUsersOrders(ctx context.Context, query pagination.Query) (orders []models.Order, next *pagination.Cursor, err error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+
+	sql, args := buildListQuery(`SELECT number, status, accrual, uploaded_at FROM orders`, "uploaded_at", "number", userID, query)
+	rows, err := ds.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	orders = make([]models.Order, 0, query.Limit)
+	for rows.Next() {
+		var order models.Order
+		err = rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		orders = append(orders, order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if query.Limit > 0 && len(orders) > query.Limit {
+		orders = orders[:query.Limit]
+		last := orders[len(orders)-1]
+		next = &pagination.Cursor{Time: last.UploadedAt, Key: last.Number, Descending: query.Descending}
+	}
+	return
+}
+
+// ListUsersWithdrawals возвращает страницу списаний пользователя и курсор следующей страницы, если она есть.
+// Нулевой query.Limit возвращает все списания.
+func (ds *DBStorage) ListUsersWithdrawals(ctx context.Context, query pagination.Query) (withdrawals []models.Withdrawal, next *pagination.Cursor, err error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+
+	sql, args := buildListQuery(`SELECT id, number, sum, processed_at FROM withdrawals`, "processed_at", "id", userID, query)
+	rows, err := ds.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	withdrawals = make([]models.Withdrawal, 0, query.Limit)
+	var ids []string
+	for rows.Next() {
+		var id string
+		var withdrawal models.Withdrawal
+		err = rows.Scan(&id, &withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.ProcessedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if query.Limit > 0 && len(withdrawals) > query.Limit {
+		withdrawals = withdrawals[:query.Limit]
+		next = &pagination.Cursor{Time: withdrawals[query.Limit-1].ProcessedAt, Key: ids[query.Limit-1], Descending: query.Descending}
+	}
+	return
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+)
+
+// loginDelaySQL повторяет models.LoginPolicy.DelayAfter для счётчика failures в миллисекундах.
+func loginDelaySQL(failures string, delay string, maxDelay string) string {
+	return fmt.Sprintf(`LEAST(%[2]s * power(2, LEAST(%[1]s - 1, 30)), CASE WHEN %[3]s > 0 THEN %[3]s ELSE %[2]s END)`, failures, delay, maxDelay)
+}
+
+// CheckLogin проверяет, разрешена ли попытка входа, ничего не учитывая: ключ не заблокирован и пауза после
+// последней неудачи в пределах окна прошла.
+func (ds *DBStorage) CheckLogin(ctx context.Context, scope string, key string, policy models.LoginPolicy) (attempt models.LoginAttempt, err error) {
+	var lockedUntil sql.NullTime
+	var retryAfter sql.NullFloat64
+	row := ds.db.QueryRowContext(ctx,
+		`SELECT failures, locked_until, EXTRACT(EPOCH FROM GREATEST(locked_until,
+			CASE WHEN failures > 0 AND last_failure_at > now() - $5 * interval '1 millisecond'
+				THEN last_failure_at + `+loginDelaySQL("failures", "$3", "$4")+` * interval '1 millisecond' END) - now())::float8
+		FROM login_throttles WHERE scope=$1 AND key=$2`,
+		scope, key, policy.Delay.Milliseconds(), policy.MaxDelay.Milliseconds(), policy.Window.Milliseconds())
+	err = row.Scan(&attempt.Failures, &lockedUntil, &retryAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.LoginAttempt{Allowed: true}, nil
+	}
+	if err != nil {
+		return
+	}
+	if lockedUntil.Time.After(time.Now()) {
+		attempt.LockedUntil = lockedUntil.Time
+	}
+	attempt.RetryAfter = time.Duration(retryAfter.Float64 * float64(time.Second))
+	attempt.Allowed = attempt.RetryAfter <= 0
+	if attempt.Allowed {
+		attempt.RetryAfter = 0
+	}
+	return
+}
+
+// AttemptLogin учитывает попытку входа одним INSERT ... ON CONFLICT ... RETURNING, поэтому параллельные попытки
+// получают разные номера и не проходят проверку вместе. Попытка, на которой счётчик достигает MaxFailures,
+// ещё разрешается, но блокирует ключ и записывается в журнал login_lockouts. Время берётся только из базы.
+func (ds *DBStorage) AttemptLogin(ctx context.Context, scope string, key string, policy models.LoginPolicy) (attempt models.LoginAttempt, err error) {
+	var lockedUntil sql.NullTime
+	row := ds.db.QueryRowContext(ctx,
+		`WITH attempt AS (
+			INSERT INTO login_throttles AS t (scope, key, failures, last_failure_at, locked_until)
+			VALUES ($1, $2, 1, now(), CASE WHEN $3 = 1 THEN now() + $4 * interval '1 millisecond' END)
+			ON CONFLICT (scope, key) DO UPDATE SET
+				failures = CASE WHEN t.locked_until <= now() OR t.last_failure_at <= now() - $5 * interval '1 millisecond' THEN 1 ELSE t.failures + 1 END,
+				last_failure_at = now(),
+				locked_until = CASE WHEN $3 > 0 AND $3 <= CASE WHEN t.locked_until <= now() OR t.last_failure_at <= now() - $5 * interval '1 millisecond' THEN 1 ELSE t.failures + 1 END
+					THEN now() + $4 * interval '1 millisecond' END
+			WHERE (t.locked_until IS NULL OR t.locked_until <= now())
+				AND (t.failures = 0 OR t.last_failure_at <= now() - $5 * interval '1 millisecond'
+					OR t.last_failure_at + `+loginDelaySQL("t.failures", "$6", "$7")+` * interval '1 millisecond' <= now())
+			RETURNING t.failures, t.locked_until
+		), lockout AS (
+			INSERT INTO login_lockouts(scope, key, failures, locked_until)
+			SELECT $1, $2, failures, locked_until FROM attempt WHERE locked_until IS NOT NULL
+		)
+		SELECT failures, locked_until FROM attempt`,
+		scope, key, policy.MaxFailures, policy.Lockout.Milliseconds(), policy.Window.Milliseconds(),
+		policy.Delay.Milliseconds(), policy.MaxDelay.Milliseconds())
+	err = row.Scan(&attempt.Failures, &lockedUntil)
+	if err == nil {
+		attempt.Allowed = true
+		attempt.LockedUntil = lockedUntil.Time
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+
+	// Попытка отклонена: ключ заблокирован или пауза после прошлой неудачи ещё не прошла
+	var retryAfter float64
+	row = ds.db.QueryRowContext(ctx,
+		`SELECT failures, locked_until, EXTRACT(EPOCH FROM GREATEST(locked_until,
+			last_failure_at + `+loginDelaySQL("failures", "$3", "$4")+` * interval '1 millisecond') - now())::float8
+		FROM login_throttles WHERE scope=$1 AND key=$2`,
+		scope, key, policy.Delay.Milliseconds(), policy.MaxDelay.Milliseconds())
+	err = row.Scan(&attempt.Failures, &lockedUntil, &retryAfter)
+	if err != nil {
+		return
+	}
+	attempt.LockedUntil = lockedUntil.Time
+	attempt.RetryAfter = time.Duration(retryAfter * float64(time.Second))
+	return
+}
+
+// ResetLoginFailures обнуляет счётчик и снимает блокировку после успешного входа.
+func (ds *DBStorage) ResetLoginFailures(ctx context.Context, scope string, key string) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE login_throttles SET failures = 0, locked_until = NULL WHERE scope=$1 AND key=$2 AND (failures > 0 OR locked_until IS NOT NULL)`, scope, key)
+	return err
+}
+
+// UnlockLogin снимает блокировку и сбрасывает счётчик; в журнале фиксируется, кто снял блокировку.
+func (ds *DBStorage) UnlockLogin(ctx context.Context, scope string, key string, unlockedBy string) error {
+	tx, err := ds.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM login_throttles WHERE scope=$1 AND key=$2`, scope, key)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE login_lockouts SET unlocked_at=now(), unlocked_by=$1 WHERE scope=$2 AND key=$3 AND unlocked_at IS NULL AND locked_until > now()`,
+		unlockedBy, scope, key)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *DBStorage) GetLoginLockouts(ctx context.Context, limit int) (lockouts []models.LoginLockout, err error) {
+	rows, err := ds.db.QueryContext(ctx,
+		`SELECT id, scope, key, failures, locked_until, created_at, unlocked_at, unlocked_by FROM login_lockouts ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts = make([]models.LoginLockout, 0)
+	for rows.Next() {
+		var lockout models.LoginLockout
+		var unlockedAt sql.NullTime
+		var unlockedBy sql.NullString
+		err = rows.Scan(&lockout.ID, &lockout.Scope, &lockout.Key, &lockout.Failures, &lockout.LockedUntil, &lockout.CreatedAt, &unlockedAt, &unlockedBy)
+		if err != nil {
+			return nil, err
+		}
+		if unlockedAt.Valid {
+			lockout.UnlockedAt = &unlockedAt.Time
+		}
+		lockout.UnlockedBy = unlockedBy.String
+		lockouts = append(lockouts, lockout)
+	}
+	err = rows.Err()
+	return
+}
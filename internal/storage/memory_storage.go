@@ -0,0 +1,898 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+	"github.com/PaBah/gofermart/internal/tracing"
+)
+
+type accrualJobRecord struct {
+	models.AccrualJob
+	nextAttemptAt time.Time
+	lockedUntil   time.Time
+}
+
+type withdrawalRecord struct {
+	models.Withdrawal
+	id     string
+	userID string
+}
+
+type sessionRecord struct {
+	models.Session
+	revoked bool
+}
+
+type idempotencyRecordKey struct {
+	userID string
+	key    string
+}
+
+type idempotencyRecord struct {
+	models.IdempotencyRecord
+	expiresAt time.Time
+}
+
+type loginThrottleKey struct {
+	scope string
+	key   string
+}
+
+type webhookDeliveryRecord struct {
+	models.WebhookDelivery
+	lockedUntil time.Time
+}
+
+type userEventsListener struct {
+	notify func(userID string)
+}
+
+// MemoryStorage — хранилище для локального запуска без DATABASE_URI и для тестов.
+// Повторяет поведение DBStorage, включая события пользователя и очередь вебхуков, но теряет данные при остановке.
+type MemoryStorage struct {
+	mu                sync.RWMutex
+	users             map[string]models.User
+	orders            map[string]models.Order
+	accrualJobs       map[string]accrualJobRecord
+	accruedOrders     map[string]struct{}
+	balances          map[string]models.Balance
+	withdrawals       []withdrawalRecord
+	idempotencyKeys   map[idempotencyRecordKey]idempotencyRecord
+	sessions          map[string]sessionRecord
+	loginThrottles    map[loginThrottleKey]models.LoginThrottle
+	loginLockouts     []models.LoginLockout
+	userEvents        []models.UserEvent
+	lastUserEventID   int64
+	listeners         map[*userEventsListener]struct{}
+	webhookEndpoints  []models.WebhookEndpoint
+	webhookDeliveries []webhookDeliveryRecord
+}
+
+// now возвращает текущее время с точностью Postgres, чтобы курсоры страниц вели себя одинаково в обоих хранилищах.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// newID генерирует UUID v4, как gen_random_uuid() в базе.
+func newID() string {
+	raw := make([]byte, 16)
+	_, _ = rand.Read(raw)
+	raw[6] = raw[6]&0x0f | 0x40
+	raw[8] = raw[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", raw[0:4], raw[4:6], raw[6:8], raw[8:10], raw[10:])
+}
+
+func (ms *MemoryStorage) CreateUser(ctx context.Context, user models.User) (models.User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.users[user.Login]; ok {
+		return models.User{}, ErrAlreadyExists
+	}
+	user.ID = newID()
+	ms.users[user.Login] = user
+	return user, nil
+}
+
+// AuthorizeUser, как и DBStorage, возвращает sql.ErrNoRows для неизвестного логина.
+func (ms *MemoryStorage) AuthorizeUser(ctx context.Context, login string) (models.User, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	user, ok := ms.users[login]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (ms *MemoryStorage) RegisterOrder(ctx context.Context, orderNumber string) (models.Order, error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if order, ok := ms.orders[orderNumber]; ok {
+		existing := models.Order{Number: order.Number, UserID: order.UserID, UploadedAt: order.UploadedAt}
+		if order.UserID != userID {
+			return existing, ErrOrderConflict
+		}
+		return existing, ErrAlreadyExists
+	}
+
+	uploadedAt := now()
+	ms.orders[orderNumber] = models.Order{Number: orderNumber, UserID: userID, Status: "NEW", UploadedAt: uploadedAt}
+	ms.accrualJobs[orderNumber] = accrualJobRecord{
+		AccrualJob:    models.AccrualJob{OrderNumber: orderNumber, UploadedAt: uploadedAt, TraceParent: tracing.TraceParent(ctx)},
+		nextAttemptAt: uploadedAt,
+	}
+	return models.Order{Number: orderNumber, UserID: userID, UploadedAt: uploadedAt}, nil
+}
+
+// GetUsersOrder возвращает заказ текущего пользователя; чужой заказ неотличим от отсутствующего.
+func (ms *MemoryStorage) GetUsersOrder(ctx context.Context, orderNumber string) (models.Order, error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	order, ok := ms.orders[orderNumber]
+	if !ok || order.UserID != userID {
+		return models.Order{}, ErrNotFound
+	}
+	return order, nil
+}
+
+func (ms *MemoryStorage) UpdateOrder(ctx context.Context, order models.Order) (models.Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stored, ok := ms.orders[order.Number]
+	if !ok {
+		return models.Order{}, sql.ErrNoRows
+	}
+	changed := stored.Status != order.Status || stored.Accrual != order.Accrual
+	stored.Status = order.Status
+	stored.Accrual = order.Accrual
+	stored.AccrualCheckedAt = now()
+	ms.orders[order.Number] = stored
+	if changed {
+		ms.recordUserEvent(stored.UserID, models.UserEventOrder, map[string]interface{}{
+			"number": stored.Number, "status": stored.Status, "accrual": stored.Accrual, "uploaded_at": stored.UploadedAt,
+		})
+	}
+
+	if order.Status == "PROCESSED" && order.Accrual > 0 {
+		if _, accrued := ms.accruedOrders[order.Number]; !accrued {
+			ms.accruedOrders[order.Number] = struct{}{}
+			ms.postBalance(stored.UserID, order.Accrual)
+		}
+	}
+
+	if order.Status == "PROCESSED" || order.Status == "INVALID" {
+		delete(ms.accrualJobs, order.Number)
+
+		eventType := models.WebhookEventOrderProcessed
+		if order.Status == "INVALID" {
+			eventType = models.WebhookEventOrderInvalid
+		}
+		ms.enqueueWebhooks(stored.UserID, eventType, map[string]interface{}{
+			"user_id": stored.UserID, "number": order.Number, "status": order.Status, "accrual": order.Accrual,
+		})
+	}
+
+	order.UserID = stored.UserID
+	return order, nil
+}
+
+// postBalance применяет проводку к балансу пользователя: положительная сумма — начисление, отрицательная — списание.
+func (ms *MemoryStorage) postBalance(userID string, amount models.Money) {
+	balance := ms.balances[userID]
+	balance.Current += amount
+	if amount < 0 {
+		balance.Withdrawn -= amount
+	}
+	ms.balances[userID] = balance
+	ms.recordUserEvent(userID, models.UserEventBalance, map[string]interface{}{"current": balance.Current, "withdrawn": balance.Withdrawn})
+}
+
+func (ms *MemoryStorage) GetBalance(ctx context.Context) (models.Balance, error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.balances[userID], nil
+}
+
+func (ms *MemoryStorage) Withdraw(ctx context.Context, userID string, orderNumber string, sum models.Money) (models.Withdrawal, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.balances[userID].Current < sum {
+		return models.Withdrawal{}, ErrInsufficientFunds
+	}
+	for _, withdrawal := range ms.withdrawals {
+		if withdrawal.OrderNumber == orderNumber {
+			return models.Withdrawal{}, ErrAlreadyExists
+		}
+	}
+
+	withdrawal := models.Withdrawal{OrderNumber: orderNumber, Sum: sum, ProcessedAt: now()}
+	ms.withdrawals = append(ms.withdrawals, withdrawalRecord{Withdrawal: withdrawal, id: newID(), userID: userID})
+	ms.postBalance(userID, -sum)
+	ms.enqueueWebhooks(userID, models.WebhookEventWithdrawalCreated, map[string]interface{}{
+		"user_id": userID, "order": orderNumber, "sum": sum, "processed_at": withdrawal.ProcessedAt,
+	})
+	return withdrawal, nil
+}
+
+// listPage отбирает страницу так же, как buildListQuery: фильтр по времени, сортировка по (время, ключ) и keyset после курсора.
+func listPage[T any](items []T, query pagination.Query, key func(T) (time.Time, string)) (page []T, next *pagination.Cursor) {
+	compare := func(a, b T) int {
+		aTime, aKey := key(a)
+		bTime, bKey := key(b)
+		if c := aTime.Compare(bTime); c != 0 {
+			return c
+		}
+		return strings.Compare(aKey, bKey)
+	}
+	direction := 1
+	if query.Descending {
+		direction = -1
+	}
+
+	page = make([]T, 0, query.Limit)
+	for _, item := range items {
+		itemTime, itemKey := key(item)
+		if !query.From.IsZero() && itemTime.Before(query.From) {
+			continue
+		}
+		if !query.To.IsZero() && !itemTime.Before(query.To) {
+			continue
+		}
+		if query.Cursor != nil {
+			c := itemTime.Compare(query.Cursor.Time)
+			if c == 0 {
+				c = strings.Compare(itemKey, query.Cursor.Key)
+			}
+			if c*direction <= 0 {
+				continue
+			}
+		}
+		page = append(page, item)
+	}
+	sort.Slice(page, func(i, j int) bool { return compare(page[i], page[j])*direction < 0 })
+
+	if query.Limit > 0 && len(page) > query.Limit {
+		page = page[:query.Limit]
+		lastTime, lastKey := key(page[len(page)-1])
+		next = &pagination.Cursor{Time: lastTime, Key: lastKey, Descending: query.Descending}
+	}
+	return page, next
+}
+
+// ListUsersOrders возвращает страницу заказов пользователя и курсор следующей страницы, если она есть.
+func (ms *MemoryStorage) ListUsersOrders(ctx context.Context, query pagination.Query) ([]models.Order, *pagination.Cursor, error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	orders := make([]models.Order, 0)
+	for _, order := range ms.orders {
+		if order.UserID != userID || (len(query.Statuses) > 0 && !slices.Contains(query.Statuses, order.Status)) {
+			continue
+		}
+		orders = append(orders, models.Order{Number: order.Number, Status: order.Status, Accrual: order.Accrual, UploadedAt: order.UploadedAt})
+	}
+
+	orders, next := listPage(orders, query, func(order models.Order) (time.Time, string) { return order.UploadedAt, order.Number })
+	return orders, next, nil
+}
+
+// ListUsersWithdrawals возвращает страницу списаний пользователя и курсор следующей страницы, если она есть.
+func (ms *MemoryStorage) ListUsersWithdrawals(ctx context.Context, query pagination.Query) ([]models.Withdrawal, *pagination.Cursor, error) {
+	userID := ctx.Value(auth.ContextUserKey).(string)
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	records := make([]withdrawalRecord, 0)
+	for _, record := range ms.withdrawals {
+		if record.userID == userID {
+			records = append(records, record)
+		}
+	}
+
+	records, next := listPage(records, query, func(record withdrawalRecord) (time.Time, string) { return record.ProcessedAt, record.id })
+	withdrawals := make([]models.Withdrawal, len(records))
+	for i, record := range records {
+		withdrawals[i] = record.Withdrawal
+	}
+	return withdrawals, next, nil
+}
+
+// ClaimAccrualJobs забирает до limit готовых к опросу заказов и блокирует их на время lease.
+func (ms *MemoryStorage) ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	claimedAt := now()
+	ready := make([]accrualJobRecord, 0)
+	for _, job := range ms.accrualJobs {
+		if !job.nextAttemptAt.After(claimedAt) && !job.lockedUntil.After(claimedAt) {
+			ready = append(ready, job)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		if !ready[i].nextAttemptAt.Equal(ready[j].nextAttemptAt) {
+			return ready[i].nextAttemptAt.Before(ready[j].nextAttemptAt)
+		}
+		return ready[i].OrderNumber < ready[j].OrderNumber
+	})
+
+	jobs := make([]models.AccrualJob, 0)
+	for _, job := range ready[:min(limit, len(ready))] {
+		job.Attempts++
+		job.lockedUntil = claimedAt.Add(lease)
+		ms.accrualJobs[job.OrderNumber] = job
+		jobs = append(jobs, job.AccrualJob)
+	}
+	return jobs, nil
+}
+
+// RescheduleAccrualJob снимает блокировку и откладывает следующий опрос заказа на delay.
+func (ms *MemoryStorage) RescheduleAccrualJob(ctx context.Context, orderNumber string, delay time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if job, ok := ms.accrualJobs[orderNumber]; ok {
+		job.nextAttemptAt = now().Add(delay)
+		job.lockedUntil = time.Time{}
+		ms.accrualJobs[orderNumber] = job
+	}
+	return nil
+}
+
+// MarkAccrualChecked фиксирует ответ системы расчёта, не изменивший заказ.
+func (ms *MemoryStorage) MarkAccrualChecked(ctx context.Context, orderNumber string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if order, ok := ms.orders[orderNumber]; ok {
+		order.AccrualCheckedAt = now()
+		ms.orders[orderNumber] = order
+	}
+	return nil
+}
+
+// CountOrdersByStatus считает заказы в каждом из statuses; статусы без заказов в ответ не попадают.
+func (ms *MemoryStorage) CountOrdersByStatus(ctx context.Context, statuses ...string) (map[string]int64, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	counts := make(map[string]int64)
+	for _, order := range ms.orders {
+		if slices.Contains(statuses, order.Status) {
+			counts[order.Status]++
+		}
+	}
+	return counts, nil
+}
+
+// ReserveIdempotencyKey резервирует ключ за пользователем на ttl до получения ответа; истёкший ключ занимается заново.
+// Если ключ уже был использован, возвращает сохранённую запись и ErrAlreadyExists.
+func (ms *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) (models.IdempotencyRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	key := idempotencyRecordKey{userID: record.UserID, key: record.Key}
+	if stored, ok := ms.idempotencyKeys[key]; ok && stored.expiresAt.After(now) {
+		return stored.IdempotencyRecord, ErrAlreadyExists
+	}
+	ms.idempotencyKeys[key] = idempotencyRecord{
+		IdempotencyRecord: models.IdempotencyRecord{Key: record.Key, UserID: record.UserID, RequestHash: record.RequestHash},
+		expiresAt:         now.Add(ttl),
+	}
+	return record, nil
+}
+
+// SaveIdempotencyResponse сохраняет ответ и продлевает жизнь ключа на ttl.
+func (ms *MemoryStorage) SaveIdempotencyResponse(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := idempotencyRecordKey{userID: record.UserID, key: record.Key}
+	if stored, ok := ms.idempotencyKeys[key]; ok {
+		stored.StatusCode = record.StatusCode
+		stored.ContentType = record.ContentType
+		stored.Body = slices.Clone(record.Body)
+		stored.expiresAt = time.Now().Add(ttl)
+		ms.idempotencyKeys[key] = stored
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) DeleteIdempotencyKey(ctx context.Context, userID string, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delete(ms.idempotencyKeys, idempotencyRecordKey{userID: userID, key: key})
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys удаляет истёкшие ключи и возвращает их количество.
+func (ms *MemoryStorage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for key, stored := range ms.idempotencyKeys {
+		if !stored.expiresAt.After(now) {
+			delete(ms.idempotencyKeys, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (ms *MemoryStorage) CreateSession(ctx context.Context, session models.Session) (models.Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, stored := range ms.sessions {
+		if stored.RefreshTokenHash == session.RefreshTokenHash {
+			return models.Session{}, ErrAlreadyExists
+		}
+	}
+	session.ID = newID()
+	ms.sessions[session.ID] = sessionRecord{Session: session}
+	return session, nil
+}
+
+// RotateSession заменяет refresh-токен активной сессии; старый токен после этого недействителен.
+func (ms *MemoryStorage) RotateSession(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, expiresAt time.Time) (models.Session, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, stored := range ms.sessions {
+		if stored.RefreshTokenHash == refreshTokenHash && stored.active() {
+			stored.RefreshTokenHash = newRefreshTokenHash
+			stored.ExpiresAt = expiresAt
+			ms.sessions[id] = stored
+			return stored.Session, nil
+		}
+	}
+	return models.Session{}, ErrNotFound
+}
+
+func (ms *MemoryStorage) RevokeSession(ctx context.Context, sessionID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if stored, ok := ms.sessions[sessionID]; ok {
+		stored.revoked = true
+		ms.sessions[sessionID] = stored
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) RevokeUserSessions(ctx context.Context, userID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for id, stored := range ms.sessions {
+		if stored.UserID == userID {
+			stored.revoked = true
+			ms.sessions[id] = stored
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	stored, ok := ms.sessions[sessionID]
+	return ok && stored.active(), nil
+}
+
+func (s sessionRecord) active() bool {
+	return !s.revoked && s.ExpiresAt.After(time.Now())
+}
+
+// loginRetryAfter возвращает, сколько ещё ждать до следующей попытки: до конца блокировки или паузы после
+// последней неудачи в пределах окна.
+func loginRetryAfter(throttle models.LoginThrottle, policy models.LoginPolicy, at time.Time) time.Duration {
+	nextAttempt := throttle.LockedUntil
+	if throttle.Failures > 0 && throttle.LastFailureAt.After(at.Add(-policy.Window)) {
+		if delayed := throttle.LastFailureAt.Add(policy.DelayAfter(throttle.Failures)); delayed.After(nextAttempt) {
+			nextAttempt = delayed
+		}
+	}
+	if nextAttempt.After(at) {
+		return nextAttempt.Sub(at)
+	}
+	return 0
+}
+
+// CheckLogin проверяет, разрешена ли попытка входа, ничего не учитывая.
+func (ms *MemoryStorage) CheckLogin(ctx context.Context, scope string, key string, policy models.LoginPolicy) (models.LoginAttempt, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	checkedAt := now()
+	throttle := ms.loginThrottles[loginThrottleKey{scope: scope, key: key}]
+	if retryAfter := loginRetryAfter(throttle, policy, checkedAt); retryAfter > 0 {
+		return models.LoginAttempt{Failures: throttle.Failures, LockedUntil: throttle.LockedUntil, RetryAfter: retryAfter}, nil
+	}
+	return models.LoginAttempt{Allowed: true, Failures: throttle.Failures}, nil
+}
+
+// AttemptLogin учитывает попытку входа. Попытка, на которой счётчик достигает MaxFailures,
+// ещё разрешается, но блокирует ключ и записывается в журнал.
+func (ms *MemoryStorage) AttemptLogin(ctx context.Context, scope string, key string, policy models.LoginPolicy) (models.LoginAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	attemptedAt := now()
+	throttleKey := loginThrottleKey{scope: scope, key: key}
+	throttle, ok := ms.loginThrottles[throttleKey]
+	if !ok {
+		throttle = models.LoginThrottle{Scope: scope, Key: key}
+	}
+
+	if retryAfter := loginRetryAfter(throttle, policy, attemptedAt); retryAfter > 0 {
+		return models.LoginAttempt{Failures: throttle.Failures, LockedUntil: throttle.LockedUntil, RetryAfter: retryAfter}, nil
+	}
+
+	restart := !ok || !throttle.LastFailureAt.After(attemptedAt.Add(-policy.Window))
+	if restart || !throttle.LockedUntil.IsZero() {
+		throttle.Failures = 1
+	} else {
+		throttle.Failures++
+	}
+	throttle.LastFailureAt = attemptedAt
+	throttle.LockedUntil = time.Time{}
+	if policy.MaxFailures > 0 && throttle.Failures >= policy.MaxFailures {
+		throttle.LockedUntil = attemptedAt.Add(policy.Lockout)
+		ms.loginLockouts = append(ms.loginLockouts, models.LoginLockout{
+			ID: int64(len(ms.loginLockouts) + 1), Scope: scope, Key: key, Failures: throttle.Failures, LockedUntil: throttle.LockedUntil, CreatedAt: attemptedAt,
+		})
+	}
+	ms.loginThrottles[throttleKey] = throttle
+	return models.LoginAttempt{Allowed: true, Failures: throttle.Failures, LockedUntil: throttle.LockedUntil}, nil
+}
+
+// ResetLoginFailures обнуляет счётчик и снимает блокировку после успешного входа.
+func (ms *MemoryStorage) ResetLoginFailures(ctx context.Context, scope string, key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	throttleKey := loginThrottleKey{scope: scope, key: key}
+	if throttle, ok := ms.loginThrottles[throttleKey]; ok {
+		throttle.Failures = 0
+		throttle.LockedUntil = time.Time{}
+		ms.loginThrottles[throttleKey] = throttle
+	}
+	return nil
+}
+
+// UnlockLogin снимает блокировку и сбрасывает счётчик; в журнале фиксируется, кто снял блокировку.
+func (ms *MemoryStorage) UnlockLogin(ctx context.Context, scope string, key string, unlockedBy string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	throttleKey := loginThrottleKey{scope: scope, key: key}
+	if _, ok := ms.loginThrottles[throttleKey]; !ok {
+		return ErrNotFound
+	}
+	delete(ms.loginThrottles, throttleKey)
+
+	unlockedAt := now()
+	for i, lockout := range ms.loginLockouts {
+		if lockout.Scope == scope && lockout.Key == key && lockout.UnlockedAt == nil && lockout.LockedUntil.After(unlockedAt) {
+			ms.loginLockouts[i].UnlockedAt = &unlockedAt
+			ms.loginLockouts[i].UnlockedBy = unlockedBy
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) GetLoginLockouts(ctx context.Context, limit int) ([]models.LoginLockout, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	lockouts := make([]models.LoginLockout, 0)
+	for i := len(ms.loginLockouts) - 1; i >= 0 && len(lockouts) < limit; i-- {
+		lockouts = append(lockouts, ms.loginLockouts[i])
+	}
+	return lockouts, nil
+}
+
+// recordUserEvent делает то же, что триггеры user_events в базе: пишет событие в журнал и будит подписчиков.
+// Подписчики вызываются под блокировкой хранилища, поэтому notify не должен обращаться к нему.
+func (ms *MemoryStorage) recordUserEvent(userID string, eventType string, data interface{}) {
+	// Полезная нагрузка собирается из строк, сумм и времени и всегда сериализуется
+	payload, _ := json.Marshal(data)
+	// Счётчик не зависит от длины журнала, из которого удаляются старые события
+	ms.lastUserEventID++
+	ms.userEvents = append(ms.userEvents, models.UserEvent{
+		ID: ms.lastUserEventID, UserID: userID, Type: eventType, Payload: payload, CreatedAt: now(),
+	})
+	for listener := range ms.listeners {
+		listener.notify(userID)
+	}
+}
+
+func (ms *MemoryStorage) GetUserEvents(ctx context.Context, userID string, afterID int64, limit int) ([]models.UserEvent, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	events := make([]models.UserEvent, 0)
+	for _, event := range ms.userEvents {
+		if len(events) == limit {
+			break
+		}
+		if event.UserID == userID && event.ID > afterID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (ms *MemoryStorage) GetLastUserEventID(ctx context.Context, userID string) (int64, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for i := len(ms.userEvents) - 1; i >= 0; i-- {
+		if ms.userEvents[i].UserID == userID {
+			return ms.userEvents[i].ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// DeleteExpiredUserEvents удаляет события старше retention и возвращает их количество.
+func (ms *MemoryStorage) DeleteExpiredUserEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	threshold := now().Add(-retention)
+	kept := ms.userEvents[:0]
+	for _, event := range ms.userEvents {
+		if !event.CreatedAt.Before(threshold) {
+			kept = append(kept, event)
+		}
+	}
+	deleted := int64(len(ms.userEvents) - len(kept))
+	ms.userEvents = kept
+	return deleted, nil
+}
+
+// ListenUserEvents вызывает notify на каждое новое событие, пока не отменён ctx.
+func (ms *MemoryStorage) ListenUserEvents(ctx context.Context, notify func(userID string)) error {
+	listener := &userEventsListener{notify: notify}
+	ms.mu.Lock()
+	ms.listeners[listener] = struct{}{}
+	ms.mu.Unlock()
+
+	<-ctx.Done()
+
+	ms.mu.Lock()
+	delete(ms.listeners, listener)
+	ms.mu.Unlock()
+	return ctx.Err()
+}
+
+// enqueueWebhooks ставит событие в очередь доставки всем подписанным эндпоинтам пользователя и администратора.
+func (ms *MemoryStorage) enqueueWebhooks(userID string, eventType string, data interface{}) {
+	payload, _ := json.Marshal(data)
+	createdAt := now()
+	for _, endpoint := range ms.webhookEndpoints {
+		if (endpoint.UserID == userID || endpoint.UserID == "") && slices.Contains(endpoint.Events, eventType) {
+			ms.webhookDeliveries = append(ms.webhookDeliveries, webhookDeliveryRecord{WebhookDelivery: models.WebhookDelivery{
+				ID: newID(), EndpointID: endpoint.ID, EventType: eventType, Payload: payload,
+				Status: models.WebhookDeliveryPending, NextAttemptAt: createdAt, CreatedAt: createdAt,
+			}})
+		}
+	}
+}
+
+func (ms *MemoryStorage) CreateWebhookEndpoint(ctx context.Context, endpoint models.WebhookEndpoint) (models.WebhookEndpoint, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	endpoint.ID = newID()
+	endpoint.Events = slices.Clone(endpoint.Events)
+	endpoint.CreatedAt = now()
+	ms.webhookEndpoints = append(ms.webhookEndpoints, endpoint)
+	return endpoint, nil
+}
+
+func (ms *MemoryStorage) GetWebhookEndpoints(ctx context.Context, userID string) ([]models.WebhookEndpoint, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	endpoints := make([]models.WebhookEndpoint, 0)
+	for _, endpoint := range ms.webhookEndpoints {
+		if endpoint.UserID == userID {
+			endpoint.Events = slices.Clone(endpoint.Events)
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+// webhookEndpoint ищет эндпоинт владельца userID; пустой userID — администратор.
+func (ms *MemoryStorage) webhookEndpoint(userID string, endpointID string) (models.WebhookEndpoint, bool) {
+	for _, endpoint := range ms.webhookEndpoints {
+		if endpoint.ID == endpointID && endpoint.UserID == userID {
+			return endpoint, true
+		}
+	}
+	return models.WebhookEndpoint{}, false
+}
+
+// DeleteWebhookEndpoint удаляет эндпоинт вместе с журналом его доставок.
+func (ms *MemoryStorage) DeleteWebhookEndpoint(ctx context.Context, userID string, endpointID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.webhookEndpoint(userID, endpointID); !ok {
+		return ErrNotFound
+	}
+	ms.webhookEndpoints = slices.DeleteFunc(ms.webhookEndpoints, func(endpoint models.WebhookEndpoint) bool { return endpoint.ID == endpointID })
+	ms.webhookDeliveries = slices.DeleteFunc(ms.webhookDeliveries, func(delivery webhookDeliveryRecord) bool { return delivery.EndpointID == endpointID })
+	return nil
+}
+
+// GetWebhookDeliveries возвращает журнал доставок эндпоинта, новые первыми. Чужой эндпоинт неотличим от отсутствующего.
+func (ms *MemoryStorage) GetWebhookDeliveries(ctx context.Context, userID string, endpointID string, limit int) ([]models.WebhookDelivery, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if _, ok := ms.webhookEndpoint(userID, endpointID); !ok {
+		return nil, ErrNotFound
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for i := len(ms.webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if delivery := ms.webhookDeliveries[i].WebhookDelivery; delivery.EndpointID == endpointID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook возвращает доставку в очередь с нулевым счётчиком попыток, в том числе из состояния DEAD.
+func (ms *MemoryStorage) RedeliverWebhook(ctx context.Context, userID string, endpointID string, deliveryID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	delivery := ms.webhookDelivery(deliveryID)
+	if _, ok := ms.webhookEndpoint(userID, endpointID); !ok || delivery == nil || delivery.EndpointID != endpointID {
+		return ErrNotFound
+	}
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = now()
+	delivery.lockedUntil = time.Time{}
+	return nil
+}
+
+// ClaimWebhookDeliveries забирает до limit доставок, готовых к отправке, и блокирует их на время lease.
+func (ms *MemoryStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	claimedAt := now()
+	ready := make([]*webhookDeliveryRecord, 0)
+	for i := range ms.webhookDeliveries {
+		delivery := &ms.webhookDeliveries[i]
+		if delivery.Status == models.WebhookDeliveryPending && !delivery.NextAttemptAt.After(claimedAt) && !delivery.lockedUntil.After(claimedAt) {
+			ready = append(ready, delivery)
+		}
+	}
+	sort.SliceStable(ready, func(i, j int) bool { return ready[i].NextAttemptAt.Before(ready[j].NextAttemptAt) })
+
+	deliveries := make([]models.WebhookDelivery, 0)
+	for _, delivery := range ready[:min(limit, len(ready))] {
+		delivery.Attempts++
+		delivery.lockedUntil = claimedAt.Add(lease)
+
+		endpoint, _ := ms.webhookEndpointByID(delivery.EndpointID)
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID: delivery.ID, EndpointID: delivery.EndpointID, URL: endpoint.URL, Secret: endpoint.Secret, EventType: delivery.EventType,
+			Payload: delivery.Payload, Status: models.WebhookDeliveryPending, Attempts: delivery.Attempts, CreatedAt: delivery.CreatedAt,
+		})
+	}
+	return deliveries, nil
+}
+
+func (ms *MemoryStorage) webhookEndpointByID(endpointID string) (models.WebhookEndpoint, bool) {
+	for _, endpoint := range ms.webhookEndpoints {
+		if endpoint.ID == endpointID {
+			return endpoint, true
+		}
+	}
+	return models.WebhookEndpoint{}, false
+}
+
+// webhookDelivery возвращает указатель на доставку в очереди; он действителен, пока очередь не изменилась.
+func (ms *MemoryStorage) webhookDelivery(deliveryID string) *webhookDeliveryRecord {
+	for i := range ms.webhookDeliveries {
+		if ms.webhookDeliveries[i].ID == deliveryID {
+			return &ms.webhookDeliveries[i]
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStorage) CompleteWebhookDelivery(ctx context.Context, deliveryID string, statusCode int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if delivery := ms.webhookDelivery(deliveryID); delivery != nil {
+		deliveredAt := now()
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &deliveredAt
+		delivery.lockedUntil = time.Time{}
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = ""
+	}
+	return nil
+}
+
+// RetryWebhookDelivery записывает неудачную попытку и откладывает следующую на delay.
+func (ms *MemoryStorage) RetryWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string, delay time.Duration) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if delivery := ms.webhookDelivery(deliveryID); delivery != nil {
+		delivery.NextAttemptAt = now().Add(delay)
+		delivery.lockedUntil = time.Time{}
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = message
+	}
+	return nil
+}
+
+// DeadLetterWebhookDelivery снимает доставку с очереди после исчерпания попыток; вернуть её можно через RedeliverWebhook.
+func (ms *MemoryStorage) DeadLetterWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if delivery := ms.webhookDelivery(deliveryID); delivery != nil {
+		delivery.Status = models.WebhookDeliveryDead
+		delivery.lockedUntil = time.Time{}
+		delivery.LastStatusCode = statusCode
+		delivery.LastError = message
+	}
+	return nil
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:           make(map[string]models.User),
+		orders:          make(map[string]models.Order),
+		accrualJobs:     make(map[string]accrualJobRecord),
+		accruedOrders:   make(map[string]struct{}),
+		balances:        make(map[string]models.Balance),
+		idempotencyKeys: make(map[idempotencyRecordKey]idempotencyRecord),
+		sessions:        make(map[string]sessionRecord),
+		loginThrottles:  make(map[loginThrottleKey]models.LoginThrottle),
+		listeners:       make(map[*userEventsListener]struct{}),
+	}
+}
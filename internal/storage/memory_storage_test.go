@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStorage_WithdrawConcurrent(t *testing.T) {
+	testWithdrawConcurrent(t, NewMemoryStorage())
+}
+
+func TestMemoryStorage_UserEventsConcurrent(t *testing.T) {
+	testUserEventsConcurrent(t, NewMemoryStorage())
+}
+
+func TestMemoryStorage_ListenUserEvents(t *testing.T) {
+	ms := NewMemoryStorage()
+	ctx, user := createRepositoryUser(t, ms)
+	_, err := ms.RegisterOrder(ctx, "12345678903")
+	require.NoError(t, err)
+
+	notified := make(chan string, 10)
+	listenCtx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- ms.ListenUserEvents(listenCtx, func(userID string) { notified <- userID }) }()
+
+	require.Eventually(t, func() bool {
+		ms.mu.RLock()
+		defer ms.mu.RUnlock()
+		return len(ms.listeners) == 1
+	}, time.Second, time.Millisecond)
+
+	_, err = ms.UpdateOrder(ctx, models.Order{Number: "12345678903", Status: "PROCESSING"})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, <-notified, "Order event wakes listener")
+
+	cancel()
+	assert.ErrorIs(t, <-stopped, context.Canceled)
+	assert.Empty(t, ms.listeners, "Listener is removed after ctx is done")
+}
+
+func TestListPage(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	orders := []models.Order{
+		{Number: "3", UploadedAt: base.Add(time.Minute)},
+		{Number: "1", UploadedAt: base},
+		{Number: "2", UploadedAt: base},
+		{Number: "4", UploadedAt: base.Add(2 * time.Minute)},
+	}
+	key := func(order models.Order) (time.Time, string) { return order.UploadedAt, order.Number }
+	numbers := func(orders []models.Order) (result []string) {
+		for _, order := range orders {
+			result = append(result, order.Number)
+		}
+		return
+	}
+
+	page, next := listPage(orders, pagination.Query{Limit: 2}, key)
+	assert.Equal(t, []string{"1", "2"}, numbers(page))
+	require.NotNil(t, next)
+	assert.Equal(t, pagination.Cursor{Time: base, Key: "2"}, *next)
+
+	page, next = listPage(orders, pagination.Query{Limit: 2, Cursor: next}, key)
+	assert.Equal(t, []string{"3", "4"}, numbers(page))
+	assert.Nil(t, next)
+
+	page, next = listPage(orders, pagination.Query{}, key)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, numbers(page), "No limit returns everything")
+	assert.Nil(t, next)
+
+	page, _ = listPage(orders, pagination.Query{Limit: 10, Descending: true, From: base.Add(time.Minute), To: base.Add(2 * time.Minute)}, key)
+	assert.Equal(t, []string{"3"}, numbers(page), "From is inclusive, To is exclusive")
+}
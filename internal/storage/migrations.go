@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/PaBah/gofermart/db"
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+const migrationsTable = "schema_migrations"
+
+var (
+	ErrSchemaBehind = errors.New("database schema is behind")
+	ErrSchemaDirty  = errors.New("database schema is dirty")
+)
+
+// Migrator применяет встроенные миграции db.MigrationsFS; изменения схемы выполняются под advisory lock.
+type Migrator struct {
+	m      *migrate.Migrate
+	handle dbHandle
+}
+
+// newMigrator занимает у sqlDB отдельное соединение: Close возвращает его, не закрывая пул.
+func newMigrator(ctx context.Context, sqlDB *sql.DB) (*Migrator, error) {
+	source, err := iofs.New(db.MigrationsFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := postgres.WithConnection(ctx, conn, &postgres.Config{MigrationsTable: migrationsTable})
+	if err != nil {
+		return nil, errors.Join(err, conn.Close())
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "postgres", driver)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{m: m}, nil
+}
+
+// OpenMigrator подключается к базе для управления схемой без запуска хранилища.
+func OpenMigrator(ctx context.Context, options config.DatabaseOptions) (*Migrator, error) {
+	handle, err := openHandle(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	migrator, err := func() (*Migrator, error) {
+		if err := connect(ctx, handle.PingContext, options.ConnectTimeout); err != nil {
+			return nil, err
+		}
+		return newMigrator(ctx, handle.stdDB())
+	}()
+	if err != nil {
+		return nil, errors.Join(err, handle.Close())
+	}
+	migrator.handle = handle
+	return migrator, nil
+}
+
+// Up применяет все новые миграции; отсутствие новых миграций ошибкой не считается.
+func (mg *Migrator) Up() error {
+	return ignoreNoChange(mg.m.Up())
+}
+
+// Down откатывает n последних миграций.
+func (mg *Migrator) Down(n int) error {
+	if n < 1 {
+		return fmt.Errorf("number of migrations to roll back must be positive, got %d", n)
+	}
+	return ignoreNoChange(mg.m.Steps(-n))
+}
+
+// Goto приводит схему к версии version, применяя или откатывая миграции.
+func (mg *Migrator) Goto(version uint) error {
+	return ignoreNoChange(mg.m.Migrate(version))
+}
+
+// Force записывает версию без выполнения миграций и снимает признак dirty; -1 означает пустую схему.
+func (mg *Migrator) Force(version int) error {
+	return mg.m.Force(version)
+}
+
+// Version возвращает применённую версию; 0 без dirty — миграции ещё не применялись.
+func (mg *Migrator) Version() (version uint, dirty bool, err error) {
+	version, dirty, err = mg.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return
+}
+
+// CheckVersion требует, чтобы схема была не старше встроенных миграций и не осталась dirty после прерванной миграции.
+func (mg *Migrator) CheckVersion() error {
+	latest, err := LatestMigrationVersion()
+	if err != nil {
+		return err
+	}
+	version, dirty, err := mg.Version()
+	if err != nil {
+		return err
+	}
+	return checkSchemaVersion(version, dirty, latest)
+}
+
+func checkSchemaVersion(version uint, dirty bool, latest uint) error {
+	switch {
+	case dirty:
+		return fmt.Errorf("%w: migration %d failed, fix the schema and run migrate force with the last successful version", ErrSchemaDirty, version)
+	case version < latest:
+		return fmt.Errorf("%w: version %d, expected %d, run migrate up", ErrSchemaBehind, version, latest)
+	}
+	return nil
+}
+
+// Close освобождает соединение миграций; база закрывается, только если её открыл OpenMigrator.
+func (mg *Migrator) Close() error {
+	sourceErr, databaseErr := mg.m.Close()
+	err := errors.Join(sourceErr, databaseErr)
+	if mg.handle != nil {
+		err = errors.Join(err, mg.handle.Close())
+	}
+	return err
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// LatestMigrationVersion возвращает версию последней миграции, встроенной в бинарник.
+func LatestMigrationVersion() (uint, error) {
+	source, err := iofs.New(db.MigrationsFS, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	version, err := source.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := source.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
+	}
+}
+
+func (ds *DBStorage) Ping(ctx context.Context) error {
+	return ds.db.PingContext(ctx)
+}
+
+// MigrationVersion возвращает применённую версию схемы; dirty означает, что миграция прервалась.
+func (ds *DBStorage) MigrationVersion(ctx context.Context) (version uint, dirty bool, err error) {
+	row := ds.db.QueryRowContext(ctx, `SELECT version, dirty FROM `+migrationsTable+` LIMIT 1`)
+	err = row.Scan(&version, &dirty)
+	return
+}
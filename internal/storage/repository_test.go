@@ -0,0 +1,499 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/auth"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var uniqueSequence atomic.Int64
+
+// unique возвращает ключ, не встречавшийся в базе: набор проверок можно запускать на непустом Postgres.
+func unique() string {
+	return fmt.Sprintf("%d%03d", time.Now().UnixNano(), uniqueSequence.Add(1)%1000)
+}
+
+func createRepositoryUser(t *testing.T, repo Repository) (context.Context, models.User) {
+	user, err := repo.CreateUser(context.Background(), models.User{Login: "user-" + unique(), Password: "hash"})
+	require.NoError(t, err)
+	return context.WithValue(context.Background(), auth.ContextUserKey, user.ID), user
+}
+
+// testRepository проверяет контракт Repository, общий для MemoryStorage и DBStorage.
+func testRepository(t *testing.T, repo Repository) {
+	t.Run("Users", func(t *testing.T) {
+		login := "user-" + unique()
+		user, err := repo.CreateUser(context.Background(), models.User{Login: login, Password: "hash"})
+		require.NoError(t, err)
+		assert.NotEmpty(t, user.ID)
+
+		_, err = repo.CreateUser(context.Background(), models.User{Login: login, Password: "other"})
+		assert.ErrorIs(t, err, ErrAlreadyExists, "Login is unique")
+
+		authorized, err := repo.AuthorizeUser(context.Background(), login)
+		require.NoError(t, err)
+		assert.Equal(t, models.User{ID: user.ID, Login: login, Password: "hash"}, authorized)
+
+		_, err = repo.AuthorizeUser(context.Background(), "missing-"+unique())
+		assert.Error(t, err)
+	})
+
+	t.Run("OrderOwnership", func(t *testing.T) {
+		ctx, user := createRepositoryUser(t, repo)
+		otherCtx, _ := createRepositoryUser(t, repo)
+		number := unique()
+
+		order, err := repo.RegisterOrder(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, order.UserID)
+
+		order, err = repo.RegisterOrder(ctx, number)
+		assert.ErrorIs(t, err, ErrAlreadyExists)
+		assert.Equal(t, user.ID, order.UserID, "Repeated upload by owner")
+
+		order, err = repo.RegisterOrder(otherCtx, number)
+		assert.ErrorIs(t, err, ErrOrderConflict)
+		assert.Equal(t, user.ID, order.UserID, "Conflict reports the owner")
+
+		order, err = repo.GetUsersOrder(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, "NEW", order.Status)
+		assert.True(t, order.AccrualCheckedAt.IsZero())
+
+		_, err = repo.GetUsersOrder(otherCtx, number)
+		assert.ErrorIs(t, err, ErrNotFound, "Foreign order is not found")
+	})
+
+	t.Run("ListUsersOrders", func(t *testing.T) {
+		ctx, _ := createRepositoryUser(t, repo)
+		numbers := []string{unique(), unique(), unique()}
+		for _, number := range numbers {
+			_, err := repo.RegisterOrder(ctx, number)
+			require.NoError(t, err)
+		}
+		_, err := repo.UpdateOrder(ctx, models.Order{Number: numbers[1], Status: "PROCESSING"})
+		require.NoError(t, err)
+		_, err = repo.UpdateOrder(ctx, models.Order{Number: numbers[2], Status: "INVALID"})
+		require.NoError(t, err)
+
+		orders, next, err := repo.ListUsersOrders(ctx, pagination.Query{Limit: 10, Statuses: []string{"NEW", "PROCESSING"}})
+		require.NoError(t, err)
+		assert.Nil(t, next)
+		require.Len(t, orders, 2)
+		assert.Equal(t, []string{"NEW", "PROCESSING"}, []string{orders[0].Status, orders[1].Status})
+
+		var listed []string
+		query := pagination.Query{Limit: 2, Descending: true}
+		for {
+			orders, next, err = repo.ListUsersOrders(ctx, query)
+			require.NoError(t, err)
+			for _, order := range orders {
+				listed = append(listed, order.Number)
+			}
+			if next == nil {
+				break
+			}
+			query.Cursor = next
+		}
+		assert.Equal(t, []string{numbers[2], numbers[1], numbers[0]}, listed, "Pages follow each other without gaps")
+
+		orders, next, err = repo.ListUsersOrders(ctx, pagination.Query{})
+		require.NoError(t, err)
+		assert.Nil(t, next)
+		assert.Len(t, orders, 3, "Query without limit returns every order")
+	})
+
+	t.Run("Accrual", func(t *testing.T) {
+		ctx, user := createRepositoryUser(t, repo)
+		number := unique()
+		_, err := repo.RegisterOrder(ctx, number)
+		require.NoError(t, err)
+
+		order, err := repo.UpdateOrder(ctx, models.Order{Number: number, Status: "PROCESSED", Accrual: 10050})
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, order.UserID)
+		_, err = repo.UpdateOrder(ctx, models.Order{Number: number, Status: "PROCESSED", Accrual: 10050})
+		require.NoError(t, err)
+
+		balance, err := repo.GetBalance(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, models.Balance{Current: 10050}, balance, "Order is accrued once")
+
+		order, err = repo.GetUsersOrder(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, models.Money(10050), order.Accrual)
+		assert.False(t, order.AccrualCheckedAt.IsZero())
+
+		_, err = repo.UpdateOrder(ctx, models.Order{Number: unique(), Status: "PROCESSED"})
+		assert.Error(t, err, "Unknown order can not be updated")
+	})
+
+	t.Run("Withdraw", func(t *testing.T) {
+		ctx, user := createRepositoryUser(t, repo)
+		_, err := repo.Withdraw(ctx, user.ID, unique(), 100)
+		assert.ErrorIs(t, err, ErrInsufficientFunds, "User without balance")
+
+		number := unique()
+		_, err = repo.RegisterOrder(ctx, number)
+		require.NoError(t, err)
+		_, err = repo.UpdateOrder(ctx, models.Order{Number: number, Status: "PROCESSED", Accrual: 5000})
+		require.NoError(t, err)
+
+		_, err = repo.Withdraw(ctx, user.ID, unique(), 6000)
+		assert.ErrorIs(t, err, ErrInsufficientFunds)
+		withdrawalNumber := unique()
+		withdrawal, err := repo.Withdraw(ctx, user.ID, withdrawalNumber, 2000)
+		require.NoError(t, err)
+		assert.Equal(t, models.Money(2000), withdrawal.Sum)
+		_, err = repo.Withdraw(ctx, user.ID, withdrawalNumber, 1000)
+		assert.ErrorIs(t, err, ErrAlreadyExists, "Order number is used for one withdrawal only")
+
+		balance, err := repo.GetBalance(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, models.Balance{Current: 3000, Withdrawn: 2000}, balance)
+
+		withdrawals, next, err := repo.ListUsersWithdrawals(ctx, pagination.Query{Limit: 10})
+		require.NoError(t, err)
+		assert.Nil(t, next)
+		require.Len(t, withdrawals, 1)
+		assert.Equal(t, withdrawalNumber, withdrawals[0].OrderNumber)
+	})
+
+	t.Run("AccrualJobs", func(t *testing.T) {
+		ctx, _ := createRepositoryUser(t, repo)
+		number := unique()
+		_, err := repo.RegisterOrder(ctx, number)
+		require.NoError(t, err)
+
+		claim := func() *models.AccrualJob {
+			jobs, err := repo.ClaimAccrualJobs(context.Background(), 1000, time.Minute)
+			require.NoError(t, err)
+			for _, job := range jobs {
+				if job.OrderNumber == number {
+					return &job
+				}
+			}
+			return nil
+		}
+
+		job := claim()
+		require.NotNil(t, job)
+		assert.Equal(t, 1, job.Attempts)
+		assert.False(t, job.UploadedAt.IsZero())
+		assert.Nil(t, claim(), "Claimed job is locked for lease")
+
+		require.NoError(t, repo.RescheduleAccrualJob(context.Background(), number, 0))
+		job = claim()
+		require.NotNil(t, job, "Rescheduled job is claimed again")
+		assert.Equal(t, 2, job.Attempts)
+
+		require.NoError(t, repo.RescheduleAccrualJob(context.Background(), number, 0))
+		_, err = repo.UpdateOrder(ctx, models.Order{Number: number, Status: "INVALID"})
+		require.NoError(t, err)
+		assert.Nil(t, claim(), "Final status removes job")
+	})
+
+	t.Run("CountOrdersByStatus", func(t *testing.T) {
+		before, err := repo.CountOrdersByStatus(context.Background(), "NEW", "PROCESSING")
+		require.NoError(t, err)
+		assert.NotContains(t, before, "PROCESSED", "Only requested statuses are counted")
+
+		ctx, _ := createRepositoryUser(t, repo)
+		_, err = repo.RegisterOrder(ctx, unique())
+		require.NoError(t, err)
+
+		after, err := repo.CountOrdersByStatus(context.Background(), "NEW", "PROCESSING")
+		require.NoError(t, err)
+		assert.Equal(t, before["NEW"]+1, after["NEW"])
+	})
+
+	t.Run("IdempotencyKeys", func(t *testing.T) {
+		_, user := createRepositoryUser(t, repo)
+		record := models.IdempotencyRecord{Key: unique(), UserID: user.ID, RequestHash: "hash"}
+
+		stored, err := repo.ReserveIdempotencyKey(context.Background(), record, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, record, stored)
+
+		record.StatusCode, record.ContentType, record.Body = 202, "application/json", []byte(`{}`)
+		require.NoError(t, repo.SaveIdempotencyResponse(context.Background(), record, time.Hour))
+		stored, err = repo.ReserveIdempotencyKey(context.Background(), models.IdempotencyRecord{Key: record.Key, UserID: user.ID, RequestHash: "other"}, time.Minute)
+		assert.ErrorIs(t, err, ErrAlreadyExists)
+		assert.Equal(t, record, stored, "Reserved key returns saved response")
+
+		require.NoError(t, repo.DeleteIdempotencyKey(context.Background(), user.ID, record.Key))
+		_, err = repo.ReserveIdempotencyKey(context.Background(), record, time.Minute)
+		assert.NoError(t, err, "Deleted key can be reserved again")
+	})
+
+	t.Run("IdempotencyKeysExpire", func(t *testing.T) {
+		_, user := createRepositoryUser(t, repo)
+		record := models.IdempotencyRecord{Key: unique(), UserID: user.ID, RequestHash: "hash"}
+
+		_, err := repo.ReserveIdempotencyKey(context.Background(), record, -time.Second)
+		require.NoError(t, err)
+		other := models.IdempotencyRecord{Key: record.Key, UserID: user.ID, RequestHash: "other"}
+		stored, err := repo.ReserveIdempotencyKey(context.Background(), other, -time.Second)
+		assert.NoError(t, err, "Expired reservation is taken over")
+		assert.Equal(t, other, stored)
+
+		deleted, err := repo.DeleteExpiredIdempotencyKeys(context.Background())
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, deleted, int64(1))
+		_, err = repo.ReserveIdempotencyKey(context.Background(), record, time.Minute)
+		assert.NoError(t, err, "Deleted expired key can be reserved again")
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		_, user := createRepositoryUser(t, repo)
+		expiresAt := time.Now().Add(time.Hour)
+		session, err := repo.CreateSession(context.Background(), models.Session{UserID: user.ID, RefreshTokenHash: unique(), ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		active, err := repo.IsSessionActive(context.Background(), session.ID)
+		require.NoError(t, err)
+		assert.True(t, active)
+
+		newHash := unique()
+		rotated, err := repo.RotateSession(context.Background(), session.RefreshTokenHash, newHash, expiresAt)
+		require.NoError(t, err)
+		assert.Equal(t, session.ID, rotated.ID)
+		assert.Equal(t, user.ID, rotated.UserID)
+		_, err = repo.RotateSession(context.Background(), session.RefreshTokenHash, unique(), expiresAt)
+		assert.ErrorIs(t, err, ErrNotFound, "Old refresh token is not valid after rotation")
+
+		require.NoError(t, repo.RevokeSession(context.Background(), session.ID))
+		active, err = repo.IsSessionActive(context.Background(), session.ID)
+		require.NoError(t, err)
+		assert.False(t, active)
+		_, err = repo.RotateSession(context.Background(), newHash, unique(), expiresAt)
+		assert.ErrorIs(t, err, ErrNotFound, "Revoked session can not be rotated")
+
+		other, err := repo.CreateSession(context.Background(), models.Session{UserID: user.ID, RefreshTokenHash: unique(), ExpiresAt: expiresAt})
+		require.NoError(t, err)
+		require.NoError(t, repo.RevokeUserSessions(context.Background(), user.ID))
+		active, err = repo.IsSessionActive(context.Background(), other.ID)
+		require.NoError(t, err)
+		assert.False(t, active)
+	})
+
+	t.Run("LoginThrottles", func(t *testing.T) {
+		login, ip := "login-"+unique(), "ip-"+unique()
+		policy := models.LoginPolicy{MaxFailures: 2, Lockout: time.Hour, Window: time.Hour}
+		attempt, err := repo.AttemptLogin(context.Background(), models.LoginScopeLogin, login, policy)
+		require.NoError(t, err)
+		assert.True(t, attempt.Allowed)
+		assert.Equal(t, 1, attempt.Failures)
+		assert.True(t, attempt.LockedUntil.IsZero())
+
+		attempt, err = repo.AttemptLogin(context.Background(), models.LoginScopeLogin, login, policy)
+		require.NoError(t, err)
+		assert.True(t, attempt.Allowed, "Attempt reaching max failures is still checked")
+		assert.Equal(t, 2, attempt.Failures)
+		assert.True(t, attempt.LockedUntil.After(time.Now()), "Max failures lock login")
+
+		attempt, err = repo.AttemptLogin(context.Background(), models.LoginScopeLogin, login, policy)
+		require.NoError(t, err)
+		assert.False(t, attempt.Allowed, "Locked login is rejected")
+		assert.Equal(t, 2, attempt.Failures, "Rejected attempt is not counted")
+		assert.Greater(t, attempt.RetryAfter, 59*time.Minute)
+
+		// Пауза после неудачи не даёт повторить попытку сразу, а сброс после успешного входа снимает её
+		slowPolicy := models.LoginPolicy{Window: time.Hour, Delay: time.Hour}
+		attempt, err = repo.CheckLogin(context.Background(), models.LoginScopeIP, ip, slowPolicy)
+		require.NoError(t, err)
+		assert.True(t, attempt.Allowed, "Unknown key is allowed")
+		attempt, err = repo.AttemptLogin(context.Background(), models.LoginScopeIP, ip, slowPolicy)
+		require.NoError(t, err)
+		assert.True(t, attempt.Allowed)
+		attempt, err = repo.CheckLogin(context.Background(), models.LoginScopeIP, ip, slowPolicy)
+		require.NoError(t, err)
+		assert.False(t, attempt.Allowed, "Check sees delay after failure")
+		assert.Equal(t, 1, attempt.Failures, "Check does not count attempt")
+		assert.Greater(t, attempt.RetryAfter, 59*time.Minute)
+		attempt, err = repo.AttemptLogin(context.Background(), models.LoginScopeIP, ip, slowPolicy)
+		require.NoError(t, err)
+		assert.False(t, attempt.Allowed, "Delay after failure")
+		require.NoError(t, repo.ResetLoginFailures(context.Background(), models.LoginScopeIP, ip))
+		attempt, err = repo.AttemptLogin(context.Background(), models.LoginScopeIP, ip, slowPolicy)
+		require.NoError(t, err)
+		assert.True(t, attempt.Allowed, "Reset clears delay")
+
+		// Счётчик начинается заново, если последняя неудача старше Window
+		expiring := models.LoginPolicy{MaxFailures: 2, Lockout: time.Hour, Window: time.Millisecond}
+		windowKey := "window-" + unique()
+		_, err = repo.AttemptLogin(context.Background(), models.LoginScopeLogin, windowKey, expiring)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond)
+		attempt, err = repo.AttemptLogin(context.Background(), models.LoginScopeLogin, windowKey, expiring)
+		require.NoError(t, err)
+		assert.Equal(t, 1, attempt.Failures, "Counter expires after window")
+		assert.True(t, attempt.LockedUntil.IsZero())
+
+		require.NoError(t, repo.UnlockLogin(context.Background(), models.LoginScopeLogin, login, "admin"))
+		assert.ErrorIs(t, repo.UnlockLogin(context.Background(), models.LoginScopeLogin, login, "admin"), ErrNotFound)
+
+		lockouts, err := repo.GetLoginLockouts(context.Background(), 1000)
+		require.NoError(t, err)
+		var lockout *models.LoginLockout
+		for i := range lockouts {
+			if lockouts[i].Key == login {
+				lockout = &lockouts[i]
+			}
+		}
+		require.NotNil(t, lockout)
+		assert.Equal(t, 2, lockout.Failures)
+		assert.NotNil(t, lockout.UnlockedAt)
+		assert.Equal(t, "admin", lockout.UnlockedBy)
+	})
+
+	t.Run("UserEvents", func(t *testing.T) {
+		ctx, user := createRepositoryUser(t, repo)
+		number := unique()
+		_, err := repo.RegisterOrder(ctx, number)
+		require.NoError(t, err)
+		_, err = repo.UpdateOrder(ctx, models.Order{Number: number, Status: "PROCESSED", Accrual: 700})
+		require.NoError(t, err)
+
+		events, err := repo.GetUserEvents(context.Background(), user.ID, 0, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		types := []string{events[0].Type, events[1].Type}
+		assert.ElementsMatch(t, []string{models.UserEventOrder, models.UserEventBalance}, types)
+		for _, event := range events {
+			if event.Type == models.UserEventOrder {
+				var payload struct {
+					Number  string       `json:"number"`
+					Status  string       `json:"status"`
+					Accrual models.Money `json:"accrual"`
+				}
+				require.NoError(t, json.Unmarshal(event.Payload, &payload))
+				assert.Equal(t, number, payload.Number)
+				assert.Equal(t, "PROCESSED", payload.Status)
+				assert.Equal(t, models.Money(700), payload.Accrual)
+			}
+		}
+
+		lastID, err := repo.GetLastUserEventID(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, events[1].ID, lastID)
+		events, err = repo.GetUserEvents(context.Background(), user.ID, lastID, 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		_, err = repo.DeleteExpiredUserEvents(context.Background(), time.Hour)
+		require.NoError(t, err)
+		events, err = repo.GetUserEvents(context.Background(), user.ID, 0, 10)
+		require.NoError(t, err)
+		assert.Len(t, events, 2, "Fresh events are kept")
+		time.Sleep(10 * time.Millisecond)
+		deleted, err := repo.DeleteExpiredUserEvents(context.Background(), time.Millisecond)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, deleted, int64(2), "Events older than retention are deleted")
+		events, err = repo.GetUserEvents(context.Background(), user.ID, 0, 10)
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		_, err = repo.Withdraw(context.Background(), user.ID, unique(), 100)
+		require.NoError(t, err)
+		events, err = repo.GetUserEvents(context.Background(), user.ID, lastID, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 1, "New event follows the deleted ones")
+		assert.Greater(t, events[0].ID, lastID)
+	})
+
+	t.Run("Webhooks", func(t *testing.T) {
+		ctx, user := createRepositoryUser(t, repo)
+		_, other := createRepositoryUser(t, repo)
+		endpoint, err := repo.CreateWebhookEndpoint(context.Background(), models.WebhookEndpoint{
+			UserID: user.ID, URL: "http://localhost/hook", Secret: "secret", Events: []string{models.WebhookEventOrderProcessed},
+		})
+		require.NoError(t, err)
+		endpoints, err := repo.GetWebhookEndpoints(context.Background(), user.ID)
+		require.NoError(t, err)
+		require.Len(t, endpoints, 1)
+		assert.Equal(t, []string{models.WebhookEventOrderProcessed}, endpoints[0].Events)
+
+		for _, status := range []string{"INVALID", "PROCESSED"} {
+			number := unique()
+			_, err = repo.RegisterOrder(ctx, number)
+			require.NoError(t, err)
+			_, err = repo.UpdateOrder(ctx, models.Order{Number: number, Status: status, Accrual: 100})
+			require.NoError(t, err)
+		}
+
+		deliveries, err := repo.GetWebhookDeliveries(context.Background(), user.ID, endpoint.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1, "Only subscribed events are queued")
+		assert.Equal(t, models.WebhookEventOrderProcessed, deliveries[0].EventType)
+		assert.Equal(t, models.WebhookDeliveryPending, deliveries[0].Status)
+		_, err = repo.GetWebhookDeliveries(context.Background(), other.ID, endpoint.ID, 10)
+		assert.ErrorIs(t, err, ErrNotFound, "Foreign endpoint is not found")
+
+		claim := func() *models.WebhookDelivery {
+			claimed, err := repo.ClaimWebhookDeliveries(context.Background(), 1000, time.Minute)
+			require.NoError(t, err)
+			for _, delivery := range claimed {
+				if delivery.EndpointID == endpoint.ID {
+					return &delivery
+				}
+			}
+			return nil
+		}
+
+		delivery := claim()
+		require.NotNil(t, delivery)
+		assert.Equal(t, "http://localhost/hook", delivery.URL)
+		assert.Equal(t, "secret", delivery.Secret)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Nil(t, claim(), "Claimed delivery is locked for lease")
+
+		require.NoError(t, repo.RetryWebhookDelivery(context.Background(), delivery.ID, 500, "server error", 0))
+		delivery = claim()
+		require.NotNil(t, delivery, "Retried delivery is claimed again")
+		assert.Equal(t, 2, delivery.Attempts)
+
+		require.NoError(t, repo.DeadLetterWebhookDelivery(context.Background(), delivery.ID, 500, "server error"))
+		assert.Nil(t, claim(), "Dead delivery is not claimed")
+		assert.ErrorIs(t, repo.RedeliverWebhook(context.Background(), other.ID, endpoint.ID, delivery.ID), ErrNotFound)
+		require.NoError(t, repo.RedeliverWebhook(context.Background(), user.ID, endpoint.ID, delivery.ID))
+		delivery = claim()
+		require.NotNil(t, delivery)
+		assert.Equal(t, 1, delivery.Attempts, "Redelivery starts attempts over")
+
+		require.NoError(t, repo.CompleteWebhookDelivery(context.Background(), delivery.ID, 200))
+		deliveries, err = repo.GetWebhookDeliveries(context.Background(), user.ID, endpoint.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, models.WebhookDeliveryDelivered, deliveries[0].Status)
+		assert.Equal(t, 200, deliveries[0].LastStatusCode)
+		assert.NotNil(t, deliveries[0].DeliveredAt)
+
+		assert.ErrorIs(t, repo.DeleteWebhookEndpoint(context.Background(), other.ID, endpoint.ID), ErrNotFound)
+		require.NoError(t, repo.DeleteWebhookEndpoint(context.Background(), user.ID, endpoint.ID))
+		endpoints, err = repo.GetWebhookEndpoints(context.Background(), user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, endpoints)
+	})
+}
+
+func TestMemoryStorage_Repository(t *testing.T) {
+	testRepository(t, NewMemoryStorage())
+}
+
+func TestDBStorage_Repository(t *testing.T) {
+	for _, driver := range databaseDrivers {
+		t.Run(driver, func(t *testing.T) {
+			ds := testDBStorage(t, driver)
+			testRepository(t, &ds)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+)
+
+func (ds *DBStorage) CreateSession(ctx context.Context, session models.Session) (createdSession models.Session, err error) {
+	row := ds.db.QueryRowContext(ctx,
+		`INSERT INTO sessions(user_id, refresh_token_hash, expires_at) VALUES ($1, $2, $3) RETURNING id`,
+		session.UserID, session.RefreshTokenHash, session.ExpiresAt)
+
+	err = row.Scan(&session.ID)
+	if err == nil {
+		createdSession = session
+	}
+	return
+}
+
+// RotateSession заменяет refresh-токен активной сессии; старый токен после этого недействителен.
+func (ds *DBStorage) RotateSession(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, expiresAt time.Time) (session models.Session, err error) {
+	row := ds.db.QueryRowContext(ctx,
+		`UPDATE sessions SET refresh_token_hash=$1, expires_at=$2 WHERE refresh_token_hash=$3 AND revoked_at IS NULL AND expires_at > now() RETURNING id, user_id`,
+		newRefreshTokenHash, expiresAt, refreshTokenHash)
+
+	err = row.Scan(&session.ID, &session.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = ErrNotFound
+		return
+	}
+	session.RefreshTokenHash = newRefreshTokenHash
+	session.ExpiresAt = expiresAt
+	return
+}
+
+func (ds *DBStorage) RevokeSession(ctx context.Context, sessionID string) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at=now() WHERE id=$1 AND revoked_at IS NULL`, sessionID)
+	return err
+}
+
+func (ds *DBStorage) RevokeUserSessions(ctx context.Context, userID string) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at=now() WHERE user_id=$1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+func (ds *DBStorage) IsSessionActive(ctx context.Context, sessionID string) (active bool, err error) {
+	row := ds.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM sessions WHERE id=$1 AND revoked_at IS NULL AND expires_at > now())`, sessionID)
+	err = row.Scan(&active)
+	return
+}
@@ -3,21 +3,59 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/pagination"
 )
 
-var ErrAlreadyExists = errors.New("already exists")
+var (
+	ErrAlreadyExists     = errors.New("already exists")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrNotFound          = errors.New("not found")
+	// ErrOrderConflict — номер заказа уже загружен другим пользователем.
+	ErrOrderConflict = errors.New("order uploaded by another user")
+)
 
 type Repository interface {
 	CreateUser(ctx context.Context, user models.User) (models.User, error)
 	AuthorizeUser(ctx context.Context, login string) (models.User, error)
 	RegisterOrder(ctx context.Context, orderNumber string) (models.Order, error)
-	GetUsersOrders(ctx context.Context) ([]models.Order, error)
-	GetUsersBalance(ctx context.Context) (float64, error)
-	GetUsersWithdraw(ctx context.Context) (float64, error)
-	CreateWithdrawal(ctx context.Context, withdrawal models.Withdrawal) (models.Withdrawal, error)
-	GetUsersWithdrawals(ctx context.Context) ([]models.Withdrawal, error)
-	GetAllOrdersIDs(ctx context.Context) ([]string, error)
+	ListUsersOrders(ctx context.Context, query pagination.Query) ([]models.Order, *pagination.Cursor, error)
+	GetUsersOrder(ctx context.Context, orderNumber string) (models.Order, error)
+	GetBalance(ctx context.Context) (models.Balance, error)
+	Withdraw(ctx context.Context, userID string, orderNumber string, sum models.Money) (models.Withdrawal, error)
+	ListUsersWithdrawals(ctx context.Context, query pagination.Query) ([]models.Withdrawal, *pagination.Cursor, error)
+	ClaimAccrualJobs(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualJob, error)
+	RescheduleAccrualJob(ctx context.Context, orderNumber string, delay time.Duration) error
 	UpdateOrder(ctx context.Context, order models.Order) (models.Order, error)
+	MarkAccrualChecked(ctx context.Context, orderNumber string) error
+	CountOrdersByStatus(ctx context.Context, statuses ...string) (map[string]int64, error)
+	ReserveIdempotencyKey(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) (models.IdempotencyRecord, error)
+	SaveIdempotencyResponse(ctx context.Context, record models.IdempotencyRecord, ttl time.Duration) error
+	DeleteIdempotencyKey(ctx context.Context, userID string, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error)
+	CreateSession(ctx context.Context, session models.Session) (models.Session, error)
+	RotateSession(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, expiresAt time.Time) (models.Session, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+	CheckLogin(ctx context.Context, scope string, key string, policy models.LoginPolicy) (models.LoginAttempt, error)
+	AttemptLogin(ctx context.Context, scope string, key string, policy models.LoginPolicy) (models.LoginAttempt, error)
+	ResetLoginFailures(ctx context.Context, scope string, key string) error
+	UnlockLogin(ctx context.Context, scope string, key string, unlockedBy string) error
+	GetLoginLockouts(ctx context.Context, limit int) ([]models.LoginLockout, error)
+	GetUserEvents(ctx context.Context, userID string, afterID int64, limit int) ([]models.UserEvent, error)
+	GetLastUserEventID(ctx context.Context, userID string) (int64, error)
+	DeleteExpiredUserEvents(ctx context.Context, retention time.Duration) (int64, error)
+	ListenUserEvents(ctx context.Context, notify func(userID string)) error
+	CreateWebhookEndpoint(ctx context.Context, endpoint models.WebhookEndpoint) (models.WebhookEndpoint, error)
+	GetWebhookEndpoints(ctx context.Context, userID string) ([]models.WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, userID string, endpointID string) error
+	GetWebhookDeliveries(ctx context.Context, userID string, endpointID string, limit int) ([]models.WebhookDelivery, error)
+	RedeliverWebhook(ctx context.Context, userID string, endpointID string, deliveryID string) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	CompleteWebhookDelivery(ctx context.Context, deliveryID string, statusCode int) error
+	RetryWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string, delay time.Duration) error
+	DeadLetterWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string) error
 }
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// userEventsChannel — канал NOTIFY, в который триггеры user_events пишут идентификатор пользователя.
+const userEventsChannel = "user_events"
+
+// lockUserEvents берёт блокировку, под которой выдаются идентификаторы событий пользователя. Транзакция, которая
+// затем заблокирует баланс, берёт её заранее: иначе триггер события ждал бы её, уже держа balances.
+func lockUserEvents(ctx context.Context, tx dbTx, userID string) error {
+	_, err := tx.ExecContext(ctx, `SELECT lock_user_events($1)`, userID)
+	return err
+}
+
+// DeleteExpiredUserEvents удаляет события старше retention и возвращает их количество.
+func (ds *DBStorage) DeleteExpiredUserEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	result, err := ds.db.ExecContext(ctx,
+		`DELETE FROM user_events WHERE created_at < now() - $1 * interval '1 millisecond'`, retention.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (ds *DBStorage) GetUserEvents(ctx context.Context, userID string, afterID int64, limit int) (events []models.UserEvent, err error) {
+	rows, err := ds.db.QueryContext(ctx,
+		`SELECT id, event_type, payload, created_at FROM user_events WHERE user_id=$1 AND id > $2 ORDER BY id LIMIT $3`,
+		userID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events = make([]models.UserEvent, 0)
+	for rows.Next() {
+		event := models.UserEvent{UserID: userID}
+		var payload []byte
+		err = rows.Scan(&event.ID, &event.Type, &payload, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+	err = rows.Err()
+	return
+}
+
+func (ds *DBStorage) GetLastUserEventID(ctx context.Context, userID string) (id int64, err error) {
+	row := ds.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM user_events WHERE user_id=$1`, userID)
+	err = row.Scan(&id)
+	return
+}
+
+// ListenUserEvents держит отдельное соединение с LISTEN user_events и вызывает notify на каждое уведомление,
+// пока не отменён ctx или не оборвалось соединение.
+func (ds *DBStorage) ListenUserEvents(ctx context.Context, notify func(userID string)) error {
+	// Соединение с подпиской не возвращается в пул
+	return ds.db.withConn(ctx, func(conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, "LISTEN "+userEventsChannel)
+		for err == nil {
+			var notification *pgconn.Notification
+			notification, err = conn.WaitForNotification(ctx)
+			if err == nil {
+				notify(notification.Payload)
+			}
+		}
+		return err
+	})
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/models"
+)
+
+// webhookOwner — владелец эндпоинта: NULL у эндпоинтов администратора.
+func webhookOwner(userID string) sql.NullString {
+	return sql.NullString{String: userID, Valid: userID != ""}
+}
+
+// enqueueWebhooks ставит событие в очередь доставки всем подписанным эндпоинтам пользователя и администратора.
+// Вызывается в транзакции изменения, поэтому событие не теряется и не появляется без самого изменения.
+func enqueueWebhooks(ctx context.Context, tx dbTx, userID string, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO webhook_deliveries(endpoint_id, event_type, payload)
+		SELECT id, $1, $2 FROM webhook_endpoints WHERE (user_id=$3 OR user_id IS NULL) AND $1 = ANY(events)`,
+		eventType, payload, userID)
+	return err
+}
+
+func (ds *DBStorage) CreateWebhookEndpoint(ctx context.Context, endpoint models.WebhookEndpoint) (models.WebhookEndpoint, error) {
+	row := ds.db.QueryRowContext(ctx,
+		`INSERT INTO webhook_endpoints(user_id, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		webhookOwner(endpoint.UserID), endpoint.URL, endpoint.Secret, endpoint.Events)
+	err := row.Scan(&endpoint.ID, &endpoint.CreatedAt)
+	return endpoint, err
+}
+
+func (ds *DBStorage) GetWebhookEndpoints(ctx context.Context, userID string) (endpoints []models.WebhookEndpoint, err error) {
+	rows, err := ds.db.QueryContext(ctx,
+		`SELECT id, url, secret, events, created_at FROM webhook_endpoints WHERE user_id IS NOT DISTINCT FROM $1 ORDER BY created_at`,
+		webhookOwner(userID))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scanArray := ds.db.arrayScanner()
+	endpoints = make([]models.WebhookEndpoint, 0)
+	for rows.Next() {
+		endpoint := models.WebhookEndpoint{UserID: userID}
+		err = rows.Scan(&endpoint.ID, &endpoint.URL, &endpoint.Secret, scanArray(&endpoint.Events), &endpoint.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	err = rows.Err()
+	return
+}
+
+func (ds *DBStorage) DeleteWebhookEndpoint(ctx context.Context, userID string, endpointID string) error {
+	result, err := ds.db.ExecContext(ctx,
+		`DELETE FROM webhook_endpoints WHERE id=$1 AND user_id IS NOT DISTINCT FROM $2`, endpointID, webhookOwner(userID))
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err == nil && deleted == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// GetWebhookDeliveries возвращает журнал доставок эндпоинта, новые первыми. Чужой эндпоинт неотличим от отсутствующего.
+func (ds *DBStorage) GetWebhookDeliveries(ctx context.Context, userID string, endpointID string, limit int) (deliveries []models.WebhookDelivery, err error) {
+	var exists bool
+	row := ds.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM webhook_endpoints WHERE id=$1 AND user_id IS NOT DISTINCT FROM $2)`, endpointID, webhookOwner(userID))
+	if err = row.Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := ds.db.QueryContext(ctx,
+		`SELECT id, event_type, payload, status, attempts, last_status_code, last_error, next_attempt_at, created_at, delivered_at
+		FROM webhook_deliveries WHERE endpoint_id=$1 ORDER BY created_at DESC LIMIT $2`, endpointID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries = make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := models.WebhookDelivery{EndpointID: endpointID}
+		var payload []byte
+		var statusCode sql.NullInt64
+		var lastError sql.NullString
+		var deliveredAt sql.NullTime
+		err = rows.Scan(&delivery.ID, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts,
+			&statusCode, &lastError, &delivery.NextAttemptAt, &delivery.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		delivery.LastStatusCode = int(statusCode.Int64)
+		delivery.LastError = lastError.String
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	return
+}
+
+// RedeliverWebhook возвращает доставку в очередь с нулевым счётчиком попыток, в том числе из состояния DEAD.
+func (ds *DBStorage) RedeliverWebhook(ctx context.Context, userID string, endpointID string, deliveryID string) error {
+	result, err := ds.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries d SET status='PENDING', attempts=0, next_attempt_at=now(), locked_until=NULL
+		FROM webhook_endpoints e
+		WHERE d.id=$1 AND d.endpoint_id=$2 AND e.id=d.endpoint_id AND e.user_id IS NOT DISTINCT FROM $3`,
+		deliveryID, endpointID, webhookOwner(userID))
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err == nil && updated == 0 {
+		err = ErrNotFound
+	}
+	return err
+}
+
+// ClaimWebhookDeliveries забирает до limit доставок, готовых к отправке, и блокирует их на время lease.
+func (ds *DBStorage) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (deliveries []models.WebhookDelivery, err error) {
+	rows, err := ds.db.QueryContext(ctx,
+		`UPDATE webhook_deliveries d SET locked_until = now() + $1 * interval '1 millisecond', attempts = d.attempts + 1
+		FROM webhook_endpoints e
+		WHERE e.id = d.endpoint_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'PENDING' AND next_attempt_at <= now() AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED
+		) RETURNING d.id, d.endpoint_id, e.url, e.secret, d.event_type, d.payload, d.attempts, d.created_at`,
+		lease.Milliseconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries = make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		delivery := models.WebhookDelivery{Status: models.WebhookDeliveryPending}
+		var payload []byte
+		err = rows.Scan(&delivery.ID, &delivery.EndpointID, &delivery.URL, &delivery.Secret, &delivery.EventType, &payload, &delivery.Attempts, &delivery.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		delivery.Payload = payload
+		deliveries = append(deliveries, delivery)
+	}
+	err = rows.Err()
+	return
+}
+
+func (ds *DBStorage) CompleteWebhookDelivery(ctx context.Context, deliveryID string, statusCode int) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status='DELIVERED', delivered_at=now(), locked_until=NULL, last_status_code=$1, last_error=NULL WHERE id=$2`,
+		statusCode, deliveryID)
+	return err
+}
+
+// RetryWebhookDelivery записывает неудачную попытку и откладывает следующую на delay.
+func (ds *DBStorage) RetryWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string, delay time.Duration) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET next_attempt_at = now() + $1 * interval '1 millisecond', locked_until=NULL, last_status_code=$2, last_error=$3 WHERE id=$4`,
+		delay.Milliseconds(), sql.NullInt64{Int64: int64(statusCode), Valid: statusCode != 0}, message, deliveryID)
+	return err
+}
+
+// DeadLetterWebhookDelivery снимает доставку с очереди после исчерпания попыток; вернуть её можно через RedeliverWebhook.
+func (ds *DBStorage) DeadLetterWebhookDelivery(ctx context.Context, deliveryID string, statusCode int, message string) error {
+	_, err := ds.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status='DEAD', locked_until=NULL, last_status_code=$1, last_error=$2 WHERE id=$3`,
+		sql.NullInt64{Int64: int64(statusCode), Valid: statusCode != 0}, message, deliveryID)
+	return err
+}
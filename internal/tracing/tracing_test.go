@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func useRecorder(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := useRecorder(t)
+
+	r := chi.NewRouter()
+	r.Use(Middleware)
+	r.Get("/api/user/orders/{number}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", TraceParent(r.Context())[3:35], "Handler runs inside incoming trace")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/orders/12345678903", nil)
+	req.Header.Set("traceparent", traceParent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /api/user/orders/{number}", spans[0].Name(), "Span named by route pattern")
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String(), "Remote parent from traceparent")
+	assert.Contains(t, spans[0].Attributes(), semconv.HTTPResponseStatusCodeKey.Int(http.StatusServiceUnavailable))
+	assert.Equal(t, codes.Error, spans[0].Status().Code, "Server errors mark span as failed")
+}
+
+func TestTraceParent(t *testing.T) {
+	assert.Equal(t, "", TraceParent(context.Background()), "No trace without span")
+
+	ctx := ContextWithTraceParent(context.Background(), traceParent)
+	assert.Equal(t, traceParent, TraceParent(ctx), "Stored trace context restored")
+
+	header := http.Header{}
+	Inject(ctx, header)
+	assert.Equal(t, traceParent, header.Get("traceparent"), "traceparent propagated to outgoing request")
+
+	assert.Equal(t, context.Background(), ContextWithTraceParent(context.Background(), ""))
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), &config.Options{Tracing: config.TracingOptions{Exporter: ExporterNone}})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	shutdown, err = Setup(context.Background(), &config.Options{Tracing: config.TracingOptions{Exporter: ExporterStdout}})
+	require.NoError(t, err, "Stdout exporter works offline")
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), &config.Options{Tracing: config.TracingOptions{Exporter: "zipkin"}})
+	assert.ErrorIs(t, err, ErrUnknownExporter)
+}
+
+func TestQueryName(t *testing.T) {
+	assert.Equal(t, "db SELECT", queryName("\n\t\tselect id FROM orders WHERE number=$1"))
+	assert.Equal(t, "db", queryName(""))
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicAddress(t *testing.T) {
+	for _, addr := range []string{"8.8.8.8", "93.184.216.34", "2606:4700:4700::1111"} {
+		assert.True(t, IsPublicAddress(netip.MustParseAddr(addr)), addr)
+	}
+	for _, addr := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1",
+		"0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1", "::ffff:169.254.169.254", "224.0.0.1"} {
+		assert.False(t, IsPublicAddress(netip.MustParseAddr(addr)), addr)
+	}
+}
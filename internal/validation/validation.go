@@ -0,0 +1,273 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/PaBah/gofermart/internal/dto"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/problem"
+	"github.com/PaBah/gofermart/internal/utils"
+)
+
+// MaxBodySize ограничивает размер тела любого запроса к API.
+const MaxBodySize = 64 << 10
+
+const (
+	loginMinLength    = 3
+	loginMaxLength    = 64
+	passwordMinLength = 8
+	// bcrypt учитывает только первые 72 байта пароля
+	passwordMaxLength      = 72
+	webhookURLMaxLength    = 2048
+	webhookSecretMinLength = 16
+	webhookSecretMaxLength = 256
+)
+
+var (
+	ErrInvalidContentType = errors.New("invalid request content type")
+	ErrBodyTooLarge       = errors.New("request body too large")
+	ErrInvalidJSON        = errors.New("invalid JSON")
+	ErrInvalidOrderNumber = errors.New("order number fails Luhn check")
+
+	loginPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+)
+
+// Errors — список нарушенных правил; возвращается как ответ 422 с кодом validation_failed.
+type Errors []problem.FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *Errors) add(field string, code string, message string) {
+	*e = append(*e, problem.FieldError{Field: field, Code: code, Message: message})
+}
+
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// BadRequestErrors — нарушения в теле регистрации и входа; по спецификации на них отвечают 400, а не 422.
+type BadRequestErrors struct {
+	Errors
+}
+
+func (e Errors) badRequest() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return BadRequestErrors{Errors: e}
+}
+
+// LimitBody не даёт обработчикам прочитать больше limit байт тела запроса.
+func LimitBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func checkContentType(req *http.Request, expected string) error {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != expected {
+		return ErrInvalidContentType
+	}
+	return nil
+}
+
+// ReadBody читает тело запроса с типом contentType.
+func ReadBody(req *http.Request, contentType string) ([]byte, error) {
+	if err := checkContentType(req, contentType); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(req.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, ErrBodyTooLarge
+	}
+	return body, err
+}
+
+// DecodeJSON разбирает тело запроса application/json в dst.
+func DecodeJSON(req *http.Request, dst interface{}) error {
+	body, err := ReadBody(req, "application/json")
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(body, dst)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidJSON, err)
+	}
+	return nil
+}
+
+func validateLogin(errs *Errors, login string, strict bool) {
+	switch {
+	case login == "":
+		errs.add("login", "required", "login is required")
+	case !strict:
+	case len(login) < loginMinLength || len(login) > loginMaxLength:
+		errs.add("login", "length", fmt.Sprintf("login must be %d to %d characters long", loginMinLength, loginMaxLength))
+	case !loginPattern.MatchString(login):
+		errs.add("login", "format", "login may contain only latin letters, digits and . _ @ -")
+	}
+}
+
+func validatePassword(errs *Errors, password string, strict bool) {
+	if password == "" {
+		errs.add("password", "required", "password is required")
+		return
+	}
+	if len(password) > passwordMaxLength {
+		errs.add("password", "length", fmt.Sprintf("password must not exceed %d bytes", passwordMaxLength))
+		return
+	}
+	if !strict {
+		return
+	}
+	if len(password) < passwordMinLength {
+		errs.add("password", "length", fmt.Sprintf("password must be %d to %d bytes long", passwordMinLength, passwordMaxLength))
+		return
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		hasLetter = hasLetter || unicode.IsLetter(r)
+		hasDigit = hasDigit || unicode.IsDigit(r)
+	}
+	if !hasLetter || !hasDigit {
+		errs.add("password", "weak", "password must contain both letters and digits")
+	}
+}
+
+// RegisterUser проверяет данные новой учётной записи; правила для логина и сложности пароля применяются только при strict.
+func RegisterUser(request dto.RegisterUserRequest, strict bool) error {
+	errs := Errors{}
+	validateLogin(&errs, request.Login, strict)
+	validatePassword(&errs, request.Password, strict)
+	return errs.badRequest()
+}
+
+// LoginUser проверяет только наличие полей: правила для пароля могли измениться после регистрации.
+func LoginUser(request dto.LoginUserRequest) error {
+	errs := Errors{}
+	if request.Login == "" {
+		errs.add("login", "required", "login is required")
+	}
+	if request.Password == "" {
+		errs.add("password", "required", "password is required")
+	}
+	return errs.badRequest()
+}
+
+// OrderNumber проверяет номер заказа алгоритмом Луна.
+func OrderNumber(number string) error {
+	if utils.ValidateLuhn(number) != nil {
+		return ErrInvalidOrderNumber
+	}
+	return nil
+}
+
+func Withdrawal(request dto.WithdrawalRequest) error {
+	errs := Errors{}
+	if request.Number == "" {
+		errs.add("order", "required", "order is required")
+	} else if err := OrderNumber(request.Number); err != nil {
+		return err
+	}
+	if request.Sum <= 0 {
+		errs.add("sum", "positive", "sum must be greater than zero")
+	}
+	return errs.err()
+}
+
+// WebhookEndpoint проверяет регистрацию эндпоинта; вне dev-режима допускается только https на публичный адрес.
+// Имя хоста здесь не разрешается: адрес после разрешения проверяет диспетчер при каждой доставке.
+func WebhookEndpoint(request dto.WebhookEndpointRequest, dev bool) error {
+	errs := Errors{}
+	endpoint, err := url.Parse(request.URL)
+	switch {
+	case request.URL == "":
+		errs.add("url", "required", "url is required")
+	case err != nil || len(request.URL) > webhookURLMaxLength ||
+		(endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" || endpoint.User != nil:
+		errs.add("url", "format", fmt.Sprintf("url must be an absolute http(s) URL up to %d characters without credentials", webhookURLMaxLength))
+	case dev:
+	case endpoint.Scheme != "https":
+		errs.add("url", "https", "url must use https")
+	case !isPublicHost(endpoint.Hostname()):
+		errs.add("url", "private", "url must not point to a loopback, private or link-local address")
+	}
+
+	if request.Secret != "" && (len(request.Secret) < webhookSecretMinLength || len(request.Secret) > webhookSecretMaxLength) {
+		errs.add("secret", "length", fmt.Sprintf("secret must be %d to %d bytes long", webhookSecretMinLength, webhookSecretMaxLength))
+	}
+
+	if len(request.Events) == 0 {
+		errs.add("events", "required", "at least one event is required")
+	}
+	for _, event := range request.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			errs.add("events", "enum", "events must be one of: "+strings.Join(models.WebhookEvents, ", "))
+			break
+		}
+	}
+	return errs.err()
+}
+
+func isPublicHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	addr, err := netip.ParseAddr(host)
+	return err != nil || utils.IsPublicAddress(addr)
+}
+
+// WriteError отвечает problem+json на ошибку разбора или проверки запроса.
+func WriteError(res http.ResponseWriter, req *http.Request, err error) {
+	var badRequest BadRequestErrors
+	var errs Errors
+	switch {
+	case errors.As(err, &badRequest):
+		p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "Request validation failed")
+		p.Errors = badRequest.Errors
+		p.Write(res, req)
+	case errors.As(err, &errs):
+		p := problem.New(http.StatusUnprocessableEntity, problem.CodeValidationFailed, "Request validation failed")
+		p.Errors = errs
+		p.Write(res, req)
+	case errors.Is(err, ErrInvalidOrderNumber):
+		problem.Error(res, req, http.StatusUnprocessableEntity, problem.CodeInvalidOrderNumber, "Order number fails Luhn check")
+	case errors.Is(err, ErrInvalidContentType):
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidContentType, "Invalid request content type")
+	case errors.Is(err, ErrBodyTooLarge):
+		problem.Error(res, req, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, fmt.Sprintf("Request body must not exceed %d bytes", MaxBodySize))
+	case errors.Is(err, ErrInvalidJSON):
+		problem.Error(res, req, http.StatusBadRequest, problem.CodeInvalidJSON, err.Error())
+	default:
+		problem.Internal(res, req, err)
+	}
+}
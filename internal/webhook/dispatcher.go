@@ -0,0 +1,243 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/logger"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/PaBah/gofermart/internal/storage"
+	"github.com/PaBah/gofermart/internal/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	SignatureHeader = "X-Gophermart-Signature"
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+
+	retryBase           = 10 * time.Second
+	idleInterval        = time.Second
+	deliveriesPerWorker = 4
+	maxErrorLength      = 512
+)
+
+// ErrForbiddenEndpoint — эндпоинт без https или указывающий во внутреннюю сеть; такая доставка сразу уходит в dead letter.
+var ErrForbiddenEndpoint = errors.New("webhook endpoint is not allowed")
+
+// Event — тело запроса к эндпоинту. ID совпадает с заголовком X-Gophermart-Delivery и не меняется при повторах.
+type Event struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Sign возвращает значение X-Gophermart-Signature: HMAC-SHA256 от "timestamp.body" на секрете эндпоинта.
+// Метка времени в подписи позволяет получателю отбрасывать повторно отправленные старые запросы.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type Dispatcher struct {
+	options *config.Options
+	storage storage.Repository
+	client  *http.Client
+	now     func() time.Time
+}
+
+func NewDispatcher(options *config.Options, storage storage.Repository) Dispatcher {
+	client := &http.Client{
+		Timeout: options.Webhook.Timeout,
+		// Перенаправление считается неудачной доставкой: подпись выдана конкретному адресу
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if !options.Dev {
+		client.Transport = publicTransport()
+	}
+
+	return Dispatcher{
+		options: options,
+		storage: storage,
+		client:  client,
+		now:     time.Now,
+	}
+}
+
+// publicTransport проверяет адрес уже после разрешения имени, поэтому DNS-запись, сменённая после регистрации
+// эндпоинта, не позволит обратиться во внутреннюю сеть. Прокси не используется: он скрыл бы настоящий адрес.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !utils.IsPublicAddress(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s is not a public address", ErrForbiddenEndpoint, address)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// Run рассылает доставки из очереди пулом воркеров, пока не отменён ctx.
+func (d Dispatcher) Run(ctx context.Context) {
+	workers := d.options.Webhook.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	deliveries := make(chan models.WebhookDelivery)
+	var wg, batch sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range deliveries {
+				d.deliver(ctx, delivery)
+				batch.Done()
+			}
+		}()
+	}
+	defer func() {
+		close(deliveries)
+		wg.Wait()
+	}()
+
+	for {
+		claimed, err := d.storage.ClaimWebhookDeliveries(ctx, workers*deliveriesPerWorker, 2*d.options.Webhook.Timeout)
+		if err != nil && ctx.Err() == nil {
+			logger.Log().Error("can not claim webhook deliveries", zap.Error(err))
+		}
+
+		if len(claimed) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(idleInterval):
+			}
+			continue
+		}
+
+		for _, delivery := range claimed {
+			batch.Add(1)
+			select {
+			case <-ctx.Done():
+				batch.Done()
+				return
+			case deliveries <- delivery:
+			}
+		}
+		batch.Wait()
+	}
+}
+
+// retryDelay растёт экспоненциально с числом попыток доставки.
+func (d Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < d.options.Webhook.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.options.Webhook.MaxBackoff {
+		delay = d.options.Webhook.MaxBackoff
+	}
+	return delay
+}
+
+func (d Dispatcher) send(ctx context.Context, delivery models.WebhookDelivery) (statusCode int, err error) {
+	body, err := json.Marshal(Event{ID: delivery.ID, Event: delivery.EventType, CreatedAt: delivery.CreatedAt, Data: delivery.Payload})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if req.URL.Scheme != "https" && !d.options.Dev {
+		return 0, fmt.Errorf("%w: only https endpoints are allowed", ErrForbiddenEndpoint)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gophermart-webhooks")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, d.now(), body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+func (d Dispatcher) deliver(ctx context.Context, delivery models.WebhookDelivery) {
+	statusCode, err := d.send(ctx, delivery)
+
+	// Итог доставки записывается и при остановке сервиса
+	store := context.Background()
+	switch {
+	case err == nil:
+		err = d.storage.CompleteWebhookDelivery(store, delivery.ID, statusCode)
+	case ctx.Err() != nil:
+		err = d.storage.RetryWebhookDelivery(store, delivery.ID, 0, "delivery interrupted by shutdown", 0)
+	case errors.Is(err, ErrForbiddenEndpoint), delivery.Attempts >= d.options.Webhook.MaxAttempts:
+		logger.Log().Warn("webhook delivery moved to dead letter", zap.String("delivery", delivery.ID), zap.Error(err))
+		err = d.storage.DeadLetterWebhookDelivery(store, delivery.ID, statusCode, truncate(err.Error()))
+	default:
+		err = d.storage.RetryWebhookDelivery(store, delivery.ID, statusCode, truncate(err.Error()), d.retryDelay(delivery.Attempts))
+	}
+	if err != nil {
+		logger.Log().Error("can not save webhook delivery result id="+delivery.ID, zap.Error(err))
+	}
+}
+
+func truncate(message string) string {
+	if len(message) > maxErrorLength {
+		return message[:maxErrorLength]
+	}
+	return message
+}
+
+// NewSecret создаёт секрет подписи для эндпоинта, зарегистрированного без своего секрета.
+func NewSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(raw), nil
+}
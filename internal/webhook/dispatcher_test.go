@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaBah/gofermart/internal/config"
+	"github.com/PaBah/gofermart/internal/mock"
+	"github.com/PaBah/gofermart/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSign(t *testing.T) {
+	timestamp := time.Unix(1714557600, 0)
+	body := []byte(`{"id":"1"}`)
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1714557600." + string(body)))
+	assert.Equal(t, "t=1714557600,v1="+hex.EncodeToString(mac.Sum(nil)), Sign("secret", timestamp, body))
+	assert.NotEqual(t, Sign("secret", timestamp, body), Sign("other", timestamp, body), "Signature depends on secret")
+}
+
+func TestNewSecret(t *testing.T) {
+	first, err := NewSecret()
+	require.NoError(t, err)
+	second, _ := NewSecret()
+	assert.True(t, strings.HasPrefix(first, "whsec_"))
+	assert.NotEqual(t, first, second)
+}
+
+func TestDispatcher_RetryDelay(t *testing.T) {
+	d := NewDispatcher(&config.Options{Webhook: config.WebhookOptions{MaxBackoff: time.Minute}}, nil)
+
+	assert.Equal(t, retryBase, d.retryDelay(1))
+	assert.Equal(t, 4*retryBase, d.retryDelay(3))
+	assert.Equal(t, time.Minute, d.retryDelay(10), "Delay is capped")
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	var received *http.Request
+	var receivedBody []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	options := &config.Options{Dev: true, Webhook: config.WebhookOptions{MaxAttempts: 3, Timeout: time.Second, MaxBackoff: time.Hour}}
+	d := NewDispatcher(options, rm)
+	d.now = func() time.Time { return time.Unix(1714557600, 0) }
+
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	delivery := models.WebhookDelivery{ID: "delivery", URL: ts.URL + "/ok", Secret: "secret", EventType: models.WebhookEventOrderProcessed,
+		Payload: []byte(`{"number":"12345678903"}`), Attempts: 1, CreatedAt: createdAt}
+
+	rm.EXPECT().CompleteWebhookDelivery(gomock.Any(), "delivery", http.StatusOK).Return(nil)
+	d.deliver(context.Background(), delivery)
+	require.NotNil(t, received)
+	assert.Equal(t, models.WebhookEventOrderProcessed, received.Header.Get(EventHeader))
+	assert.Equal(t, "delivery", received.Header.Get(DeliveryHeader))
+	assert.Equal(t, Sign("secret", d.now(), receivedBody), received.Header.Get(SignatureHeader), "Receiver can verify the body")
+	var event Event
+	require.NoError(t, json.Unmarshal(receivedBody, &event))
+	assert.Equal(t, Event{ID: "delivery", Event: models.WebhookEventOrderProcessed, CreatedAt: createdAt, Data: []byte(`{"number":"12345678903"}`)}, event)
+
+	delivery.URL = ts.URL + "/fail"
+	rm.EXPECT().RetryWebhookDelivery(gomock.Any(), "delivery", http.StatusInternalServerError, "endpoint answered 500 Internal Server Error", retryBase).Return(nil)
+	d.deliver(context.Background(), delivery)
+
+	delivery.URL = ts.URL + "/redirect"
+	delivery.Attempts = 3
+	rm.EXPECT().DeadLetterWebhookDelivery(gomock.Any(), "delivery", http.StatusFound, "endpoint answered 302 Found").Return(nil)
+	d.deliver(context.Background(), delivery)
+}
+
+func TestDispatcher_DeliverForbiddenEndpoint(t *testing.T) {
+	received := false
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer ts.Close()
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	d := NewDispatcher(&config.Options{Webhook: config.WebhookOptions{MaxAttempts: 3, Timeout: time.Second, MaxBackoff: time.Hour}}, rm)
+
+	testCases := []struct {
+		name string
+		url  string
+	}{
+		{name: "loopback", url: ts.URL},
+		{name: "localhost", url: strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)},
+		{name: "cloud metadata", url: "https://169.254.169.254/latest/meta-data"},
+		{name: "private network", url: "https://10.0.0.1/hook"},
+		{name: "plain http", url: "http://partner.example/hook"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rm.EXPECT().DeadLetterWebhookDelivery(gomock.Any(), "delivery", 0, gomock.Any()).DoAndReturn(
+				func(ctx context.Context, deliveryID string, statusCode int, message string) error {
+					assert.Contains(t, message, ErrForbiddenEndpoint.Error())
+					return nil
+				})
+			d.deliver(context.Background(), models.WebhookDelivery{ID: "delivery", URL: tc.url, Secret: "secret", Payload: []byte(`{}`), Attempts: 1})
+		})
+	}
+	assert.False(t, received, "Request never reaches internal address")
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	ctrl := gomock.NewController(t)
+	rm := mock.NewMockRepository(ctrl)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gomock.InOrder(
+		rm.EXPECT().ClaimWebhookDeliveries(gomock.Any(), 4, 2*time.Second).Return([]models.WebhookDelivery{
+			{ID: "1", URL: ts.URL, Secret: "secret", EventType: models.WebhookEventWithdrawalCreated, Payload: []byte(`{}`), Attempts: 1},
+		}, nil),
+		rm.EXPECT().ClaimWebhookDeliveries(gomock.Any(), 4, 2*time.Second).DoAndReturn(
+			func(ctx context.Context, limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+				cancel()
+				return []models.WebhookDelivery{}, nil
+			}),
+	)
+	rm.EXPECT().CompleteWebhookDelivery(gomock.Any(), "1", http.StatusOK).Return(nil)
+
+	options := &config.Options{Dev: true, Webhook: config.WebhookOptions{Workers: 1, MaxAttempts: 3, Timeout: time.Second, MaxBackoff: time.Hour}}
+	done := make(chan struct{})
+	go func() {
+		NewDispatcher(options, rm).Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not stop after context cancel")
+	}
+}